@@ -0,0 +1,38 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+)
+
+// MIMEMsgpack 紧凑格式 云手机上报及获取号码时可选用msgpack代替JSON
+// 字段名与JSON一致
+const MIMEMsgpack = binding.MIMEMSGPACK
+
+// IsMsgpack Content-Type是否为msgpack
+func IsMsgpack(contentType string) bool {
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return contentType == binding.MIMEMSGPACK || contentType == binding.MIMEMSGPACK2
+}
+
+// AcceptsMsgpack Accept中是否包含msgpack
+func AcceptsMsgpack(c *gin.Context) bool {
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		if IsMsgpack(accept) {
+			return true
+		}
+	}
+	return false
+}
+
+// Render 按Accept协商返回格式 未指定msgpack时返回JSON
+func Render(c *gin.Context, code int, obj interface{}) {
+	if AcceptsMsgpack(c) {
+		c.Render(code, render.MsgPack{Data: obj})
+		return
+	}
+	c.JSON(code, obj)
+}
@@ -1,6 +1,14 @@
 package common
 
-import "testing"
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
 
 func TestUUID(t *testing.T) {
 	ids := map[string]struct{}{}
@@ -13,3 +21,63 @@ func TestUUID(t *testing.T) {
 		ids[id] = struct{}{}
 	}
 }
+
+func TestErrorCodes(t *testing.T) {
+	for code, e := range catalogue {
+		// 错误码前三位为HTTP状态码
+		if code != CodeOK && int(code)/100 != e.status {
+			t.Errorf("code %v maps to status %v", code, e.status)
+		}
+		if len(code.Message("en")) == 0 || len(code.Message("zh-CN")) == 0 {
+			t.Errorf("code %v has no message", code)
+		}
+	}
+	if msg := CodeJobNotFound.Message("zh-CN,zh;q=0.9"); msg != "任务不存在" {
+		t.Errorf("zh message: %v", msg)
+	}
+	if msg := Code(12345).Message(""); msg != CodeInternal.Message("") {
+		t.Errorf("unknown code message: %v", msg)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	write := func(env Envelope, err *Error) (*httptest.ResponseRecorder, string) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		Write(c, env, nil, err)
+		return w, w.Body.String()
+	}
+
+	w, body := write(EnvelopeJob, WrapError(CodeJobNotFound, errors.New("No Job Matched")))
+	if w.Code != http.StatusNotFound || !strings.Contains(body, `"code":40400`) || !strings.Contains(body, "No Job Matched") {
+		t.Errorf("job envelope: %v %v", w.Code, body)
+	}
+	// 内部错误不返回原因
+	w, body = write(EnvelopeJob, AsError(errors.New("dial tcp 10.0.0.1:3306")))
+	if w.Code != http.StatusInternalServerError || strings.Contains(body, "10.0.0.1") {
+		t.Errorf("internal error leaked: %v %v", w.Code, body)
+	}
+	w, body = write(EnvelopeResult, NewError(CodeWrongDevice, ""))
+	if w.Code != http.StatusOK || !strings.Contains(body, `"status":403`) || !strings.Contains(body, `"code":40300`) {
+		t.Errorf("result envelope: %v %v", w.Code, body)
+	}
+}
+
+func TestWebhookSign(t *testing.T) {
+	body := []byte(`{"id":"d1"}`)
+	want := "sha256=2a9610d838548d2bf7fa8ff2e5ea833bd076a152266b71f2e2bb3610f380289a"
+	if got := WebhookSign("1582000000", body, "secret"); got != want {
+		t.Fatalf("WebhookSign = %v, want %v", got, want)
+	}
+	if !ValidWebhookSign("1582000000", body, "secret", want) {
+		t.Fatal("valid sign rejected")
+	}
+	// 时间戳 请求体或密钥不同时校验失败
+	if ValidWebhookSign("1582000001", body, "secret", want) ||
+		ValidWebhookSign("1582000000", []byte(`{"id":"d2"}`), "secret", want) ||
+		ValidWebhookSign("1582000000", body, "other", want) {
+		t.Fatal("invalid sign accepted")
+	}
+}
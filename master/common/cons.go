@@ -5,6 +5,12 @@ const (
 	JobSavePrefix = "/greedy/jobs/save"
 	JobKillPrefix = "/greedy/jobs/kill"
 	JobLockPrefix = "/greedy/jobs/lock"
+
+	MasterRegPrefix      = "/greedy/masters/"
+	SchedulerElectPrefix = "/greedy/scheduler/leader"
+	WebhookPrefix        = "/greedy/webhooks/hooks/"
+	DeliveryPrefix       = "/greedy/webhooks/deliveries/"
+	NotifyRevisionKey    = "/greedy/webhooks/revision"
 )
 
 // .
@@ -19,9 +25,10 @@ type JobStatus int
 
 // job status
 const (
-	Pendding JobStatus = iota + 1 // 未执行
-	Running                       // 执行中
-	Done                          // 执行完成
+	Pendding  JobStatus = iota + 1 // 未执行
+	Running                        // 执行中
+	Done                           // 执行完成
+	Exhausted                      // 重试次数用尽 不再下发
 )
 
 // JobResult 任务执行结果
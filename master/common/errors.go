@@ -0,0 +1,131 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Code 错误码 数值稳定 客户端按错误码判断错误类型 不要依赖错误信息
+// 前三位为对应的HTTP状态码
+type Code int
+
+// 错误码
+const (
+	CodeOK                  Code = 0
+	CodeInvalidParameters   Code = 40000 // 查询参数错误
+	CodeInvalidBody         Code = 40001 // 请求体无法解析
+	CodeValidationFailed    Code = 40002 // 请求体字段校验失败
+	CodeUnknownPhones       Code = 40003 // 上报了未下发给该设备的号码
+	CodeWrongDevice         Code = 40300 // 上报的设备不是任务当前的执行者
+	CodeJobNotFound         Code = 40400 // 任务不存在
+	CodeNoJobMatched        Code = 40401 // 没有可执行的任务
+	CodeNoDataMatched       Code = 40402 // 任务没有待抓取的号码
+	CodeLockNotFound        Code = 40403 // 任务锁不存在
+	CodeWebhookNotFound     Code = 40404 // webhook不存在
+	CodeInvalidTransition   Code = 40900 // 任务当前状态不允许该操作
+	CodeLockConflict        Code = 40901 // 任务锁已被其他设备持有
+	CodeJobConflict         Code = 40902 // 任务被并发修改 可以重试
+	CodeBodyTooLarge        Code = 41300 // 请求体超过上限
+	CodeUnsupportedEncoding Code = 41500 // 不支持的Content-Encoding
+	CodeInternal            Code = 50000 // 内部错误 详细原因只记录日志
+)
+
+// catalogue 错误码对应的HTTP状态码及各语言的错误信息
+var catalogue = map[Code]struct {
+	status int
+	en, zh string
+}{
+	CodeOK:                  {http.StatusOK, "success", "成功"},
+	CodeInvalidParameters:   {http.StatusBadRequest, "invalid parameters", "参数错误"},
+	CodeInvalidBody:         {http.StatusBadRequest, "invalid request body", "请求体格式错误"},
+	CodeValidationFailed:    {http.StatusBadRequest, "request body validation failed", "请求体校验失败"},
+	CodeUnknownPhones:       {http.StatusBadRequest, "phones were not dispatched to this device", "号码不是下发给该设备的"},
+	CodeWrongDevice:         {http.StatusForbidden, "job is not assigned to this device", "任务不是由该设备执行的"},
+	CodeJobNotFound:         {http.StatusNotFound, "job not found", "任务不存在"},
+	CodeNoJobMatched:        {http.StatusNotFound, "no job matched", "没有可执行的任务"},
+	CodeNoDataMatched:       {http.StatusNotFound, "no data matched", "任务没有待抓取的号码"},
+	CodeLockNotFound:        {http.StatusNotFound, "lock not found", "任务锁不存在"},
+	CodeWebhookNotFound:     {http.StatusNotFound, "webhook not found", "webhook不存在"},
+	CodeInvalidTransition:   {http.StatusConflict, "job state does not allow this operation", "任务当前状态不允许该操作"},
+	CodeLockConflict:        {http.StatusConflict, "job is locked by another device", "任务已被其他设备锁定"},
+	CodeJobConflict:         {http.StatusConflict, "job was modified concurrently, please retry", "任务被并发修改 请重试"},
+	CodeBodyTooLarge:        {http.StatusRequestEntityTooLarge, "request body too large", "请求体过大"},
+	CodeUnsupportedEncoding: {http.StatusUnsupportedMediaType, "unsupported content encoding", "不支持的压缩格式"},
+	CodeInternal:            {http.StatusInternalServerError, "internal error", "内部错误"},
+}
+
+// Status 对应的HTTP状态码
+func (c Code) Status() int {
+	if e, ok := catalogue[c]; ok {
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Message 错误信息 lang以zh开头时返回中文 其余返回英文
+func (c Code) Message(lang string) string {
+	e, ok := catalogue[c]
+	if !ok {
+		e = catalogue[CodeInternal]
+	}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(lang)), "zh") {
+		return e.zh
+	}
+	return e.en
+}
+
+// FieldError 字段校验错误
+type FieldError struct {
+	Field string `json:"field"` // json路径 如job_detail[0].phone 为空时为整个请求体
+	Error string `json:"error"`
+}
+
+// Error 接口错误 Detail及Fields会返回给客户端 Err只记录日志
+type Error struct {
+	Code   Code
+	Detail string       // 补充信息 如出错的号码
+	Fields []FieldError // 校验失败的字段
+	Err    error        // 内部原因
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s", e.Code, e.Code.Message(""))
+	if len(e.Detail) != 0 {
+		msg += ": " + e.Detail
+	}
+	if e.Err != nil && (e.Err.Error() != e.Detail) {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Status 对应的HTTP状态码
+func (e *Error) Status() int {
+	return e.Code.Status()
+}
+
+// NewError .
+func NewError(code Code, detail string) *Error {
+	return &Error{Code: code, Detail: detail}
+}
+
+// WrapError 包装内部错误 4xx的错误原因作为Detail返回 5xx的只记录日志
+func WrapError(code Code, err error) *Error {
+	e := &Error{Code: code, Err: err}
+	if err != nil && code.Status() < http.StatusInternalServerError {
+		e.Detail = err.Error()
+	}
+	return e
+}
+
+// AsError 转换为接口错误 未知的错误按内部错误处理
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return WrapError(CodeInternal, err)
+}
@@ -2,141 +2,127 @@ package common
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/MolenZhang/log"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
-// 统一错误码
+// Envelope 接口的返回格式
+type Envelope int
+
 const (
-	InvalidParamters = "Invalid Parameters"
+	// EnvelopeJob 管理平台使用 成功时直接返回数据 失败时返回ErrorBody及对应的HTTP状态码
+	EnvelopeJob Envelope = iota
+	// EnvelopeResult 设备使用 HTTP状态码固定200 实际状态在responseHeader中
+	EnvelopeResult
+	// EnvelopeRaw 文件导出及事件流 成功时由handler直接写入 失败时按EnvelopeJob返回
+	EnvelopeRaw
 )
 
-// Result 统一返回字段
+// Result EnvelopeResult的返回字段
 type Result struct {
 	Header interface{} `json:"responseHeader"`
 	Data   interface{} `json:"response"`
 }
 
-// RespHeader .
+// RespHeader EnvelopeResult的返回头
 type RespHeader struct {
-	Status  int    `json:"status"`
-	Version string `json:"version"`
-	Time    int64  `json:"time"`
-	Msg     string `json:"msg"`
+	Status  int          `json:"status"`
+	Version string       `json:"version"`
+	Time    int64        `json:"time"`
+	Msg     string       `json:"msg"`
+	Code    Code         `json:"code"`
+	Detail  string       `json:"detail,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
 }
 
-// SetHeader set header resp
-func (r *Result) SetHeader(h interface{}) *Result {
-	r.Header = h
-	return r
+// ErrorBody EnvelopeJob及EnvelopeRaw出错时的返回
+type ErrorBody struct {
+	Code   Code         `json:"code"`
+	Error  string       `json:"error"`
+	Detail string       `json:"detail,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
 }
 
-// SetData set data resp
-func (r *Result) SetData(d interface{}) *Result {
-	r.Data = d
-	return r
+// Response 统一的返回 handler中defer Done
+type Response struct {
+	envelope Envelope
+	data     interface{}
+	err      *Error
+}
+
+// NewResponse .
+func NewResponse(env Envelope) *Response {
+	return &Response{envelope: env}
 }
 
-// SetStatus 设置状态
-func (r *RespHeader) SetStatus(status int) *RespHeader {
-	r.Status = status
+// SetData 成功时返回的数据
+func (r *Response) SetData(d interface{}) *Response {
+	r.data = d
 	return r
 }
 
-// SetMsg 设置返回错误信息
-func (r *RespHeader) SetMsg(s string) *RespHeader {
-	r.Msg = s
+// Fail 设置错误 非*Error的错误按内部错误处理
+func (r *Response) Fail(err error) *Response {
+	r.err = AsError(err)
 	return r
 }
 
-// Done 统一处理返回值
-func (r *Result) Done(c *gin.Context) {
+// Failed 是否已设置错误
+func (r *Response) Failed() bool {
+	return r.err != nil
+}
+
+// Done 统一处理返回值 handler中panic时返回内部错误
+func (r *Response) Done(c *gin.Context) {
 	if c == nil {
 		return
 	}
-
 	if err := recover(); err != nil {
 		log.Errorf("got panic: %v, url: %v", err, c.Request.URL.Path)
 		if c.Writer.Written() {
 			return
 		}
-		c.AbortWithStatus(http.StatusInternalServerError)
-	} else {
-		c.JSON(200, r)
+		r.err = NewError(CodeInternal, "")
 	}
+	Write(c, r.envelope, r.data, r.err)
 }
 
-// ResultJob Job得适配通用平台返回
-type ResultJob struct {
-	Data   interface{} `json:"data"`
-	Status int         `json:"-"`
-	ErrMsg ErrMsg      `json:"msg"`
-}
-
-// ErrMsg 错误信息
-type ErrMsg struct {
-	Msg map[string]string `json:"msg"`
-}
-
-// SetMsg .
-func (e *ErrMsg) SetMsg(msg string) *ErrMsg {
-	e.Msg = map[string]string{}
-	e.Msg["error"] = msg
-	return e
-}
-
-// GetMsg .
-func (e *ErrMsg) GetMsg() map[string]string {
-	return e.Msg
-}
-
-// SetMsg 出错时返回此信息
-func (rj *ResultJob) SetMsg(msg map[string]interface{}) *ResultJob {
-	rj.Data = msg
-	return rj
-}
-
-// SetData ,
-func (rj *ResultJob) SetData(d interface{}) *ResultJob {
-	rj.Data = d
-	return rj
-}
-
-// SetStatus .
-func (rj *ResultJob) SetStatus(status int) *ResultJob {
-	rj.Status = status
-	return rj
-}
-
-// GetStatus .
-func (rj *ResultJob) GetStatus() int {
-	return rj.Status
-}
-
-// GetJobData .
-func (rj *ResultJob) GetJobData() interface{} {
-	return rj.Data
-}
-
-// Done 处理job相关返回
-func (rj *ResultJob) Done(c *gin.Context) {
-	if c == nil {
-		return
+// Write 按返回格式写入 内部错误只记录日志 不返回给客户端
+func Write(c *gin.Context, env Envelope, data interface{}, err *Error) {
+	if err != nil {
+		if err.Status() >= http.StatusInternalServerError {
+			log.Error("[Response] Internal Error", zap.String("path", c.Request.URL.Path), zap.Error(err))
+		}
+		c.Abort()
 	}
-	if err := recover(); err != nil {
-		log.Errorf("got panic: %v, url: %v", err, c.Request.URL.Path)
-		if c.Writer.Written() {
-			return
+	if env == EnvelopeResult {
+		h := RespHeader{
+			Version: "1.0.0",
+			Time:    time.Now().Unix(),
+			Status:  http.StatusOK,
+			Msg:     CodeOK.Message(""),
+		}
+		if err != nil {
+			h.Status = err.Status()
+			h.Code = err.Code
+			h.Msg = err.Code.Message(c.GetHeader("Accept-Language"))
+			h.Detail = err.Detail
+			h.Fields = err.Fields
 		}
-		c.AbortWithStatus(http.StatusInternalServerError)
+		Render(c, http.StatusOK, Result{Header: h, Data: data})
 		return
 	}
-
-	switch rj.GetStatus() {
-	case http.StatusOK:
-		c.JSON(http.StatusOK, rj.GetJobData())
-	default:
-		c.JSON(rj.GetStatus(), rj.ErrMsg.GetMsg())
+	if err != nil {
+		Render(c, err.Status(), ErrorBody{
+			Code:   err.Code,
+			Error:  err.Code.Message(c.GetHeader("Accept-Language")),
+			Detail: err.Detail,
+			Fields: err.Fields,
+		})
+		return
 	}
+	Render(c, http.StatusOK, data)
 }
@@ -1,7 +1,9 @@
 package common
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"net/url"
 	"sort"
@@ -42,3 +44,17 @@ func GenerateSign(values url.Values, secKey string) string {
 	s.Write([]byte(stringToSign))
 	return hex.EncodeToString(s.Sum(nil))
 }
+
+// WebhookSign 生成webhook签名 sha256=hex(HMAC-SHA256(密钥, 时间戳.请求体))
+// 事件及投递ID都在请求体中 接收方用创建webhook时的密钥按相同规则校验
+func WebhookSign(timestamp string, body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidWebhookSign 校验webhook签名
+func ValidWebhookSign(timestamp string, body []byte, secret, sign string) bool {
+	return hmac.Equal([]byte(WebhookSign(timestamp, body, secret)), []byte(sign))
+}
@@ -1,30 +1,62 @@
 package config
 
+import "time"
+
 // Config 配置相关
 type Config struct {
-	Etcd  EtcdConfig
-	Mongo MongoConfig
-	Mysql MysqlConfig
+	Server    ServerConfig
+	Etcd      EtcdConfig
+	Mongo     MongoConfig
+	Mysql     MysqlConfig
+	Match     MatchConfig
+	Simulator SimulatorConfig
+}
+
+// ServerConfig .
+type ServerConfig struct {
+	Addr            string
+	GRPCAddr        string        // worker使用的gRPC服务 为空时不启动
+	CheckTimeout    time.Duration // 就绪检查时单个依赖的超时时间
+	ShutdownTimeout time.Duration // 退出时等待请求及异步写入完成的截止时间
+	RegisterTTL     int64         // 节点注册租约 单位秒
+	MaxBodyBytes    int64         // 请求体大小上限 gzip请求按解压后的大小计算
+	AttemptTimeout  time.Duration // 执行期限 设备在期限内没有追加上报或心跳(/greedy/data/heartbeat)时回收任务
 }
 
 // EtcdConfig .
 type EtcdConfig struct {
-	Addrs []string
+	Addrs   []string
+	LockTTL int64 // 任务锁租约 单位秒
+}
+
+// MatchConfig 任务匹配策略 random/priority/fair/fifo
+type MatchConfig struct {
+	Strategy    string         // 默认策略
+	ByCrawlType map[int]string // 按执行方式覆盖默认策略
+}
+
+// SimulatorConfig 模拟器下发策略
+type SimulatorConfig struct {
+	BatchSize  int            // 每轮抓取的号码数
+	BatchSizes map[string]int // 按模拟器镜像覆盖每轮抓取的号码数
+	Interval   int            // 每轮抓取的间隔 单位秒
 }
 
 // MongoConfig .
 type MongoConfig struct {
-	Addrs []string
-	User  string
-	Pwd   string
+	Addrs       []string
+	User        string
+	Pwd         string
+	DialTimeout time.Duration
 }
 
 // MysqlConfig .
 type MysqlConfig struct {
-	User     string
-	Pwd      string
-	Addr     string
-	Database string
+	User        string
+	Pwd         string
+	Addr        string
+	Database    string
+	DialTimeout time.Duration
 }
 
 // Cfg .
@@ -33,29 +65,52 @@ var Cfg Config
 // NewConfig .
 func NewConfig() *Config {
 	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8585",
+			GRPCAddr:        ":8586",
+			CheckTimeout:    time.Second * 3,
+			ShutdownTimeout: time.Second * 20,
+			RegisterTTL:     10,
+			MaxBodyBytes:    8 << 20,
+			AttemptTimeout:  time.Minute * 10,
+		},
 		Etcd: EtcdConfig{
-			Addrs: []string{},
+			Addrs:   []string{},
+			LockTTL: 5,
 		},
 		Mongo: MongoConfig{
 			Addrs: []string{},
 		},
+		Match: MatchConfig{
+			Strategy:    "random",
+			ByCrawlType: map[int]string{},
+		},
+		Simulator: SimulatorConfig{
+			BatchSize:  20,
+			BatchSizes: map[string]int{},
+			Interval:   5,
+		},
 	}
 }
 
 // InitConfig .
 func InitConfig() {
 	cfg := NewConfig()
+	// 仅仅适配云手机
+	cfg.Etcd.Addrs = []string{"10.99.91.62:8378"}
 	cfg.Mongo = MongoConfig{
-		Addrs: []string{"10.99.91.62:8378"},
-		User:  "hm_w",
-		Pwd:   "hm4mongo",
+		Addrs:       []string{"10.99.91.62:8378"},
+		User:        "hm_w",
+		Pwd:         "hm4mongo",
+		DialTimeout: time.Second * 10,
 	}
 	cfg.Mongo.Addrs = []string{"10.12.5.164:27017", "10.12.5.164:27017"}
 	cfg.Mysql = MysqlConfig{
-		User:     "rdswr",
-		Pwd:      "rdswr2018",
-		Addr:     "10.14.122.12",
-		Database: "greedy",
+		User:        "rdswr",
+		Pwd:         "rdswr2018",
+		Addr:        "10.14.122.12",
+		Database:    "greedy",
+		DialTimeout: time.Second * 10,
 	}
 	Cfg = *cfg
 }
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+
+	"github.com/MolenZhang/log"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"go.uber.org/zap"
+)
+
+// jobEventPrefix 任务保存及强杀的公共前缀
+const jobEventPrefix = "/greedy/jobs/"
+
+// JobWatch 通过watch任务前缀订阅任务变更
+// rev已被压缩时从压缩后最早的版本继续 期间的事件无法补发
+func (JobMgr *JobManager) JobWatch(ctx context.Context, rev int64) <-chan storage.JobEvent {
+	events := make(chan storage.JobEvent, 64)
+	go func() {
+		defer close(events)
+		for {
+			compacted := JobMgr.watchJobs(ctx, rev, events)
+			if compacted == 0 {
+				return
+			}
+			log.Warnf("[JobWatch] Revision %v Compacted, Resume From %v", rev, compacted)
+			rev = compacted
+		}
+	}()
+	return events
+}
+
+// watchJobs 推送任务事件直到watch结束 rev已被压缩时返回压缩后最早的版本
+func (JobMgr *JobManager) watchJobs(ctx context.Context, rev int64, events chan<- storage.JobEvent) int64 {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithPrevKV()}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev))
+	}
+	for wresp := range JobMgr.watcher.Watch(ctx, jobEventPrefix, opts...) {
+		if wresp.CompactRevision != 0 {
+			return wresp.CompactRevision
+		}
+		if err := wresp.Err(); err != nil {
+			metrics.StorageError(metrics.BackendEtcd, "watch", err)
+			log.Error("[JobWatch] Watch Error", zap.Error(err))
+			return 0
+		}
+		for _, ev := range wresp.Events {
+			e, ok := JobMgr.jobEvent(ctx, ev)
+			if !ok {
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return 0
+			}
+		}
+	}
+	return 0
+}
+
+// jobEvent 转换etcd事件 锁等其他key返回false
+func (JobMgr *JobManager) jobEvent(ctx context.Context, ev *clientv3.Event) (e storage.JobEvent, ok bool) {
+	key := string(ev.Kv.Key)
+	e.Revision = ev.Kv.ModRevision
+	e.At = time.Now().Format(TimeLayout)
+	switch {
+	case strings.HasPrefix(key, common.JobSavePrefix):
+		prev := decodeJob(ev.PrevKv)
+		if ev.Type == mvccpb.DELETE {
+			if prev == nil {
+				return e, false
+			}
+			e.Type, e.Job = storage.EventDeleted, *prev
+			return e, true
+		}
+		cur := decodeJob(ev.Kv)
+		if cur == nil {
+			return e, false
+		}
+		e.Type, e.Job = storage.ClassifyJobEvent(prev, *cur), *cur
+		return e, true
+	case strings.HasPrefix(key, common.JobKillPrefix):
+		// 强杀的key随租约过期删除 只关注写入
+		if ev.Type != mvccpb.PUT {
+			return e, false
+		}
+		name := strings.TrimPrefix(key, common.JobKillPrefix)
+		e.Type, e.Job = storage.EventKilled, etcd.JobEtcd{Name: name}
+		// 读取强杀时刻的任务 避免任务随后被删除
+		resp, err := JobMgr.kv.Get(ctx, common.JobSavePrefix+name, clientv3.WithRev(ev.Kv.ModRevision))
+		if err == nil && len(resp.Kvs) != 0 {
+			if job := decodeJob(resp.Kvs[0]); job != nil {
+				e.Job = *job
+			}
+		}
+		return e, true
+	}
+	return e, false
+}
+
+func decodeJob(kv *mvccpb.KeyValue) *etcd.JobEtcd {
+	if kv == nil || len(kv.Value) == 0 {
+		return nil
+	}
+	job := etcd.JobEtcd{}
+	if err := json.Unmarshal(kv.Value, &job); err != nil {
+		log.Error("[JobWatch] Unmarshal Job Error", zap.Error(err), zap.String("key", string(kv.Key)))
+		return nil
+	}
+	return &job
+}
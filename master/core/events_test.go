@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+func TestJobWatch(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := mgr.JobWatch(ctx, 0)
+
+	job := etcd.JobEtcd{ID: "id-1", Name: "job-1", Status: int(common.Pendding)}
+	mgr.JobSave(job)
+	job.Status = int(common.Running)
+	mgr.JobSave(job)
+	job.Status = int(common.Done)
+	mgr.JobSave(job)
+	// 锁的变更不产生事件
+	l := mgr.JobLockMade("job-1", testHolder)
+	l.TryLock(context.Background())
+	l.UnLock()
+	mgr.JobKill("job-1")
+	mgr.JobDelete("job-1")
+
+	want := []string{
+		storage.EventCreated,
+		storage.EventDispatched,
+		storage.EventReported,
+		storage.EventKilled,
+		storage.EventDeleted,
+	}
+	var first int64
+	for i, typ := range want {
+		select {
+		case e := <-events:
+			if e.Type != typ || e.Job.ID != "id-1" {
+				t.Fatalf("event %v = %v %+v, want %v", i, e.Type, e.Job, typ)
+			}
+			if i == 0 {
+				first = e.Revision
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for event %v", typ)
+		}
+	}
+
+	// 从指定版本重新订阅
+	replay := mgr.JobWatch(ctx, first+1)
+	select {
+	case e := <-replay:
+		if e.Type != storage.EventDispatched {
+			t.Fatalf("replayed event = %v, want %v", e.Type, storage.EventDispatched)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for replayed event")
+	}
+
+	// 版本已被压缩时从压缩后的版本继续
+	resp, err := mgr.kv.Get(ctx, jobEventPrefix, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.kv.Compact(ctx, resp.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+	compacted := mgr.JobWatch(ctx, first+1)
+	mgr.JobSave(etcd.JobEtcd{ID: "id-2", Name: "job-2"})
+	for {
+		select {
+		case e := <-compacted:
+			if e.Revision < resp.Header.Revision {
+				t.Fatalf("event before compaction: %v %v", e.Type, e.Revision)
+			}
+			if e.Job.ID == "id-2" {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for event after compaction")
+		}
+	}
+}
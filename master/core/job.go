@@ -3,28 +3,42 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
 	"time"
 
 	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
 	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
 
 	"github.com/MolenZhang/log"
 	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 )
 
 // JobManager 任务管理器
 type JobManager struct {
-	client *clientv3.Client
-	kv     clientv3.KV
-	lease  clientv3.Lease
+	client  *clientv3.Client
+	kv      clientv3.KV
+	lease   clientv3.Lease
+	watcher clientv3.Watcher
+	lockTTL int64
+	locks   *lockSet
+	reg     *registration
 }
 
 // JobMgr .
 var JobMgr JobManager
 
+var (
+	_ storage.JobStore   = (*JobManager)(nil)
+	_ storage.LockStore  = (*JobManager)(nil)
+	_ storage.JobWatcher = (*JobManager)(nil)
+)
+
 // InitJobManager 初始化任务管理器
+// clientv3.New不保证集群可用 初始化后读取一次确保etcd可访问
 func InitJobManager(endpoints []string) error {
 	config := clientv3.Config{
 		Endpoints:   endpoints,
@@ -32,16 +46,49 @@ func InitJobManager(endpoints []string) error {
 	}
 	client, err := clientv3.New(config)
 	if err != nil {
-		return err
+		return fmt.Errorf("connect etcd %v: %v", endpoints, err)
 	}
-	JobMgr = JobManager{
-		client: client,
-		kv:     clientv3.NewKV(client),
-		lease:  clientv3.NewLease(client),
+	mgr := NewJobManager(client)
+	ctx, cancel := context.WithTimeout(context.Background(), config.DialTimeout)
+	defer cancel()
+	if err := mgr.Ping(ctx); err != nil {
+		client.Close()
+		return fmt.Errorf("connect etcd %v: %v", endpoints, err)
 	}
+	JobMgr = *mgr
 	return nil
 }
 
+// NewJobManager 基于已有的etcd客户端创建任务管理器
+func NewJobManager(client *clientv3.Client) *JobManager {
+	return &JobManager{
+		client:  client,
+		kv:      clientv3.NewKV(client),
+		lease:   clientv3.NewLease(client),
+		watcher: clientv3.NewWatcher(client),
+		lockTTL: DefaultLockTTL,
+		locks:   newLockSet(),
+		reg:     &registration{},
+	}
+}
+
+// SetLockTTL 设置任务锁的租约时长 单位秒
+func (JobMgr *JobManager) SetLockTTL(ttl int64) {
+	if ttl > 0 {
+		JobMgr.lockTTL = ttl
+	}
+}
+
+// Ping 检查etcd是否可用
+func (JobMgr *JobManager) Ping(ctx context.Context) error {
+	if JobMgr.kv == nil {
+		return fmt.Errorf("etcd is not initialized")
+	}
+	_, err := JobMgr.kv.Get(ctx, common.JobSavePrefix, clientv3.WithCountOnly())
+	metrics.StorageError(metrics.BackendEtcd, "ping", err)
+	return err
+}
+
 // JobSave .
 // 任务保存至/greedy/jobs/name
 func (JobMgr *JobManager) JobSave(job etcd.JobEtcd) (oldJob etcd.JobEtcd, err error) {
@@ -49,6 +96,7 @@ func (JobMgr *JobManager) JobSave(job etcd.JobEtcd) (oldJob etcd.JobEtcd, err er
 	value, _ := json.Marshal(job)
 	resp, err := JobMgr.kv.Put(context.TODO(), key, string(value), clientv3.WithPrevKV())
 	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "put", err)
 		return
 	}
 	// 更新操作的话 会返回旧的任务详情
@@ -62,11 +110,106 @@ func (JobMgr *JobManager) JobSave(job etcd.JobEtcd) (oldJob etcd.JobEtcd, err er
 	return
 }
 
+// JobSaveFenced 持有任务锁时保存任务
+// token为加锁时得到的fencing token 锁已被他人重新持有时拒绝写入
+func (JobMgr *JobManager) JobSaveFenced(job etcd.JobEtcd, token int64) (oldJob etcd.JobEtcd, err error) {
+	key := fmt.Sprintf("%s%s", common.JobSavePrefix, job.Name)
+	lockKey := common.JobLockPrefix + job.Name
+	value, _ := json.Marshal(job)
+	resp, err := JobMgr.kv.Txn(context.TODO()).
+		If(clientv3.Compare(clientv3.CreateRevision(lockKey), "=", token)).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithPrevKV())).
+		Commit()
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "txn", err)
+		return
+	}
+	if !resp.Succeeded {
+		err = storage.ErrLockFenced
+		return
+	}
+	if prev := resp.Responses[0].GetResponsePut().PrevKv; prev != nil {
+		oldJob = etcd.JobEtcd{}
+		err = json.Unmarshal(prev.Value, &oldJob)
+	}
+	return
+}
+
+// JobCreate 创建任务 key已存在时不覆盖
+func (JobMgr *JobManager) JobCreate(job etcd.JobEtcd) (err error) {
+	key := fmt.Sprintf("%s%s", common.JobSavePrefix, job.Name)
+	value, _ := json.Marshal(job)
+	resp, err := JobMgr.kv.Txn(context.TODO()).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "txn", err)
+		return
+	}
+	if !resp.Succeeded {
+		err = storage.ErrJobExists
+	}
+	return
+}
+
+// jobUpdateRetries JobUpdate并发冲突时的最大尝试次数
+const jobUpdateRetries = 5
+
+// JobUpdate 读取任务后修改并写回 写入时比较key的ModRevision
+// 期间被其他请求修改时基于最新的任务重新执行fn
+func (JobMgr *JobManager) JobUpdate(id string, fn func(job *etcd.JobEtcd) error) (job etcd.JobEtcd, err error) {
+	for i := 0; i < jobUpdateRetries; i++ {
+		var kv *mvccpb.KeyValue
+		if job, kv, err = JobMgr.jobKV(id); err != nil {
+			return
+		}
+		if err = fn(&job); err != nil {
+			return
+		}
+		value, _ := json.Marshal(job)
+		key := string(kv.Key)
+		resp, txnErr := JobMgr.kv.Txn(context.TODO()).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(key, string(value))).
+			Commit()
+		if txnErr != nil {
+			metrics.StorageError(metrics.BackendEtcd, "txn", txnErr)
+			err = txnErr
+			return
+		}
+		if resp.Succeeded {
+			return
+		}
+		log.Debugf("[JobUpdate] Job: %v Modified Concurrently, Retry", job.Name)
+	}
+	err = storage.ErrJobConflict
+	return
+}
+
+// jobKV 根据id获取任务及其key
+func (JobMgr *JobManager) jobKV(id string) (job etcd.JobEtcd, kv *mvccpb.KeyValue, err error) {
+	resp, err := JobMgr.kv.Get(context.TODO(), common.JobSavePrefix, clientv3.WithPrefix())
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "get", err)
+		return
+	}
+	for _, kv = range resp.Kvs {
+		job = etcd.JobEtcd{}
+		if json.Unmarshal(kv.Value, &job) == nil && job.ID == id {
+			return
+		}
+	}
+	err = storage.ErrJobNotFound
+	return
+}
+
 // JobDelete 任务删除
 func (JobMgr *JobManager) JobDelete(name string) (oldJob etcd.JobEtcd, err error) {
 	key := fmt.Sprintf("%s%s", common.JobSavePrefix, name)
 	resp, err := JobMgr.kv.Delete(context.TODO(), key, clientv3.WithPrevKV())
 	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "delete", err)
 		return
 	}
 
@@ -84,6 +227,7 @@ func (JobMgr *JobManager) JobDelete(name string) (oldJob etcd.JobEtcd, err error
 func (JobMgr *JobManager) JobLists() (jobs []etcd.JobEtcd, err error) {
 	resp, err := JobMgr.kv.Get(context.TODO(), common.JobSavePrefix, clientv3.WithPrefix())
 	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "get", err)
 		return
 	}
 	jobs = []etcd.JobEtcd{}
@@ -104,10 +248,12 @@ func (JobMgr *JobManager) JobKill(name string) (err error) {
 	key := fmt.Sprintf("%s%s", common.JobKillPrefix, name)
 	resp, err := JobMgr.lease.Grant(context.TODO(), 1)
 	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "grant", err)
 		return
 	}
 	leaseID := resp.ID
 	_, err = JobMgr.kv.Put(context.TODO(), key, "", clientv3.WithLease(leaseID))
+	metrics.StorageError(metrics.BackendEtcd, "put", err)
 	return
 }
 
@@ -118,48 +264,104 @@ func ParseToString(src interface{}) (string, error) {
 }
 
 // JobMatch 获取可执行的任务
-func (JobMgr *JobManager) JobMatch(crawlType int) (job etcd.JobEtcd, err error) {
-	jobs, err := JobMgr.JobLists()
+func (JobMgr *JobManager) JobMatch(crawlType int, filters ...JobFilter) (job etcd.JobEtcd, err error) {
+	return JobMatch(JobMgr, crawlType, filters...)
+}
+
+// ErrNoJobMatched 没有可执行的任务
+var ErrNoJobMatched = errors.New("No Job Matched")
+
+// JobFilter 执行方式特有的匹配条件 返回false的任务不下发
+type JobFilter func(job etcd.JobEtcd) bool
+
+// JobMatch 从任务存储中获取可执行的任务
+func JobMatch(store storage.JobStore, crawlType int, filters ...JobFilter) (job etcd.JobEtcd, err error) {
+	jobs, err := store.JobLists()
 	if err != nil || len(jobs) == 0 {
 		log.Errorf("[JobMatch] No Job or Get Jobs error: %v", err)
+		if err == nil {
+			err = ErrNoJobMatched
+		}
 		return
 	}
-	// 顺序随机
-	jobs, err = JobMgr.FisherYates(jobs)
+	now := time.Now()
+	candidates := []etcd.JobEtcd{}
 	for _, v := range jobs {
 		// 校验是否符合当前执行方式
 		if v.CrawlType != crawlType {
 			continue
 		}
-		// 校验状态是在执行中
-		if v.Status == 2 {
+		// 周期任务的定义不直接执行
+		if len(v.Schedule) != 0 {
+			continue
+		}
+		// 只有未执行或失败后退避结束的任务可以下发
+		if !Eligible(v, now) {
 			continue
 		}
-		// 校验状态是否为执行完成且执行结果为执行成功
-		if v.Status == 3 && v.Result == 1 {
+		if !matchAll(v, filters) {
 			continue
 		}
-		job = v
+		candidates = append(candidates, v)
+	}
+	if len(candidates) == 0 {
+		err = ErrNoJobMatched
 		return
 	}
-	err = fmt.Errorf("No Job Matched")
+	// 按执行方式配置的策略选取
+	job = StrategyFor(crawlType).Pick(candidates)
 	return
 }
 
+func matchAll(job etcd.JobEtcd, filters []JobFilter) bool {
+	for _, f := range filters {
+		if !f(job) {
+			return false
+		}
+	}
+	return true
+}
+
 // JobLockMade 给任务造锁
-func (JobMgr *JobManager) JobLockMade(name string) *JobLock {
-	return NewJobLock(name, JobMgr.kv, JobMgr.lease)
+func (JobMgr *JobManager) JobLockMade(name string, holder storage.LockHolder) storage.Locker {
+	jobLock := NewJobLock(name, JobMgr.kv, JobMgr.lease, JobMgr.watcher)
+	jobLock.holder = holder
+	jobLock.ttl = JobMgr.lockTTL
+	jobLock.held = JobMgr.locks
+	return jobLock
+}
+
+// ReleaseLocks 释放当前进程持有的所有任务锁
+func (JobMgr *JobManager) ReleaseLocks(ctx context.Context) (err error) {
+	for _, l := range JobMgr.locks.list() {
+		if e := l.unlock(ctx); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Close 关闭etcd连接
+func (JobMgr *JobManager) Close() error {
+	if JobMgr.client == nil {
+		return nil
+	}
+	return JobMgr.client.Close()
 }
 
 // FisherYates 任务打乱
 func (JobMgr *JobManager) FisherYates(src []etcd.JobEtcd) ([]etcd.JobEtcd, error) {
+	return FisherYates(src)
+}
+
+// FisherYates 任务打乱
+func FisherYates(src []etcd.JobEtcd) ([]etcd.JobEtcd, error) {
 	if len(src) == 0 {
 		return nil, fmt.Errorf("Invalid parameters")
 	}
 	t := len(src)
-	rand.Seed(time.Now().Unix())
 	for i := t - 1; i > 0; i-- {
-		num := rand.Intn(i + 1)
+		num := randIntn(i + 1)
 		src[i], src[num] = src[num], src[i]
 	}
 	return src, nil
@@ -177,6 +379,6 @@ func (JobMgr *JobManager) JobGetWithID(id string) (job etcd.JobEtcd, err error)
 			return
 		}
 	}
-	err = fmt.Errorf("No Job Matched")
+	err = storage.ErrJobNotFound
 	return
 }
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+func TestJobSaveAndDelete(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	job := etcd.JobEtcd{ID: "id-1", Name: "job-1", CrawlType: 2, Status: int(common.Pendding)}
+	old, err := mgr.JobSave(job)
+	if err != nil {
+		t.Fatalf("JobSave: %v", err)
+	}
+	if old.ID != "" {
+		t.Fatalf("first save returned old job %+v", old)
+	}
+
+	job.Status = int(common.Running)
+	old, err = mgr.JobSave(job)
+	if err != nil {
+		t.Fatalf("JobSave: %v", err)
+	}
+	if old.Status != int(common.Pendding) {
+		t.Fatalf("update returned old status %v, want %v", old.Status, common.Pendding)
+	}
+
+	got, err := mgr.JobGetWithID("id-1")
+	if err != nil || got.Status != int(common.Running) {
+		t.Fatalf("JobGetWithID = %+v, %v", got, err)
+	}
+	// 同名任务已存在时JobCreate不覆盖
+	if err := mgr.JobCreate(etcd.JobEtcd{ID: "id-2", Name: "job-1"}); err != storage.ErrJobExists {
+		t.Fatalf("JobCreate existing = %v", err)
+	}
+	if got, _ := mgr.JobGetWithID("id-1"); got.Status != int(common.Running) {
+		t.Fatalf("job overwritten by JobCreate: %+v", got)
+	}
+
+	old, err = mgr.JobDelete("job-1")
+	if err != nil || old.ID != "id-1" {
+		t.Fatalf("JobDelete = %+v, %v", old, err)
+	}
+	if _, err := mgr.JobGetWithID("id-1"); err == nil {
+		t.Fatal("JobGetWithID after delete succeeded")
+	}
+	old, err = mgr.JobDelete("job-1")
+	if err != nil || old.ID != "" {
+		t.Fatalf("JobDelete missing job = %+v, %v", old, err)
+	}
+}
+
+func TestJobLists(t *testing.T) {
+	tests := []struct {
+		name  string
+		jobs  []string
+		count int
+	}{
+		{"empty", nil, 0},
+		{"single", []string{"a"}, 1},
+		{"multiple", []string{"a", "b", "c"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, done := newTestManager(t)
+			defer done()
+			for _, name := range tt.jobs {
+				if _, err := mgr.JobSave(etcd.JobEtcd{ID: "id-" + name, Name: name}); err != nil {
+					t.Fatalf("JobSave: %v", err)
+				}
+			}
+			jobs, err := mgr.JobLists()
+			if err != nil {
+				t.Fatalf("JobLists: %v", err)
+			}
+			if len(jobs) != tt.count {
+				t.Fatalf("JobLists returned %v jobs, want %v", len(jobs), tt.count)
+			}
+		})
+	}
+}
+
+func TestJobMatch(t *testing.T) {
+	pending := func(name string, crawlType int) etcd.JobEtcd {
+		return etcd.JobEtcd{ID: "id-" + name, Name: name, CrawlType: crawlType, Status: int(common.Pendding)}
+	}
+	withState := func(job etcd.JobEtcd, status common.JobStatus, result common.JobResult) etcd.JobEtcd {
+		job.Status = int(status)
+		job.Result = int(result)
+		return job
+	}
+
+	tests := []struct {
+		name      string
+		jobs      []etcd.JobEtcd
+		crawlType int
+		want      string // 为空表示无任务可执行
+	}{
+		{"no jobs", nil, 2, ""},
+		{"pending job", []etcd.JobEtcd{pending("a", 2)}, 2, "a"},
+		{"other crawl type", []etcd.JobEtcd{pending("a", 1)}, 2, ""},
+		{"running job", []etcd.JobEtcd{withState(pending("a", 2), common.Running, 0)}, 2, ""},
+		{"succeeded job", []etcd.JobEtcd{withState(pending("a", 2), common.Done, common.Successful)}, 2, ""},
+		{"failed job", []etcd.JobEtcd{withState(pending("a", 2), common.Done, common.Failed)}, 2, "a"},
+		{"only one eligible", []etcd.JobEtcd{
+			withState(pending("a", 2), common.Running, 0),
+			pending("b", 1),
+			pending("c", 2),
+		}, 2, "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, done := newTestManager(t)
+			defer done()
+			for _, job := range tt.jobs {
+				if _, err := mgr.JobSave(job); err != nil {
+					t.Fatalf("JobSave: %v", err)
+				}
+			}
+			job, err := mgr.JobMatch(tt.crawlType)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("JobMatch = %+v, want error", job)
+				}
+				return
+			}
+			if err != nil || job.Name != tt.want {
+				t.Fatalf("JobMatch = %+v, %v, want %v", job, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobUpdateConcurrent(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+	if _, err := mgr.JobSave(etcd.JobEtcd{ID: "id-1", Name: "job-1"}); err != nil {
+		t.Fatalf("JobSave: %v", err)
+	}
+
+	// 并发修改时每次修改都基于最新的任务 不会丢失
+	var wg sync.WaitGroup
+	var conflicts int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mgr.JobUpdate("id-1", func(job *etcd.JobEtcd) error {
+				job.Progress.Reported++
+				return nil
+			})
+			if err == storage.ErrJobConflict {
+				atomic.AddInt32(&conflicts, 1)
+			} else if err != nil {
+				t.Errorf("JobUpdate: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	got, _ := mgr.JobGetWithID("id-1")
+	if got.Progress.Reported+int(conflicts) != 10 {
+		t.Fatalf("reported = %v, conflicts = %v", got.Progress.Reported, conflicts)
+	}
+
+	// fn出错时不写入
+	errStop := errors.New("stop")
+	if _, err := mgr.JobUpdate("id-1", func(job *etcd.JobEtcd) error {
+		job.Progress.Reported = 0
+		return errStop
+	}); err != errStop {
+		t.Fatalf("JobUpdate error = %v", err)
+	}
+	if again, _ := mgr.JobGetWithID("id-1"); again.Progress.Reported != got.Progress.Reported {
+		t.Fatalf("job written after fn error: %+v", again.Progress)
+	}
+	if _, err := mgr.JobUpdate("missing", func(*etcd.JobEtcd) error { return nil }); err != storage.ErrJobNotFound {
+		t.Fatalf("JobUpdate missing job = %v", err)
+	}
+}
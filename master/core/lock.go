@@ -2,95 +2,259 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"sync"
+	"time"
 
 	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+
 	"github.com/MolenZhang/log"
 	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"go.uber.org/zap"
 )
 
+// DefaultLockTTL 锁的默认租约时长 单位秒
+const DefaultLockTTL int64 = 5
+
 // JobLock 构建分布式锁
+// 锁只避免多个节点同时为一个任务取数 下发完成即释放 执行期间不持有
+// 任务状态的变更(下发、上报、心跳及超时回收)均由JobUpdate比较版本写入
 type JobLock struct {
+	mu         sync.Mutex
 	kv         clientv3.KV
 	lease      clientv3.Lease
+	watcher    clientv3.Watcher
 	jobName    string
+	holder     storage.LockHolder // 锁的value 记录持有者
+	ttl        int64
 	cancelFunc context.CancelFunc
 	leaseID    clientv3.LeaseID
 	isLocked   bool
+	token      int64
+	held       *lockSet
+	done       chan struct{}
+}
+
+// lockSet 当前进程持有的锁 退出时统一释放
+type lockSet struct {
+	mu    sync.Mutex
+	locks map[*JobLock]struct{}
+}
+
+func newLockSet() *lockSet {
+	return &lockSet{locks: map[*JobLock]struct{}{}}
+}
+
+func (ls *lockSet) add(l *JobLock) {
+	if ls == nil {
+		return
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.locks[l] = struct{}{}
+}
+
+func (ls *lockSet) remove(l *JobLock) {
+	if ls == nil {
+		return
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	delete(ls.locks, l)
+}
+
+func (ls *lockSet) list() []*JobLock {
+	if ls == nil {
+		return nil
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	locks := make([]*JobLock, 0, len(ls.locks))
+	for l := range ls.locks {
+		locks = append(locks, l)
+	}
+	return locks
 }
 
 // NewJobLock 初始化锁
-func NewJobLock(jobName string, kv clientv3.KV, lease clientv3.Lease) *JobLock {
+func NewJobLock(jobName string, kv clientv3.KV, lease clientv3.Lease, watcher clientv3.Watcher) *JobLock {
 	return &JobLock{
 		kv:      kv,
 		lease:   lease,
+		watcher: watcher,
 		jobName: jobName,
+		ttl:     DefaultLockTTL,
+		done:    make(chan struct{}),
 	}
 }
 
-// TryLock 加锁
-func (jobLock *JobLock) TryLock() (err error) {
-	resp, err := jobLock.lease.Grant(context.TODO(), 5)
-	if err != nil {
-		return
+// Key 锁在etcd中的key
+func (jobLock *JobLock) Key() string {
+	return common.JobLockPrefix + jobLock.jobName
+}
+
+// TryLock 加锁 锁被占用时立即返回storage.ErrLockOccupied
+func (jobLock *JobLock) TryLock(ctx context.Context) (err error) {
+	_, err = jobLock.tryLock(ctx)
+	return
+}
+
+// Lock 阻塞加锁 锁被占用时watch锁的key 直到锁释放后重新抢锁或ctx结束
+func (jobLock *JobLock) Lock(ctx context.Context) error {
+	for {
+		rev, err := jobLock.tryLock(ctx)
+		if err != storage.ErrLockOccupied {
+			return err
+		}
+		if err := jobLock.waitRelease(ctx, rev); err != nil {
+			return err
+		}
 	}
-	ctx, cancelFunc := context.WithCancel(context.TODO())
-	leaseID := resp.ID
+}
 
-	keepRespChan, err := jobLock.lease.KeepAlive(ctx, leaseID)
+// waitRelease 等待锁的key被删除 rev为抢锁失败时的集群版本
+func (jobLock *JobLock) waitRelease(ctx context.Context, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := jobLock.watcher.Watch(wctx, jobLock.Key(), clientv3.WithRev(rev+1))
+	for wresp := range wch {
+		if err := wresp.Err(); err != nil {
+			metrics.StorageError(metrics.BackendEtcd, "watch", err)
+			return err
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == mvccpb.DELETE {
+				return nil
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// tryLock 抢锁 失败时返回当前集群版本 用于watch锁的释放
+func (jobLock *JobLock) tryLock(ctx context.Context) (rev int64, err error) {
+	resp, err := jobLock.lease.Grant(ctx, jobLock.ttl)
 	if err != nil {
-		cancelFunc()
+		metrics.LockFailures.WithLabelValues("grant").Inc()
+		metrics.StorageError(metrics.BackendEtcd, "grant", err)
 		return
 	}
-
-	go func() {
-		for {
-			select {
-			case keepResp := <-keepRespChan:
-				if keepResp == nil {
-					return
-				}
-			}
+	leaseID := resp.ID
+	// 抢锁失败时释放租约
+	release := func() {
+		if _, err := jobLock.lease.Revoke(context.Background(), leaseID); err != nil {
+			log.Error("[JobLock] Revoke Lease Error", zap.Error(err))
 		}
-	}()
+	}
 
 	// 创建事务txn
-	txn := jobLock.kv.Txn(context.TODO())
-	lockKey := common.JobLockPrefix + jobLock.jobName
+	txn := jobLock.kv.Txn(ctx)
+	lockKey := jobLock.Key()
+	holder := jobLock.holder
+	holder.AcquiredAt = time.Now().Format(TimeLayout)
+	value, _ := json.Marshal(holder)
 
 	// 事务抢锁
 	txn.If(clientv3.Compare(clientv3.
 		CreateRevision(lockKey), "=", 0)).
-		Then(clientv3.OpPut(lockKey, "", clientv3.WithLease(leaseID))).
+		Then(clientv3.OpPut(lockKey, string(value), clientv3.WithLease(leaseID))).
 		Else(clientv3.OpGet(lockKey))
 
 	txnResp, err := txn.Commit()
 	if err != nil {
-		cancelFunc()
+		release()
+		metrics.LockFailures.WithLabelValues("txn").Inc()
+		metrics.StorageError(metrics.BackendEtcd, "txn", err)
 		err = errors.New("Lock Failed, Transaction Commit Failed")
 		return
 	}
 
 	// 成功返回，失败释放租约
 	if !txnResp.Succeeded {
+		release()
+		metrics.LockFailures.WithLabelValues("occupied").Inc()
+		return txnResp.Header.Revision, storage.ErrLockOccupied
+	}
+
+	// 抢锁成功后自动续约 续约结束说明锁已释放或租约丢失
+	kctx, cancelFunc := context.WithCancel(context.Background())
+	keepRespChan, err := jobLock.lease.KeepAlive(kctx, leaseID)
+	if err != nil {
 		cancelFunc()
-		err = errors.New("Lock Failed, Lock Occupied")
+		release()
+		metrics.LockFailures.WithLabelValues("keepalive").Inc()
+		metrics.StorageError(metrics.BackendEtcd, "keepalive", err)
 		return
 	}
 
+	jobLock.mu.Lock()
 	jobLock.leaseID = leaseID
 	jobLock.cancelFunc = cancelFunc
 	jobLock.isLocked = true
+	// 新创建的key 其CreateRevision即为本次事务的版本
+	jobLock.token = txnResp.Header.Revision
+	done := make(chan struct{})
+	jobLock.done = done
+	jobLock.mu.Unlock()
+	jobLock.held.add(jobLock)
+
+	go func() {
+		for range keepRespChan {
+		}
+		jobLock.lost(leaseID, done)
+	}()
 	return
 }
 
+// lost 续约结束 通知持有者
+func (jobLock *JobLock) lost(leaseID clientv3.LeaseID, done chan struct{}) {
+	jobLock.mu.Lock()
+	defer jobLock.mu.Unlock()
+	if jobLock.isLocked && jobLock.leaseID == leaseID {
+		jobLock.isLocked = false
+		jobLock.held.remove(jobLock)
+		log.Warnf("[JobLock] Lease Lost, Job: %v", jobLock.jobName)
+		metrics.LockFailures.WithLabelValues("lost").Inc()
+	}
+	close(done)
+}
+
+// Done 租约丢失或主动解锁后关闭
+func (jobLock *JobLock) Done() <-chan struct{} {
+	jobLock.mu.Lock()
+	defer jobLock.mu.Unlock()
+	return jobLock.done
+}
+
+// Token fencing token 即锁的key的CreateRevision 未持有锁时为0
+func (jobLock *JobLock) Token() int64 {
+	jobLock.mu.Lock()
+	defer jobLock.mu.Unlock()
+	if !jobLock.isLocked {
+		return 0
+	}
+	return jobLock.token
+}
+
 // UnLock 解锁
 func (jobLock *JobLock) UnLock() {
+	jobLock.unlock(context.TODO())
+}
+
+func (jobLock *JobLock) unlock(ctx context.Context) (err error) {
+	jobLock.mu.Lock()
+	defer jobLock.mu.Unlock()
 	if jobLock.isLocked {
+		jobLock.isLocked = false
+		jobLock.held.remove(jobLock)
 		jobLock.cancelFunc() // 取消自动续约
-		if _, err := jobLock.lease.Revoke(context.TODO(), jobLock.leaseID); err != nil {
-			log.Error("解锁失败", zap.Error(err))
+		if _, err = jobLock.lease.Revoke(ctx, jobLock.leaseID); err != nil {
+			log.Error("[JobLock] UnLock Error", zap.Error(err), zap.String("job", jobLock.jobName))
 		} // 释放租约
 	}
+	return
 }
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+var testHolder = storage.LockHolder{WorkerID: "worker-1", IP: "127.0.0.1"}
+
+func TestJobLockContention(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	const workers = 10
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners []int
+		start   = make(chan struct{})
+	)
+	locks := make([]*JobLock, workers)
+	for i := 0; i < workers; i++ {
+		locks[i] = mgr.JobLockMade("job-1", testHolder).(*JobLock)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if err := locks[i].TryLock(context.Background()); err == nil {
+				mu.Lock()
+				winners = append(winners, i)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if len(winners) != 1 {
+		t.Fatalf("%v goroutines acquired the lock, want 1", len(winners))
+	}
+
+	// 其他任务的锁互不影响
+	other := mgr.JobLockMade("job-2", testHolder)
+	if err := other.TryLock(context.Background()); err != nil {
+		t.Fatalf("lock on another job: %v", err)
+	}
+	defer other.UnLock()
+
+	// 释放后可以再次抢到
+	locks[winners[0]].UnLock()
+	again := mgr.JobLockMade("job-1", testHolder)
+	if err := again.TryLock(context.Background()); err != nil {
+		t.Fatalf("lock after unlock: %v", err)
+	}
+	again.UnLock()
+}
+
+func TestJobLockReleasedWhenClientDies(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	// 另一个客户端持有锁后异常退出 不主动释放
+	client := newTestClient(t)
+	holder := NewJobManager(client)
+	if err := holder.JobLockMade("job-1", testHolder).TryLock(context.Background()); err != nil {
+		t.Fatalf("holder TryLock: %v", err)
+	}
+	client.Close()
+
+	if err := mgr.JobLockMade("job-1", testHolder).TryLock(context.Background()); err == nil {
+		t.Fatal("lock acquired before the holder's lease expired")
+	}
+
+	// 租约过期后锁自动释放
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		l := mgr.JobLockMade("job-1", testHolder)
+		err := l.TryLock(context.Background())
+		if err == nil {
+			l.UnLock()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lock still held after lease expiry: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func TestReleaseLocks(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	for _, name := range []string{"job-1", "job-2"} {
+		if err := mgr.JobLockMade(name, testHolder).TryLock(context.Background()); err != nil {
+			t.Fatalf("TryLock %v: %v", name, err)
+		}
+	}
+	if err := mgr.ReleaseLocks(context.Background()); err != nil {
+		t.Fatalf("ReleaseLocks: %v", err)
+	}
+	for _, name := range []string{"job-1", "job-2"} {
+		l := mgr.JobLockMade(name, testHolder)
+		if err := l.TryLock(context.Background()); err != nil {
+			t.Fatalf("TryLock %v after release: %v", name, err)
+		}
+		l.UnLock()
+	}
+}
+
+func TestJobLockWaits(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	holder := mgr.JobLockMade("job-1", testHolder)
+	if err := holder.Lock(context.Background()); err != nil {
+		t.Fatalf("holder Lock: %v", err)
+	}
+	if err := mgr.JobLockMade("job-1", testHolder).TryLock(context.Background()); err != storage.ErrLockOccupied {
+		t.Fatalf("TryLock on held lock = %v, want %v", err, storage.ErrLockOccupied)
+	}
+
+	// 等待超时
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := mgr.JobLockMade("job-1", testHolder).Lock(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Lock with deadline = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 持有者释放后等待者拿到锁 且token更大
+	waiter := mgr.JobLockMade("job-1", testHolder)
+	acquired := make(chan error, 1)
+	go func() { acquired <- waiter.Lock(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+	oldToken := holder.Token()
+	holder.UnLock()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("waiter Lock: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter not woken after unlock")
+	}
+	defer waiter.UnLock()
+	if waiter.Token() <= oldToken {
+		t.Fatalf("token %v not greater than previous %v", waiter.Token(), oldToken)
+	}
+	select {
+	case <-holder.Done():
+	default:
+		t.Fatal("Done not closed after UnLock")
+	}
+}
+
+func TestJobLockFencing(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	job := etcd.JobEtcd{ID: "id-1", Name: "job-1"}
+	if _, err := mgr.JobSave(job); err != nil {
+		t.Fatal(err)
+	}
+
+	// 持有者租约丢失 锁被他人重新持有
+	client := newTestClient(t)
+	stale := NewJobManager(client).JobLockMade("job-1", testHolder)
+	if err := stale.TryLock(context.Background()); err != nil {
+		t.Fatalf("stale TryLock: %v", err)
+	}
+	token := stale.Token()
+	if _, err := mgr.lease.Revoke(context.Background(), stale.(*JobLock).leaseID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stale.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done not closed after lease lost")
+	}
+	client.Close()
+
+	owner := mgr.JobLockMade("job-1", testHolder)
+	if err := owner.TryLock(context.Background()); err != nil {
+		t.Fatalf("owner TryLock: %v", err)
+	}
+	defer owner.UnLock()
+
+	job.Status = 2
+	if _, err := mgr.JobSaveFenced(job, token); err != storage.ErrLockFenced {
+		t.Fatalf("stale write = %v, want %v", err, storage.ErrLockFenced)
+	}
+	if _, err := mgr.JobSaveFenced(job, owner.Token()); err != nil {
+		t.Fatalf("owner write: %v", err)
+	}
+}
+
+func TestLockListsAndRevoke(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	l := mgr.JobLockMade("job-1", testHolder)
+	if err := l.TryLock(context.Background()); err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	locks, err := mgr.LockLists(context.Background())
+	if err != nil {
+		t.Fatalf("LockLists: %v", err)
+	}
+	if len(locks) != 1 {
+		t.Fatalf("locks = %+v, want 1", locks)
+	}
+	got := locks[0]
+	if got.Job != "job-1" || got.Holder.WorkerID != testHolder.WorkerID ||
+		got.Holder.IP != testHolder.IP || len(got.Holder.AcquiredAt) == 0 ||
+		got.Token != l.Token() || got.TTL <= 0 {
+		t.Fatalf("unexpected lock info: %+v", got)
+	}
+
+	if _, err := mgr.LockRevoke(context.Background(), "job-1"); err != nil {
+		t.Fatalf("LockRevoke: %v", err)
+	}
+	select {
+	case <-l.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("holder not notified after revoke")
+	}
+	if _, err := mgr.LockRevoke(context.Background(), "job-1"); err != storage.ErrLockNotFound {
+		t.Fatalf("revoke missing lock = %v, want %v", err, storage.ErrLockNotFound)
+	}
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+
+	"github.com/MolenZhang/log"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"go.uber.org/zap"
+)
+
+// LockLists 当前所有的任务锁
+func (JobMgr *JobManager) LockLists(ctx context.Context) (locks []storage.LockInfo, err error) {
+	resp, err := JobMgr.kv.Get(ctx, common.JobLockPrefix, clientv3.WithPrefix())
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "get", err)
+		return
+	}
+	locks = []storage.LockInfo{}
+	for _, kv := range resp.Kvs {
+		locks = append(locks, JobMgr.lockInfo(ctx, kv))
+	}
+	return
+}
+
+// LockRevoke 撤销锁持有者的租约 锁随之释放
+func (JobMgr *JobManager) LockRevoke(ctx context.Context, job string) (info storage.LockInfo, err error) {
+	resp, err := JobMgr.kv.Get(ctx, common.JobLockPrefix+job)
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "get", err)
+		return
+	}
+	if len(resp.Kvs) == 0 {
+		err = storage.ErrLockNotFound
+		return
+	}
+	kv := resp.Kvs[0]
+	info = JobMgr.lockInfo(ctx, kv)
+	if kv.Lease == 0 {
+		// 没有租约的锁只能直接删除
+		_, err = JobMgr.kv.Delete(ctx, string(kv.Key))
+		metrics.StorageError(metrics.BackendEtcd, "delete", err)
+		return
+	}
+	_, err = JobMgr.lease.Revoke(ctx, clientv3.LeaseID(kv.Lease))
+	metrics.StorageError(metrics.BackendEtcd, "revoke", err)
+	return
+}
+
+// lockInfo 解析锁的持有者及租约剩余时长
+func (JobMgr *JobManager) lockInfo(ctx context.Context, kv *mvccpb.KeyValue) storage.LockInfo {
+	info := storage.LockInfo{
+		Job:     strings.TrimPrefix(string(kv.Key), common.JobLockPrefix),
+		Token:   kv.CreateRevision,
+		LeaseID: kv.Lease,
+	}
+	// 旧版本的锁value为空
+	if len(kv.Value) != 0 {
+		if err := json.Unmarshal(kv.Value, &info.Holder); err != nil {
+			log.Error("[LockLists] Unmarshal Lock Holder Error", zap.Error(err),
+				zap.String("job", info.Job))
+		}
+	}
+	if kv.Lease != 0 {
+		ttl, err := JobMgr.lease.TimeToLive(ctx, clientv3.LeaseID(kv.Lease))
+		if err != nil {
+			metrics.StorageError(metrics.BackendEtcd, "ttl", err)
+		} else {
+			info.TTL = ttl.TTL
+		}
+	}
+	return info
+}
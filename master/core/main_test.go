@@ -0,0 +1,44 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/etcd/etcdtest"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// server 所有测试共用的嵌入式etcd
+var server *etcdtest.Server
+
+func TestMain(m *testing.M) {
+	s, err := etcdtest.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "start embedded etcd: %v\n", err)
+		os.Exit(1)
+	}
+	server = s
+	code := m.Run()
+	server.Stop()
+	os.Exit(code)
+}
+
+// newTestManager 清空任务相关的key后返回新的任务管理器 测试结束时需关闭
+func newTestManager(t *testing.T) (*JobManager, func()) {
+	client := newTestClient(t)
+	if _, err := client.Delete(context.Background(), "/greedy/", clientv3.WithPrefix()); err != nil {
+		t.Fatalf("clean etcd: %v", err)
+	}
+	return NewJobManager(client), func() { client.Close() }
+}
+
+func newTestClient(t *testing.T) *clientv3.Client {
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("connect embedded etcd: %v", err)
+	}
+	return client
+}
@@ -0,0 +1,328 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+
+	"github.com/MolenZhang/log"
+	"go.uber.org/zap"
+)
+
+// webhook请求头
+const (
+	HeaderHookEvent     = "X-Greedy-Event"
+	HeaderHookDelivery  = "X-Greedy-Delivery"
+	HeaderHookTimestamp = "X-Greedy-Timestamp"
+	HeaderHookSignature = "X-Greedy-Signature"
+)
+
+// 投递失败时的重试 默认2s起每次翻倍 共5次
+const (
+	DefaultDeliveryAttempts = 5
+	DefaultDeliveryBackoff  = 2 * time.Second
+	DefaultDeliveryTimeout  = 10 * time.Second
+)
+
+// WebhookPayload 投递的请求体
+type WebhookPayload struct {
+	ID    string       `json:"id"` // 投递ID 与请求头X-Greedy-Delivery一致
+	Event string       `json:"event"`
+	At    string       `json:"at"`
+	Job   etcd.JobEtcd `json:"job"`
+}
+
+// HookEvent 任务事件对应的webhook事件
+// 失败后仍会重试的任务尚未结束 只在达到最大执行次数不再重试时通知job.failed
+func HookEvent(e storage.JobEvent) (string, bool) {
+	switch e.Type {
+	case storage.EventCreated:
+		// 周期任务的定义不会被执行
+		if len(e.Job.Schedule) != 0 {
+			return "", false
+		}
+		return storage.HookJobCreated, true
+	case storage.EventDispatched:
+		return storage.HookJobStarted, true
+	case storage.EventReported:
+		switch StateOf(e.Job) {
+		case StateSucceeded:
+			return storage.HookJobSucceeded, true
+		case StateExhausted:
+			return storage.HookJobFailed, true
+		}
+	case storage.EventKilled:
+		return storage.HookJobKilled, true
+	}
+	return "", false
+}
+
+// Notifier 订阅任务变更 投递给订阅了对应事件的webhook
+// 由leader执行 避免多个master重复投递
+// 已处理到的任务版本保存在etcd 新的任期从未完成投递的最早版本继续 投递至少一次
+// 同一事件重复投递时投递ID不变 接收方按X-Greedy-Delivery去重
+type Notifier struct {
+	events   storage.JobWatcher
+	hooks    storage.WebhookStore
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	next    int64         // 已收到的事件的下一个版本
+	pending map[int64]int // 各版本未完成的投递数
+	saved   int64         // 已保存的版本
+}
+
+// revisionInterval 保存已处理版本的间隔
+const revisionInterval = time.Second
+
+// NewNotifier .
+func NewNotifier(events storage.JobWatcher, hooks storage.WebhookStore) *Notifier {
+	return &Notifier{
+		events:   events,
+		hooks:    hooks,
+		client:   &http.Client{Timeout: DefaultDeliveryTimeout},
+		attempts: DefaultDeliveryAttempts,
+		backoff:  DefaultDeliveryBackoff,
+		pending:  map[int64]int{},
+	}
+}
+
+// SetRetry 设置投递的最大次数及首次重试的间隔
+func (n *Notifier) SetRetry(attempts int, backoff time.Duration) {
+	if attempts > 0 {
+		n.attempts = attempts
+	}
+	if backoff > 0 {
+		n.backoff = backoff
+	}
+}
+
+// Run 投递任务事件 直到ctx结束或失去leader
+// 从上次保存的版本继续 返回前等待进行中的投递并保存已处理的版本
+func (n *Notifier) Run(ctx context.Context, lost <-chan struct{}) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	rev, ok := n.loadRevision(ctx)
+	if !ok {
+		return
+	}
+	defer func() {
+		n.wg.Wait()
+		n.saveRevision()
+	}()
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ticker := time.NewTicker(revisionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n.saveRevision()
+			}
+		}
+	}()
+
+	for {
+		for e := range n.events.JobWatch(ctx, rev) {
+			if event, ok := HookEvent(e); ok {
+				n.notify(ctx, e.Revision, event, e.Job)
+			}
+			rev = e.Revision + 1
+			n.mu.Lock()
+			n.next = rev
+			n.mu.Unlock()
+		}
+		// watch异常结束时从下一个版本继续
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// loadRevision 读取上次保存的版本 失败时重试直到ctx结束
+func (n *Notifier) loadRevision(ctx context.Context) (int64, bool) {
+	for {
+		rev, err := n.hooks.NotifyRevision()
+		if err == nil {
+			n.mu.Lock()
+			n.next, n.saved, n.pending = rev, rev, map[int64]int{}
+			n.mu.Unlock()
+			return rev, true
+		}
+		log.Error("[Notifier] Get Revision Error", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// saveRevision 保存未完成投递的最早版本 没有未完成的投递时保存下一个版本
+func (n *Notifier) saveRevision() {
+	n.mu.Lock()
+	rev := n.next
+	for r := range n.pending {
+		if r < rev {
+			rev = r
+		}
+	}
+	if rev == n.saved {
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+	if err := n.hooks.NotifyRevisionSave(rev); err != nil {
+		log.Error("[Notifier] Save Revision Error", zap.Error(err), zap.Int64("revision", rev))
+		return
+	}
+	n.mu.Lock()
+	n.saved = rev
+	n.mu.Unlock()
+}
+
+// Notify 异步投递给订阅了事件的webhook
+func (n *Notifier) Notify(ctx context.Context, event string, job etcd.JobEtcd) {
+	n.notify(ctx, 0, event, job)
+}
+
+// notify rev大于0时记录该版本未完成的投递 投递ID由版本及webhook生成 重放时不变
+func (n *Notifier) notify(ctx context.Context, rev int64, event string, job etcd.JobEtcd) {
+	hooks, err := n.hooks.WebhookLists()
+	if err != nil {
+		log.Error("[Notifier] Get Webhooks Error", zap.Error(err))
+		return
+	}
+	now := time.Now().Format(TimeLayout)
+	for _, h := range hooks {
+		if !h.Active || !h.Subscribed(event) {
+			continue
+		}
+		d := storage.Delivery{
+			ID:        common.GenerateID(),
+			WebhookID: h.ID,
+			Event:     event,
+			JobID:     job.ID,
+			Status:    storage.DeliveryPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if rev > 0 {
+			d.ID = fmt.Sprintf("%v-%v", rev, h.ID)
+		}
+		body, err := json.Marshal(WebhookPayload{ID: d.ID, Event: event, At: now, Job: job})
+		if err != nil {
+			log.Error("[Notifier] Marshal Payload Error", zap.Error(err))
+			continue
+		}
+		n.track(rev, 1)
+		n.wg.Add(1)
+		go func(h storage.Webhook) {
+			defer n.wg.Done()
+			// ctx结束时未完成的投递保留在pending中 下个任期重放
+			if d := n.deliver(ctx, h, d, body); d.Status != storage.DeliveryPending {
+				n.track(rev, -1)
+			}
+		}(h)
+	}
+}
+
+// track 修改版本未完成的投递数
+func (n *Notifier) track(rev int64, delta int) {
+	if rev <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.pending[rev] += delta; n.pending[rev] <= 0 {
+		delete(n.pending, rev)
+	}
+}
+
+// deliver 投递失败时按退避重试 每次请求后更新投递记录
+func (n *Notifier) deliver(ctx context.Context, h storage.Webhook, d storage.Delivery, body []byte) storage.Delivery {
+	delay := n.backoff
+	for {
+		d.Attempts++
+		code, err := n.post(ctx, h, d, body)
+		d.StatusCode = code
+		d.UpdatedAt = time.Now().Format(TimeLayout)
+		switch {
+		case err == nil:
+			d.Status, d.Error = storage.DeliverySucceeded, ""
+		case d.Attempts >= n.attempts:
+			d.Status, d.Error = storage.DeliveryFailed, err.Error()
+		default:
+			d.Error = err.Error()
+		}
+		if err := n.hooks.DeliverySave(d); err != nil {
+			log.Error("[Notifier] Save Delivery Error", zap.Error(err), zap.String("delivery", d.ID))
+		}
+		if d.Status != storage.DeliveryPending {
+			metrics.WebhookDeliveries.WithLabelValues(d.Event, d.Status).Inc()
+			if d.Status == storage.DeliveryFailed {
+				log.Error("[Notifier] Delivery Failed", zap.String("webhook", h.ID), zap.String("url", h.URL),
+					zap.String("event", d.Event), zap.String("job_id", d.JobID), zap.String("error", d.Error))
+			}
+			return d
+		}
+		select {
+		case <-ctx.Done():
+			return d
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post 发送一次请求 非2xx视为失败
+func (n *Notifier) post(ctx context.Context, h storage.Webhook, d storage.Delivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderHookEvent, d.Event)
+	req.Header.Set(HeaderHookDelivery, d.ID)
+	req.Header.Set(HeaderHookTimestamp, ts)
+	req.Header.Set(HeaderHookSignature, common.WebhookSign(ts, body, h.Secret))
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+func TestHookEvent(t *testing.T) {
+	cases := []struct {
+		typ  string
+		job  etcd.JobEtcd
+		want string
+	}{
+		{storage.EventCreated, etcd.JobEtcd{}, storage.HookJobCreated},
+		{storage.EventCreated, etcd.JobEtcd{Schedule: "@daily"}, ""},
+		{storage.EventDispatched, etcd.JobEtcd{}, storage.HookJobStarted},
+		{storage.EventReported, etcd.JobEtcd{Status: int(common.Done), Result: int(common.Successful)}, storage.HookJobSucceeded},
+		// 仍会重试的失败不通知
+		{storage.EventReported, etcd.JobEtcd{Status: int(common.Done), Result: int(common.Failed)}, ""},
+		{storage.EventReported, etcd.JobEtcd{Status: int(common.Exhausted), Result: int(common.Failed)}, storage.HookJobFailed},
+		{storage.EventKilled, etcd.JobEtcd{}, storage.HookJobKilled},
+		{storage.EventUpdated, etcd.JobEtcd{}, ""},
+	}
+	for i, c := range cases {
+		got, _ := HookEvent(storage.JobEvent{Type: c.typ, Job: c.job})
+		if got != c.want {
+			t.Errorf("case %v: HookEvent(%v) = %q, want %q", i, c.typ, got, c.want)
+		}
+	}
+}
+
+func TestNotifierDelivery(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	// 第一次返回500 之后校验签名
+	var calls int32
+	var payload WebhookPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if !common.ValidWebhookSign(r.Header.Get(HeaderHookTimestamp), body, "secret", r.Header.Get(HeaderHookSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &payload)
+	}))
+	defer receiver.Close()
+
+	hooks := []storage.Webhook{
+		{ID: "h1", URL: receiver.URL, Secret: "secret", Events: []string{storage.HookJobSucceeded}, Active: true},
+		{ID: "h2", URL: receiver.URL, Secret: "secret", Events: []string{storage.HookJobCreated}, Active: true},
+	}
+	for _, h := range hooks {
+		if err := mgr.WebhookSave(h); err != nil {
+			t.Fatalf("WebhookSave: %v", err)
+		}
+	}
+
+	n := NewNotifier(mgr, mgr)
+	n.SetRetry(3, 10*time.Millisecond)
+	n.Notify(context.Background(), storage.HookJobSucceeded, etcd.JobEtcd{ID: "id-1", Name: "job-1"})
+	n.wg.Wait()
+
+	if payload.Event != storage.HookJobSucceeded || payload.Job.ID != "id-1" {
+		t.Fatalf("payload = %+v", payload)
+	}
+	ds, err := mgr.DeliveryLists("h1")
+	if err != nil || len(ds) != 1 {
+		t.Fatalf("DeliveryLists(h1) = %v, %v", ds, err)
+	}
+	if d := ds[0]; d.Status != storage.DeliverySucceeded || d.Attempts != 2 || d.ID != payload.ID {
+		t.Fatalf("delivery = %+v", d)
+	}
+	// 未订阅的webhook不投递
+	if ds, _ := mgr.DeliveryLists("h2"); len(ds) != 0 {
+		t.Fatalf("DeliveryLists(h2) = %v, want empty", ds)
+	}
+
+	// 重试耗尽后记录失败
+	receiver.Close()
+	n.Notify(context.Background(), storage.HookJobSucceeded, etcd.JobEtcd{ID: "id-2", Name: "job-2"})
+	n.wg.Wait()
+	ds, _ = mgr.DeliveryLists("h1")
+	if len(ds) != 2 {
+		t.Fatalf("deliveries = %+v", ds)
+	}
+	for _, d := range ds {
+		if d.JobID == "id-2" && (d.Status != storage.DeliveryFailed || d.Attempts != 3 || len(d.Error) == 0) {
+			t.Fatalf("delivery = %+v", d)
+		}
+	}
+
+	if _, err := mgr.WebhookDelete("h1"); err != nil {
+		t.Fatalf("WebhookDelete: %v", err)
+	}
+	if _, err := mgr.WebhookDelete("h1"); err != storage.ErrWebhookNotFound {
+		t.Fatalf("WebhookDelete again = %v, want ErrWebhookNotFound", err)
+	}
+}
+
+func TestNotifierResume(t *testing.T) {
+	mgr, done := newTestManager(t)
+	defer done()
+
+	// job-2的投递失败 用于模拟失去leader时未完成的投递
+	var failing int32 = 1
+	type delivery struct{ job, id string }
+	received := make(chan delivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := WebhookPayload{}
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+		if payload.Job.ID == "id-2" && atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		received <- delivery{payload.Job.ID, r.Header.Get(HeaderHookDelivery)}
+	}))
+	defer receiver.Close()
+	mgr.WebhookSave(storage.Webhook{ID: "h1", URL: receiver.URL, Secret: "secret", Events: []string{storage.HookJobCreated}, Active: true})
+
+	resp, err := mgr.kv.Get(context.Background(), jobEventPrefix, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.NotifyRevisionSave(resp.Header.Revision + 1)
+	wait := func(want string) delivery {
+		select {
+		case d := <-received:
+			if d.job != want {
+				t.Fatalf("delivered %+v, want %v", d, want)
+			}
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+		return delivery{}
+	}
+	run := func() (context.CancelFunc, chan struct{}) {
+		n := NewNotifier(mgr, mgr)
+		n.SetRetry(3, time.Hour)
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			n.Run(ctx, nil)
+		}()
+		return cancel, stopped
+	}
+
+	cancel, stopped := run()
+	mgr.JobSave(etcd.JobEtcd{ID: "id-1", Name: "job-1"})
+	wait("id-1")
+	mgr.JobSave(etcd.JobEtcd{ID: "id-2", Name: "job-2"})
+	first := wait("id-2")
+	cancel()
+	<-stopped
+
+	// 下个任期从未完成的job-2继续 投递ID不变 job-1不再投递
+	mgr.JobSave(etcd.JobEtcd{ID: "id-3", Name: "job-3"})
+	atomic.StoreInt32(&failing, 0)
+	cancel, stopped = run()
+	if d := wait("id-2"); d.id != first.id {
+		t.Fatalf("redelivered id = %v, want %v", d.id, first.id)
+	}
+	wait("id-3")
+
+	// 投递完成后保存job-3之后的版本
+	job, _ := mgr.JobGetWithID("id-3")
+	resp, _ = mgr.kv.Get(context.Background(), common.JobSavePrefix+job.Name)
+	want := resp.Kvs[0].ModRevision + 1
+	for i := 0; i < 50; i++ {
+		if rev, _ := mgr.NotifyRevision(); rev == want {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cancel()
+	<-stopped
+	if rev, err := mgr.NotifyRevision(); err != nil || rev != want {
+		t.Fatalf("NotifyRevision = %v, %v, want %v", rev, err, want)
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"time"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+// progressSamples 计算上报速率时保留的最近上报次数
+const progressSamples = 10
+
+// Progress 任务进度 由计数及最近的上报速率计算
+type Progress struct {
+	Total      int     `json:"total"`
+	Dispatched int     `json:"dispatched"`
+	Reported   int     `json:"reported"`
+	WithResult int     `json:"with_result"`
+	Failed     int     `json:"failed"`
+	Percent    float64 `json:"percent"`              // 完成百分比
+	Throughput float64 `json:"throughput"`           // 最近的上报速率 单位条/分钟
+	ETASeconds int64   `json:"eta_seconds"`          // 预计剩余时长 单位秒 无法估算时为-1
+	ETA        string  `json:"eta,omitempty"`        // 预计完成时间
+	UpdatedAt  string  `json:"updated_at,omitempty"` // 最近一次上报时间
+}
+
+// RecordDispatch 下发时更新计数 需在StartAttempt之后调用
+func RecordDispatch(job *etcd.JobEtcd, n int) {
+	p := &job.Progress
+	// 首次下发时以实际数据条数作为总数
+	if len(job.History) <= 1 && p.Dispatched == 0 {
+		p.Total = n
+	}
+	p.Dispatched += n
+	if l := len(job.History); l != 0 {
+		job.History[l-1].Dispatched = n
+	}
+}
+
+// RecordReport 上报时更新计数 需在FinishAttempt之前调用
+// reported及withResult为号码数 同一号码多个来源的结果只计一次
+// 任务失败时 本次下发但未上报(含追加上报)的号码计为失败
+func RecordReport(job *etcd.JobEtcd, reported, withResult int, failed bool, now time.Time) {
+	p := &job.Progress
+	p.Reported += reported
+	p.WithResult += withResult
+
+	seconds := 0
+	if l := len(job.History); l != 0 {
+		last := &job.History[l-1]
+		last.Reported += reported
+		if failed && last.Dispatched > last.Reported {
+			p.Failed += last.Dispatched - last.Reported
+		}
+		// 追加上报时从本次执行的上一次上报开始计时
+		from := last.StartedAt
+		if n := len(p.Samples); n != 0 && p.Samples[n-1].At > from {
+			from = p.Samples[n-1].At
+		}
+		if start, err := time.ParseInLocation(TimeLayout, from, time.Local); err == nil {
+			seconds = int(now.Sub(start).Seconds())
+		}
+	}
+	if seconds < 1 {
+		seconds = 1
+	}
+	p.Samples = append(p.Samples, etcd.ProgressSample{
+		At:      now.Format(TimeLayout),
+		Count:   reported,
+		Seconds: seconds,
+	})
+	if len(p.Samples) > progressSamples {
+		p.Samples = p.Samples[len(p.Samples)-progressSamples:]
+	}
+}
+
+// ProgressOf 计算任务的完成百分比、上报速率及预计完成时间
+func ProgressOf(job etcd.JobEtcd, now time.Time) Progress {
+	p := job.Progress
+	v := Progress{
+		Total:      p.Total,
+		Dispatched: p.Dispatched,
+		Reported:   p.Reported,
+		WithResult: p.WithResult,
+		Failed:     p.Failed,
+		ETASeconds: -1,
+	}
+	if v.Total == 0 {
+		v.Total = job.Source.Count
+	}
+	if v.Total > 0 {
+		v.Percent = float64(v.Reported) * 100 / float64(v.Total)
+		if v.Percent > 100 {
+			v.Percent = 100
+		}
+	}
+	if n := len(p.Samples); n != 0 {
+		v.UpdatedAt = p.Samples[n-1].At
+	}
+
+	count, seconds := 0, 0
+	for _, s := range p.Samples {
+		count += s.Count
+		seconds += s.Seconds
+	}
+	if seconds != 0 {
+		v.Throughput = float64(count) * 60 / float64(seconds)
+	}
+
+	remaining := v.Total - v.Reported
+	switch {
+	case remaining <= 0:
+		v.ETASeconds = 0
+	case v.Throughput > 0:
+		v.ETASeconds = int64(float64(remaining) * 60 / v.Throughput)
+	}
+	if v.ETASeconds > 0 {
+		v.ETA = now.Add(time.Duration(v.ETASeconds) * time.Second).Format(TimeLayout)
+	}
+	return v
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+func TestProgress(t *testing.T) {
+	start := time.Date(2020, 2, 18, 10, 0, 0, 0, time.Local)
+	job := etcd.JobEtcd{Source: etcd.JobDataSource{Count: 1000}}
+
+	if p := ProgressOf(job, start); p.Total != 1000 || p.ETASeconds != -1 {
+		t.Fatalf("progress before dispatch: %+v", p)
+	}
+
+	// 首次下发100条 2分钟后上报80条 其中60条有结果
+	StartAttempt(&job, "worker-1@127.0.0.1", start)
+	RecordDispatch(&job, 100)
+	now := start.Add(2 * time.Minute)
+	RecordReport(&job, 80, 60, true, now)
+	FinishAttempt(&job, 2, "timeout", now)
+
+	p := ProgressOf(job, now)
+	if p.Total != 100 || p.Dispatched != 100 || p.Reported != 80 || p.WithResult != 60 || p.Failed != 20 {
+		t.Fatalf("counters: %+v", p)
+	}
+	if p.Percent != 80 || p.Throughput != 40 {
+		t.Fatalf("percent = %v, throughput = %v", p.Percent, p.Throughput)
+	}
+	// 剩余20条 每分钟40条
+	if p.ETASeconds != 30 || p.ETA != now.Add(30*time.Second).Format(TimeLayout) {
+		t.Fatalf("eta = %v %v", p.ETASeconds, p.ETA)
+	}
+
+	StartAttempt(&job, "worker-1@127.0.0.1", now)
+	RecordDispatch(&job, 20)
+	RecordReport(&job, 20, 5, false, now.Add(time.Minute))
+	if p := ProgressOf(job, now); p.Total != 100 || p.Percent != 100 || p.ETASeconds != 0 {
+		t.Fatalf("progress after retry: %+v", p)
+	}
+}
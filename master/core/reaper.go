@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+
+	"github.com/MolenZhang/log"
+	"go.uber.org/zap"
+)
+
+// 执行期限 设备在期限内没有追加上报或心跳时视为异常退出
+const (
+	DefaultAttemptTimeout = 10 * time.Minute
+	DefaultReapTick       = 30 * time.Second
+)
+
+// AttemptTimeoutMsg 超时回收的执行记录的失败原因
+const AttemptTimeoutMsg = "attempt timed out"
+
+var attemptTimeout = DefaultAttemptTimeout
+
+// SetAttemptTimeout 设置执行期限
+func SetAttemptTimeout(d time.Duration) {
+	if d > 0 {
+		attemptTimeout = d
+	}
+}
+
+// ExtendAttempt 延长当前执行的期限 下发、追加上报及心跳时调用
+func ExtendAttempt(job *etcd.JobEtcd, now time.Time) {
+	if n := len(job.History); n != 0 {
+		job.History[n-1].Deadline = now.Add(attemptTimeout).Format(TimeLayout)
+	}
+}
+
+// AttemptExpired 执行中的任务是否已超过期限 升级前下发的执行没有期限 按下发时间计算
+func AttemptExpired(job etcd.JobEtcd, now time.Time) bool {
+	n := len(job.History)
+	if StateOf(job) != StateRunning || n == 0 {
+		return false
+	}
+	a := job.History[n-1]
+	if len(a.Deadline) != 0 {
+		deadline, err := time.ParseInLocation(TimeLayout, a.Deadline, time.Local)
+		return err == nil && now.After(deadline)
+	}
+	start, err := time.ParseInLocation(TimeLayout, a.StartedAt, time.Local)
+	return err == nil && now.After(start.Add(attemptTimeout))
+}
+
+// ExpireAttempt 超时的执行按失败结束 未上报的号码计为失败
+// 按退避策略等待后重新下发 再次下发时只下发未完成的号码
+func ExpireAttempt(job *etcd.JobEtcd, now time.Time) error {
+	RecordReport(job, 0, 0, true, now)
+	return FinishAttempt(job, common.Failed, AttemptTimeoutMsg, now)
+}
+
+// errAttemptAlive 回收前设备已上报或心跳
+var errAttemptAlive = errors.New("attempt is alive")
+
+// Reaper 回收超时的执行 由选举出的leader执行
+type Reaper struct {
+	store    storage.JobStore
+	tick     time.Duration
+	onExpire func(job etcd.JobEtcd) // 回收后回调 用于更新号码状态
+}
+
+// NewReaper .
+func NewReaper(store storage.JobStore, onExpire func(job etcd.JobEtcd)) *Reaper {
+	return &Reaper{
+		store:    store,
+		tick:     DefaultReapTick,
+		onExpire: onExpire,
+	}
+}
+
+// ReapExpired 回收所有超时的执行
+func (r *Reaper) ReapExpired(now time.Time) (jobs []etcd.JobEtcd, err error) {
+	list, err := r.store.JobLists()
+	if err != nil {
+		return
+	}
+	for _, job := range list {
+		if !AttemptExpired(job, now) {
+			continue
+		}
+		device := job.History[len(job.History)-1].Device
+		// 回收前设备可能刚好上报或心跳 基于最新的任务重新判断
+		expired, err := r.store.JobUpdate(job.ID, func(job *etcd.JobEtcd) error {
+			if !AttemptExpired(*job, now) {
+				return errAttemptAlive
+			}
+			return ExpireAttempt(job, now)
+		})
+		if err == errAttemptAlive {
+			continue
+		}
+		if err != nil {
+			log.Error("[Reaper] Expire Attempt Error", zap.Error(err), zap.String("job", job.Name))
+			continue
+		}
+		log.Info("[Reaper] Attempt Expired", zap.String("job", expired.Name), zap.String("device", device),
+			zap.Int("status", expired.Status))
+		if r.onExpire != nil {
+			r.onExpire(expired)
+		}
+		jobs = append(jobs, expired)
+	}
+	return
+}
+
+// Run 按周期检查 直到ctx结束或失去leader
+func (r *Reaper) Run(ctx context.Context, lost <-chan struct{}) {
+	ticker := time.NewTicker(r.tick)
+	defer ticker.Stop()
+	for {
+		if _, err := r.ReapExpired(time.Now()); err != nil {
+			log.Error("[Reaper] Reap Expired Attempts Error", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-lost:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/memory"
+)
+
+func TestReapExpired(t *testing.T) {
+	store := memory.NewJobStore()
+	start := time.Date(2020, 2, 18, 10, 0, 0, 0, time.Local)
+	job := etcd.JobEtcd{ID: "id-1", Name: "job-1", CrawlType: int(common.CloudPhone), MaxAttempts: 2}
+	if err := StartAttempt(&job, "dev-1", start); err != nil {
+		t.Fatal(err)
+	}
+	RecordDispatch(&job, 2)
+	store.JobSave(job)
+
+	expired := []etcd.JobEtcd{}
+	r := NewReaper(store, func(job etcd.JobEtcd) { expired = append(expired, job) })
+	if jobs, _ := r.ReapExpired(start.Add(DefaultAttemptTimeout - time.Minute)); len(jobs) != 0 {
+		t.Fatalf("reaped before deadline: %+v", jobs)
+	}
+
+	// 追加上报延长期限
+	job, _ = store.JobUpdate("id-1", func(job *etcd.JobEtcd) error {
+		AppendReport(job, 1, 1, start.Add(5*time.Minute))
+		return nil
+	})
+	if AttemptExpired(job, start.Add(DefaultAttemptTimeout+time.Minute)) {
+		t.Fatalf("deadline not extended: %+v", job.History)
+	}
+
+	now := start.Add(5*time.Minute + DefaultAttemptTimeout + time.Second)
+	jobs, err := r.ReapExpired(now)
+	if err != nil || len(jobs) != 1 || len(expired) != 1 {
+		t.Fatalf("reaped = %+v, err = %v", jobs, err)
+	}
+	got, _ := store.JobGetWithID("id-1")
+	last := got.History[len(got.History)-1]
+	if StateOf(got) != StateFailed || last.Error != AttemptTimeoutMsg || got.Progress.Failed != 1 || len(got.NextEligibleAt) == 0 {
+		t.Fatalf("job after reap: %+v", got)
+	}
+	// 达到最大执行次数后不再重试
+	if err := StartAttempt(&got, "dev-2", now); err != nil {
+		t.Fatal(err)
+	}
+	store.JobSave(got)
+	if jobs, _ := r.ReapExpired(now.Add(DefaultAttemptTimeout + time.Second)); len(jobs) != 1 || StateOf(jobs[0]) != StateExhausted {
+		t.Fatalf("reap last attempt: %+v", jobs)
+	}
+}
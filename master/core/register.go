@@ -1 +1,84 @@
 package core
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+
+	"github.com/MolenZhang/log"
+	"github.com/coreos/etcd/clientv3"
+)
+
+// Node 注册到etcd中的master节点
+type Node struct {
+	ID        string `json:"id"`
+	Addr      string `json:"addr"`
+	StartedAt string `json:"start_time"`
+}
+
+// registration 节点注册使用的租约
+type registration struct {
+	mu         sync.Mutex
+	leaseID    clientv3.LeaseID
+	cancelFunc context.CancelFunc
+}
+
+// Register 注册master节点 进程异常退出时随租约过期自动删除
+func (JobMgr *JobManager) Register(node Node, ttl int64) (err error) {
+	resp, err := JobMgr.lease.Grant(context.TODO(), ttl)
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "grant", err)
+		return
+	}
+	ctx, cancelFunc := context.WithCancel(context.TODO())
+	keepRespChan, err := JobMgr.lease.KeepAlive(ctx, resp.ID)
+	if err != nil {
+		cancelFunc()
+		metrics.StorageError(metrics.BackendEtcd, "keepalive", err)
+		return
+	}
+	go func() {
+		for keepResp := range keepRespChan {
+			if keepResp == nil {
+				break
+			}
+		}
+		log.Infof("[Register] KeepAlive Stopped, Node: %v", node.ID)
+	}()
+
+	node.StartedAt = time.Now().Format(TimeLayout)
+	value, _ := json.Marshal(node)
+	key := common.MasterRegPrefix + node.ID
+	if _, err = JobMgr.kv.Put(context.TODO(), key, string(value), clientv3.WithLease(resp.ID)); err != nil {
+		cancelFunc()
+		metrics.StorageError(metrics.BackendEtcd, "put", err)
+		return
+	}
+
+	JobMgr.reg.mu.Lock()
+	JobMgr.reg.leaseID = resp.ID
+	JobMgr.reg.cancelFunc = cancelFunc
+	JobMgr.reg.mu.Unlock()
+	return
+}
+
+// Deregister 注销master节点 撤销租约
+func (JobMgr *JobManager) Deregister(ctx context.Context) (err error) {
+	if JobMgr.reg == nil {
+		return
+	}
+	JobMgr.reg.mu.Lock()
+	defer JobMgr.reg.mu.Unlock()
+	if JobMgr.reg.cancelFunc == nil {
+		return
+	}
+	JobMgr.reg.cancelFunc()
+	JobMgr.reg.cancelFunc = nil
+	_, err = JobMgr.lease.Revoke(ctx, JobMgr.reg.leaseID)
+	metrics.StorageError(metrics.BackendEtcd, "revoke", err)
+	return
+}
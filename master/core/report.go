@@ -0,0 +1,87 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+// ErrWrongDevice 上报的设备不是任务当前的执行者
+var ErrWrongDevice = errors.New("job is not assigned to this device")
+
+// maxUnknownShown 错误信息中最多列出的号码数
+const maxUnknownShown = 10
+
+// UnknownPhonesError 上报了未下发的号码
+type UnknownPhonesError struct {
+	Phones []string
+}
+
+func (e *UnknownPhonesError) Error() string {
+	shown := e.Phones
+	if len(shown) > maxUnknownShown {
+		shown = shown[:maxUnknownShown]
+	}
+	return fmt.Sprintf("%d phones were not dispatched to this device: %s", len(e.Phones), strings.Join(shown, ","))
+}
+
+// ValidateReport 校验上报 任务需在执行中 上报的设备需是本次执行的设备
+// 不满足时分别返回*TransitionError ErrWrongDevice 号码由mysql中的状态校验
+func ValidateReport(job etcd.JobEtcd, result common.JobResult, device string) error {
+	if to := reportTarget(job, result); !CanTransition(StateOf(job), to) {
+		return &TransitionError{Job: job.Name, From: StateOf(job), To: to}
+	}
+	return validateAssignment(job, device)
+}
+
+// ValidateAppend 校验追加上报 与ValidateReport相同 但任务保持执行中
+func ValidateAppend(job etcd.JobEtcd, device string) error {
+	if from := StateOf(job); from != StateRunning {
+		return &TransitionError{Job: job.Name, From: from, To: StateRunning}
+	}
+	return validateAssignment(job, device)
+}
+
+func validateAssignment(job etcd.JobEtcd, device string) error {
+	if n := len(job.History); n != 0 && job.History[n-1].Device != device {
+		return ErrWrongDevice
+	}
+	return nil
+}
+
+// AppendReport 追加上报时更新进度 同时延长执行期限 任务保持执行中
+// reported为本次新上报的号码数 为0时只延长期限
+// 校验后本次执行已先一步结束时只补记计数 结束时计为失败的号码不再计为失败
+func AppendReport(job *etcd.JobEtcd, reported, withResult int, now time.Time) {
+	if StateOf(*job) == StateRunning {
+		ExtendAttempt(job, now)
+		if reported != 0 {
+			RecordReport(job, reported, withResult, false, now)
+		}
+		return
+	}
+	n := len(job.History)
+	if reported == 0 || n == 0 {
+		return
+	}
+	a := &job.History[n-1]
+	a.Reported += reported
+	p := &job.Progress
+	p.Reported += reported
+	p.WithResult += withResult
+	if common.JobResult(a.Result) == common.Failed {
+		if p.Failed -= reported; p.Failed < 0 {
+			p.Failed = 0
+		}
+	}
+}
+
+// AssignedTo 任务是否正由该设备执行
+func AssignedTo(job etcd.JobEtcd, device string) bool {
+	n := len(job.History)
+	return StateOf(job) == StateRunning && n != 0 && job.History[n-1].Device == device
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+// DefaultMaxAttempts 任务未指定时的最大执行次数
+const DefaultMaxAttempts = 3
+
+// DefaultBackoff 任务未指定时的退避策略 1分钟起 每次翻倍 最长1小时
+var DefaultBackoff = etcd.BackoffPolicy{
+	Initial:    60,
+	Max:        3600,
+	Multiplier: 2,
+}
+
+// RetryPolicy 补全任务的重试策略
+func RetryPolicy(job etcd.JobEtcd) (maxAttempts int, backoff etcd.BackoffPolicy) {
+	maxAttempts = job.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	backoff = job.Backoff
+	if backoff.Initial <= 0 {
+		backoff.Initial = DefaultBackoff.Initial
+	}
+	if backoff.Max < backoff.Initial {
+		backoff.Max = backoff.Initial
+		if DefaultBackoff.Max > backoff.Max {
+			backoff.Max = DefaultBackoff.Max
+		}
+	}
+	if backoff.Multiplier < 1 {
+		backoff.Multiplier = DefaultBackoff.Multiplier
+	}
+	return
+}
+
+// ValidateRetry 校验任务的重试参数
+func ValidateRetry(maxAttempts int, backoff etcd.BackoffPolicy) error {
+	if maxAttempts < 0 {
+		return fmt.Errorf("max_attempts is invalid")
+	}
+	if backoff.Initial < 0 || backoff.Max < 0 || backoff.Multiplier < 0 {
+		return fmt.Errorf("backoff is invalid")
+	}
+	if backoff.Max != 0 && backoff.Max < backoff.Initial {
+		return fmt.Errorf("backoff.max must not be less than backoff.initial")
+	}
+	return nil
+}
+
+// BackoffDelay 第n次失败后的退避时长
+func BackoffDelay(backoff etcd.BackoffPolicy, failures int) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+	secs := float64(backoff.Initial) * math.Pow(backoff.Multiplier, float64(failures-1))
+	if secs > float64(backoff.Max) {
+		secs = float64(backoff.Max)
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// Eligible 任务当前是否可以下发 失败后需等待退避结束
+func Eligible(job etcd.JobEtcd, now time.Time) bool {
+	if !CanTransition(StateOf(job), StateRunning) {
+		return false
+	}
+	if len(job.NextEligibleAt) == 0 {
+		return true
+	}
+	next, err := time.ParseInLocation(TimeLayout, job.NextEligibleAt, time.Local)
+	if err != nil {
+		return true
+	}
+	return !now.Before(next)
+}
+
+// StartAttempt 任务下发时记录一次新的执行
+func StartAttempt(job *etcd.JobEtcd, device string, now time.Time) error {
+	if err := Transition(job, StateRunning, now); err != nil {
+		return err
+	}
+	job.Attempts++
+	job.NextEligibleAt = ""
+	// 重跑后Attempts会清零 序号按历史记录累计
+	job.History = append(job.History, etcd.Attempt{
+		No:        len(job.History) + 1,
+		Device:    device,
+		StartedAt: job.StartedAt,
+	})
+	ExtendAttempt(job, now)
+	return nil
+}
+
+// FinishAttempt 任务上报时结束当前执行
+// 失败且未达到最大执行次数时按退避策略设置下次可下发的时间 否则进入Exhausted
+func FinishAttempt(job *etcd.JobEtcd, result common.JobResult, errMsg string, now time.Time) error {
+	to := reportTarget(*job, result)
+	if err := Transition(job, to, now); err != nil {
+		return err
+	}
+	if n := len(job.History); n != 0 && len(job.History[n-1].FinishedAt) == 0 {
+		a := &job.History[n-1]
+		a.FinishedAt = job.StopedAt
+		a.Result = int(result)
+		a.Error = errMsg
+	}
+	if to == StateFailed {
+		_, backoff := RetryPolicy(*job)
+		job.NextEligibleAt = now.Add(BackoffDelay(backoff, job.Attempts)).Format(TimeLayout)
+	}
+	return nil
+}
+
+// reportTarget 上报后任务的状态
+func reportTarget(job etcd.JobEtcd, result common.JobResult) State {
+	if result != common.Failed {
+		return StateSucceeded
+	}
+	if maxAttempts, _ := RetryPolicy(job); job.Attempts >= maxAttempts {
+		return StateExhausted
+	}
+	return StateFailed
+}
+
+// Rerun 重跑任务 清空执行次数及退避 保留历史记录
+func Rerun(job *etcd.JobEtcd, now time.Time) error {
+	if err := Transition(job, StatePending, now); err != nil {
+		return err
+	}
+	job.Attempts = 0
+	job.NextEligibleAt = ""
+	return nil
+}
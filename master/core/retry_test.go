@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	policy := etcd.BackoffPolicy{Initial: 10, Max: 60, Multiplier: 2}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 60 * time.Second},
+		{10, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := BackoffDelay(policy, tt.failures); got != tt.want {
+			t.Errorf("BackoffDelay(%v) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
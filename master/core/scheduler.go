@@ -0,0 +1,225 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+
+	"github.com/MolenZhang/log"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// DefaultScheduleTick 调度器检查周期任务的间隔
+const DefaultScheduleTick = 10 * time.Second
+
+// ParseSchedule 解析cron表达式 支持标准5位及@daily/@weekly等写法
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// NextRunTime 周期任务的下次触发时间 暂停或非周期任务返回false
+func NextRunTime(job etcd.JobEtcd) (time.Time, bool) {
+	if len(job.Schedule) == 0 || job.Paused {
+		return time.Time{}, false
+	}
+	sched, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return time.Time{}, false
+	}
+	last := job.LastRunAt
+	if len(last) == 0 {
+		last = job.CreatedAt
+	}
+	from, err := time.ParseInLocation(TimeLayout, last, time.Local)
+	if err != nil {
+		from = time.Now()
+	}
+	return sched.Next(from), true
+}
+
+// Scheduler 周期任务调度器
+// 由选举出的leader执行 到期时根据任务定义创建一次新的执行
+type Scheduler struct {
+	store storage.JobStore
+	tick  time.Duration
+	onRun func(run etcd.JobEtcd) // 新的执行创建后回调 用于导入任务数据
+}
+
+// NewScheduler .
+func NewScheduler(store storage.JobStore, onRun func(run etcd.JobEtcd)) *Scheduler {
+	return &Scheduler{
+		store: store,
+		tick:  DefaultScheduleTick,
+		onRun: onRun,
+	}
+}
+
+// errNotDue 定义已暂停或已被其他节点触发 本次跳过
+var errNotDue = errors.New("Schedule Not Due")
+
+// maxRunNameRetries 执行名称被占用时最多顺延的次数
+const maxRunNameRetries = 3
+
+// RunDue 创建所有到期的执行 错过的多次触发只补一次
+func (s *Scheduler) RunDue(now time.Time) (runs []etcd.JobEtcd, err error) {
+	jobs, err := s.store.JobLists()
+	if err != nil {
+		return
+	}
+	for _, def := range jobs {
+		next, ok := NextRunTime(def)
+		if !ok || now.Before(next) {
+			continue
+		}
+		run, err := s.fire(def, now)
+		if err == errNotDue || err == storage.ErrJobNotFound {
+			continue
+		}
+		if err != nil {
+			log.Error("[Scheduler] Create Run Error", zap.Error(err),
+				zap.String("job", def.Name))
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return
+}
+
+// fire 先更新定义再创建执行 避免创建失败后重复触发
+// 定义以比较版本的方式写入 列出后被暂停、删除或已被触发时跳过
+func (s *Scheduler) fire(def etcd.JobEtcd, now time.Time) (run etcd.JobEtcd, err error) {
+	def, err = s.store.JobUpdate(def.ID, func(def *etcd.JobEtcd) error {
+		if next, ok := NextRunTime(*def); !ok || now.Before(next) {
+			return errNotDue
+		}
+		def.Runs++
+		def.LastRunAt = now.Format(TimeLayout)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for i := 0; ; i++ {
+		run = newRun(def, now)
+		if err = s.store.JobCreate(run); err != storage.ErrJobExists || i == maxRunNameRetries {
+			break
+		}
+		// 同名任务已存在(如用户创建)时不覆盖 顺延序号后重试
+		log.Warnf("[Scheduler] Job: %v, Run: %v Already Exists", def.Name, run.Name)
+		runs := def.Runs
+		if def, err = s.store.JobUpdate(def.ID, func(def *etcd.JobEtcd) error {
+			if def.Runs != runs {
+				return errNotDue
+			}
+			def.Runs++
+			return nil
+		}); err != nil {
+			return
+		}
+	}
+	if err != nil {
+		return
+	}
+	log.Infof("[Scheduler] Job: %v, Run: %v Created", def.Name, run.Name)
+	if s.onRun != nil {
+		s.onRun(run)
+	}
+	return
+}
+
+// newRun 根据定义生成第def.Runs次执行
+func newRun(def etcd.JobEtcd, now time.Time) etcd.JobEtcd {
+	return etcd.JobEtcd{
+		ID:            common.GenerateID(),
+		Name:          fmt.Sprintf("%s-%d", def.Name, def.Runs),
+		Source:        def.Source,
+		CrawlType:     def.CrawlType,
+		Priority:      def.Priority,
+		MaxAttempts:   def.MaxAttempts,
+		Backoff:       def.Backoff,
+		Description:   def.Description,
+		MinAppVersion: def.MinAppVersion,
+		Status:        int(common.Pendding),
+		CreatedAt:     now.Format(TimeLayout),
+		ParentID:      def.ID,
+		RunID:         def.Runs,
+	}
+}
+
+// Run 按周期检查 直到ctx结束或失去leader
+func (s *Scheduler) Run(ctx context.Context, lost <-chan struct{}) {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+	for {
+		if _, err := s.RunDue(time.Now()); err != nil {
+			log.Error("[Scheduler] Run Due Jobs Error", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-lost:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LeaderTask 仅由leader执行的任务 ctx结束或失去leader(lost关闭)时返回
+type LeaderTask func(ctx context.Context, lost <-chan struct{})
+
+// RunLeader 参与选举 成为leader后运行所有任务 直到ctx结束
+// 多个master中只有leader会创建周期任务的执行及投递webhook
+func (JobMgr *JobManager) RunLeader(ctx context.Context, nodeID string, tasks ...LeaderTask) {
+	for {
+		if err := JobMgr.lead(ctx, nodeID, tasks); err != nil {
+			log.Error("[Leader] Election Error", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (JobMgr *JobManager) lead(ctx context.Context, nodeID string, tasks []LeaderTask) error {
+	session, err := concurrency.NewSession(JobMgr.client, concurrency.WithTTL(int(JobMgr.lockTTL)))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	election := concurrency.NewElection(session, common.SchedulerElectPrefix)
+	if err := election.Campaign(ctx, nodeID); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	log.Infof("[Leader] Node: %v Elected As Leader", nodeID)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task LeaderTask) {
+			defer wg.Done()
+			task(ctx, session.Done())
+		}(task)
+	}
+	wg.Wait()
+
+	// 主动退出时让出leader 其他节点无需等待租约过期
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if err := election.Resign(rctx); err != nil {
+		log.Error("[Leader] Resign Error", zap.Error(err))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/memory"
+)
+
+func TestSchedulerRunDue(t *testing.T) {
+	store := memory.NewJobStore()
+	created := time.Date(2020, 2, 18, 10, 0, 0, 0, time.Local)
+	def := etcd.JobEtcd{
+		ID:        "def-1",
+		Name:      "weekly",
+		CrawlType: int(common.CloudPhone),
+		CreatedAt: created.Format(TimeLayout),
+		Schedule:  "@hourly",
+		Source:    etcd.JobDataSource{Batch: "20200218", Count: 10},
+	}
+	if _, err := store.JobSave(def); err != nil {
+		t.Fatal(err)
+	}
+	fired := []etcd.JobEtcd{}
+	s := NewScheduler(store, func(run etcd.JobEtcd) { fired = append(fired, run) })
+
+	if next, ok := NextRunTime(def); !ok || !next.Equal(created.Add(time.Hour)) {
+		t.Fatalf("next run = %v %v, want %v", next, ok, created.Add(time.Hour))
+	}
+	if runs, _ := s.RunDue(created.Add(30 * time.Minute)); len(runs) != 0 {
+		t.Fatalf("runs before schedule: %+v", runs)
+	}
+
+	// 错过多次触发只补一次
+	now := created.Add(3*time.Hour + time.Minute)
+	runs, err := s.RunDue(now)
+	if err != nil || len(runs) != 1 || len(fired) != 1 {
+		t.Fatalf("runs = %+v, err = %v", runs, err)
+	}
+	run := runs[0]
+	if run.ParentID != def.ID || run.RunID != 1 || run.Name != "weekly-1" ||
+		run.Source.Batch != def.Source.Batch || len(run.Schedule) != 0 {
+		t.Fatalf("unexpected run: %+v", run)
+	}
+	if runs, _ := s.RunDue(now); len(runs) != 0 {
+		t.Fatalf("fired twice: %+v", runs)
+	}
+
+	// 列出后已被其他节点触发的定义不再重复触发
+	if _, err := s.fire(def, now); err != errNotDue {
+		t.Fatalf("stale fire err = %v, want errNotDue", err)
+	}
+
+	// 同名任务已存在时不覆盖 顺延序号
+	user := etcd.JobEtcd{ID: "user-1", Name: "weekly-2", CreatedAt: created.Format(TimeLayout)}
+	store.JobSave(user)
+	if runs, _ := s.RunDue(now.Add(time.Hour)); len(runs) != 1 || runs[0].Name != "weekly-3" || runs[0].RunID != 3 {
+		t.Fatalf("run after name conflict: %+v", runs)
+	}
+	if got, _ := store.JobGetWithID(def.ID); got.Runs != 3 {
+		t.Fatalf("runs after name conflict = %v, want 3", got.Runs)
+	}
+	if got, err := store.JobGetWithID(user.ID); err != nil || got.Name != user.Name {
+		t.Fatalf("existing job = %+v, %v", got, err)
+	}
+
+	// 定义本身不会被下发
+	job, err := JobMatch(store, int(common.CloudPhone))
+	if err != nil || job.ParentID != def.ID || len(job.Schedule) != 0 {
+		t.Fatalf("JobMatch = %+v, %v, want a run of %v", job, err, def.ID)
+	}
+
+	// 暂停后不再触发 列出后才暂停的也跳过
+	listed, _ := store.JobGetWithID(def.ID)
+	paused := listed
+	paused.Paused = true
+	store.JobSave(paused)
+	if runs, _ := s.RunDue(now.Add(3 * time.Hour)); len(runs) != 0 {
+		t.Fatalf("paused schedule fired: %+v", runs)
+	}
+	if _, err := s.fire(listed, now.Add(3*time.Hour)); err != errNotDue {
+		t.Fatalf("fire after pause err = %v, want errNotDue", err)
+	}
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+// ParseVersion 解析点分的数字版本号 如8.2.0
+func ParseVersion(v string) ([]int, error) {
+	if len(v) == 0 {
+		return nil, fmt.Errorf("version is empty")
+	}
+	parts := strings.Split(strings.TrimPrefix(v, "v"), ".")
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("version is invalid: %v", v)
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+// CompareVersion 比较版本号 a<b返回-1 a==b返回0 a>b返回1 缺少的位按0处理
+func CompareVersion(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// AppVersionFilter 只匹配app版本满足任务要求的任务
+// 未上报版本或版本无法解析的设备只能执行不限制版本的任务
+func AppVersionFilter(appVersion string) JobFilter {
+	have, err := ParseVersion(appVersion)
+	return func(job etcd.JobEtcd) bool {
+		if len(job.MinAppVersion) == 0 {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		need, perr := ParseVersion(job.MinAppVersion)
+		if perr != nil {
+			return false
+		}
+		return CompareVersion(have, need) >= 0
+	}
+}
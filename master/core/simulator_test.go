@@ -0,0 +1,29 @@
+package core
+
+import (
+	"testing"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+func TestAppVersionFilter(t *testing.T) {
+	cases := []struct {
+		have, need string
+		want       bool
+	}{
+		{"8.2.0", "", true},
+		{"", "", true},
+		{"", "8.2", false},
+		{"8.2", "8.2.0", true},
+		{"8.10", "8.9.5", true},
+		{"v9", "8.9.5", true},
+		{"8.1.9", "8.2", false},
+		{"beta", "8.2", false},
+	}
+	for _, c := range cases {
+		got := AppVersionFilter(c.have)(etcd.JobEtcd{MinAppVersion: c.need})
+		if got != c.want {
+			t.Errorf("AppVersionFilter(%q) on %q = %v, want %v", c.have, c.need, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+// State 任务状态 由存储中的status及result组合而成
+type State int
+
+// job state
+const (
+	StatePending   State = iota + 1 // 未执行
+	StateRunning                    // 执行中
+	StateSucceeded                  // 执行成功
+	StateFailed                     // 执行失败 等待退避后重试
+	StateExhausted                  // 重试次数用尽 不再下发
+)
+
+func (s State) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateRunning:
+		return "running"
+	case StateSucceeded:
+		return "succeeded"
+	case StateFailed:
+		return "failed"
+	case StateExhausted:
+		return "exhausted"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// Status 状态对应存储中的status及result
+func (s State) Status() (common.JobStatus, common.JobResult) {
+	switch s {
+	case StateRunning:
+		return common.Running, 0
+	case StateSucceeded:
+		return common.Done, common.Successful
+	case StateFailed:
+		return common.Done, common.Failed
+	case StateExhausted:
+		return common.Exhausted, common.Failed
+	}
+	return common.Pendding, 0
+}
+
+// StateOf 任务当前的状态
+// 旧数据中status为0的任务视为未执行 执行完成但结果不是成功的视为失败
+func StateOf(job etcd.JobEtcd) State {
+	switch common.JobStatus(job.Status) {
+	case common.Running:
+		return StateRunning
+	case common.Done:
+		if common.JobResult(job.Result) == common.Successful {
+			return StateSucceeded
+		}
+		return StateFailed
+	case common.Exhausted:
+		return StateExhausted
+	}
+	return StatePending
+}
+
+// transitions 允许的状态转换
+// 下发: 未执行/失败 -> 执行中
+// 上报: 执行中 -> 成功/失败/重试次数用尽
+// 重跑: 成功/失败/重试次数用尽 -> 未执行
+var transitions = map[State][]State{
+	StatePending:   {StateRunning},
+	StateRunning:   {StateSucceeded, StateFailed, StateExhausted},
+	StateSucceeded: {StatePending},
+	StateFailed:    {StateRunning, StatePending},
+	StateExhausted: {StatePending},
+}
+
+// CanTransition 是否允许从from转换到to
+func CanTransition(from, to State) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError 不允许的状态转换
+type TransitionError struct {
+	Job      string
+	From, To State
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("job %v is %v, can not transition to %v", e.Job, e.From, e.To)
+}
+
+// TransitionHook 状态转换后执行 用于更新时间戳及指标等
+type TransitionHook func(job *etcd.JobEtcd, from, to State, now time.Time)
+
+var hooks = struct {
+	sync.RWMutex
+	list []TransitionHook
+}{
+	list: []TransitionHook{stampTransition, countTransition},
+}
+
+// OnTransition 注册状态转换的回调 按注册顺序执行
+func OnTransition(hook TransitionHook) {
+	hooks.Lock()
+	defer hooks.Unlock()
+	hooks.list = append(hooks.list, hook)
+}
+
+// Transition 校验并执行状态转换 不允许时返回*TransitionError且不修改任务
+func Transition(job *etcd.JobEtcd, to State, now time.Time) error {
+	from := StateOf(*job)
+	if !CanTransition(from, to) {
+		return &TransitionError{Job: job.Name, From: from, To: to}
+	}
+	status, result := to.Status()
+	job.Status, job.Result = int(status), int(result)
+	hooks.RLock()
+	defer hooks.RUnlock()
+	for _, hook := range hooks.list {
+		hook(job, from, to, now)
+	}
+	return nil
+}
+
+// stampTransition 记录开始及结束执行的时间
+func stampTransition(job *etcd.JobEtcd, from, to State, now time.Time) {
+	switch to {
+	case StateRunning:
+		job.StartedAt = now.Format(TimeLayout)
+	case StateSucceeded, StateFailed, StateExhausted:
+		job.StopedAt = now.Format(TimeLayout)
+	}
+}
+
+func countTransition(job *etcd.JobEtcd, from, to State, now time.Time) {
+	metrics.JobTransitions.WithLabelValues(from.String(), to.String()).Inc()
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+func TestStateOf(t *testing.T) {
+	cases := []struct {
+		status common.JobStatus
+		result common.JobResult
+		want   State
+	}{
+		{0, 0, StatePending},
+		{common.Pendding, 0, StatePending},
+		{common.Running, 0, StateRunning},
+		{common.Done, common.Successful, StateSucceeded},
+		{common.Done, common.Failed, StateFailed},
+		{common.Done, 0, StateFailed},
+		{common.Exhausted, common.Failed, StateExhausted},
+	}
+	for _, c := range cases {
+		job := etcd.JobEtcd{Status: int(c.status), Result: int(c.result)}
+		if got := StateOf(job); got != c.want {
+			t.Errorf("StateOf(%v, %v) = %v, want %v", c.status, c.result, got, c.want)
+		}
+		// 状态与存储的值可以互相转换
+		if status, result := c.want.Status(); c.status != 0 && c.result != 0 && (status != c.status || result != c.result) {
+			t.Errorf("%v.Status() = %v, %v", c.want, status, result)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	now := time.Date(2020, 2, 18, 10, 0, 0, 0, time.Local)
+	var seen []string
+	OnTransition(func(job *etcd.JobEtcd, from, to State, now time.Time) {
+		if job.Name == "state-1" {
+			seen = append(seen, from.String()+"->"+to.String())
+		}
+	})
+
+	job := etcd.JobEtcd{Name: "state-1", Status: int(common.Pendding)}
+	if err := Transition(&job, StateSucceeded, now); err == nil {
+		t.Fatal("pending -> succeeded allowed")
+	}
+	if err := Transition(&job, StateRunning, now); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != int(common.Running) || job.StartedAt != now.Format(TimeLayout) {
+		t.Fatalf("running job: %+v", job)
+	}
+	if err := Transition(&job, StateSucceeded, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if job.Result != int(common.Successful) || job.StopedAt != now.Add(time.Minute).Format(TimeLayout) {
+		t.Fatalf("succeeded job: %+v", job)
+	}
+
+	// 非法转换不修改任务
+	err := Transition(&job, StateRunning, now)
+	if te, ok := err.(*TransitionError); !ok || te.From != StateSucceeded || te.To != StateRunning {
+		t.Fatalf("succeeded -> running: %v", err)
+	}
+	if StateOf(job) != StateSucceeded {
+		t.Fatalf("job changed by rejected transition: %+v", job)
+	}
+	if len(seen) != 2 || seen[0] != "pending->running" || seen[1] != "running->succeeded" {
+		t.Fatalf("hooks: %v", seen)
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"time"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+// TimeLayout 任务中时间字段的格式
+const TimeLayout = "2006-01-02 15:04:05"
+
+// JobSummary 任务维度的汇总
+type JobSummary struct {
+	Total       int         `json:"total"`
+	ByStatus    map[int]int `json:"by_status"`     // 状态 1-未执行 2-执行中 3-执行完成
+	ByCrawlType map[int]int `json:"by_crawl_type"` // 执行方式 1-模拟器;2-云手机;3-api
+	Finished    int         `json:"finished"`      // 有开始及结束时间的任务数
+	AvgDuration float64     `json:"avg_duration"`  // 平均执行时长 单位秒
+}
+
+// JobDuration 任务执行时长 未开始或未结束时ok为false
+func JobDuration(job etcd.JobEtcd) (d time.Duration, ok bool) {
+	if len(job.StartedAt) == 0 || len(job.StopedAt) == 0 {
+		return
+	}
+	start, err := time.ParseInLocation(TimeLayout, job.StartedAt, time.Local)
+	if err != nil {
+		return
+	}
+	stop, err := time.ParseInLocation(TimeLayout, job.StopedAt, time.Local)
+	if err != nil || stop.Before(start) {
+		return
+	}
+	return stop.Sub(start), true
+}
+
+// Summarize 汇总任务状态及平均执行时长
+func Summarize(jobs []etcd.JobEtcd) JobSummary {
+	s := JobSummary{
+		Total:       len(jobs),
+		ByStatus:    map[int]int{},
+		ByCrawlType: map[int]int{},
+	}
+	var total time.Duration
+	for _, job := range jobs {
+		s.ByStatus[job.Status]++
+		s.ByCrawlType[job.CrawlType]++
+		if d, ok := JobDuration(job); ok {
+			s.Finished++
+			total += d
+		}
+	}
+	if s.Finished != 0 {
+		s.AvgDuration = total.Seconds() / float64(s.Finished)
+	}
+	return s
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+// 任务匹配策略
+const (
+	StrategyRandom   = "random"   // 随机
+	StrategyPriority = "priority" // 严格按优先级 同优先级先创建的先执行
+	StrategyFair     = "fair"     // 按剩余工作量加权随机 大任务不会饿死小任务
+	StrategyFIFO     = "fifo"     // 按创建时间先进先出
+)
+
+// Strategy 从可执行的任务中选出下一个下发的任务
+// jobs已按执行方式及状态过滤 且不为空
+type Strategy interface {
+	Pick(jobs []etcd.JobEtcd) etcd.JobEtcd
+}
+
+// StrategyFunc .
+type StrategyFunc func(jobs []etcd.JobEtcd) etcd.JobEtcd
+
+// Pick .
+func (f StrategyFunc) Pick(jobs []etcd.JobEtcd) etcd.JobEtcd {
+	return f(jobs)
+}
+
+// strategies 已注册的策略
+var strategies = map[string]Strategy{
+	StrategyRandom:   StrategyFunc(pickRandom),
+	StrategyPriority: StrategyFunc(pickPriority),
+	StrategyFair:     StrategyFunc(pickFair),
+	StrategyFIFO:     StrategyFunc(pickFIFO),
+}
+
+// matcher 各执行方式使用的策略
+var matcher = struct {
+	sync.RWMutex
+	def    Strategy
+	byType map[int]Strategy
+}{
+	def:    strategies[StrategyRandom],
+	byType: map[int]Strategy{},
+}
+
+// SetMatchStrategy 设置默认策略及按执行方式的策略
+func SetMatchStrategy(def string, byType map[int]string) error {
+	d, ok := strategies[def]
+	if !ok {
+		return fmt.Errorf("unknown match strategy: %v", def)
+	}
+	m := map[int]Strategy{}
+	for ct, name := range byType {
+		s, ok := strategies[name]
+		if !ok {
+			return fmt.Errorf("unknown match strategy for crawl type %v: %v", ct, name)
+		}
+		m[ct] = s
+	}
+	matcher.Lock()
+	defer matcher.Unlock()
+	matcher.def = d
+	matcher.byType = m
+	return nil
+}
+
+// StrategyFor 执行方式对应的策略
+func StrategyFor(crawlType int) Strategy {
+	matcher.RLock()
+	defer matcher.RUnlock()
+	if s, ok := matcher.byType[crawlType]; ok {
+		return s
+	}
+	return matcher.def
+}
+
+// rnd 全局随机源 避免每次请求重新播种
+var rnd = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func randIntn(n int) int {
+	rnd.Lock()
+	defer rnd.Unlock()
+	return rnd.Intn(n)
+}
+
+func pickRandom(jobs []etcd.JobEtcd) etcd.JobEtcd {
+	return jobs[randIntn(len(jobs))]
+}
+
+// earlier 按创建时间排序 时间相同时按名称
+func earlier(a, b etcd.JobEtcd) bool {
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt < b.CreatedAt
+	}
+	return a.Name < b.Name
+}
+
+func pickPriority(jobs []etcd.JobEtcd) etcd.JobEtcd {
+	sorted := append([]etcd.JobEtcd{}, jobs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority > sorted[j].Priority
+		}
+		return earlier(sorted[i], sorted[j])
+	})
+	return sorted[0]
+}
+
+func pickFIFO(jobs []etcd.JobEtcd) etcd.JobEtcd {
+	first := jobs[0]
+	for _, job := range jobs[1:] {
+		if earlier(job, first) {
+			first = job
+		}
+	}
+	return first
+}
+
+// remaining 任务剩余的工作量 即未上报的号码数 至少为1
+// 尚未下发过的任务以数据源条数作为总数
+func remaining(job etcd.JobEtcd) int {
+	total := job.Progress.Total
+	if total == 0 {
+		total = job.Source.Count
+	}
+	if n := total - job.Progress.Reported; n > 0 {
+		return n
+	}
+	return 1
+}
+
+func pickFair(jobs []etcd.JobEtcd) etcd.JobEtcd {
+	total := 0
+	for _, job := range jobs {
+		total += remaining(job)
+	}
+	n := randIntn(total)
+	for _, job := range jobs {
+		n -= remaining(job)
+		if n < 0 {
+			return job
+		}
+	}
+	return jobs[len(jobs)-1]
+}
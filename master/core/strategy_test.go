@@ -0,0 +1,61 @@
+package core
+
+import (
+	"testing"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+)
+
+func TestStrategies(t *testing.T) {
+	jobs := []etcd.JobEtcd{
+		{Name: "small", Priority: 1, CreatedAt: "2020-02-18 10:00:00", Source: etcd.JobDataSource{Count: 10}},
+		{Name: "urgent", Priority: 9, CreatedAt: "2020-02-18 12:00:00", Source: etcd.JobDataSource{Count: 100}},
+		{Name: "first", Priority: 1, CreatedAt: "2020-02-18 09:00:00", Source: etcd.JobDataSource{Count: 990}},
+	}
+	if got := strategies[StrategyPriority].Pick(jobs); got.Name != "urgent" {
+		t.Fatalf("priority picked %v", got.Name)
+	}
+	if got := strategies[StrategyFIFO].Pick(jobs); got.Name != "first" {
+		t.Fatalf("fifo picked %v", got.Name)
+	}
+
+	// 按剩余工作量加权 每个任务都有机会被选中
+	picked := map[string]int{}
+	for i := 0; i < 5000; i++ {
+		picked[strategies[StrategyFair].Pick(jobs).Name]++
+	}
+	if picked["small"] == 0 || picked["first"] < picked["urgent"] {
+		t.Fatalf("fair share picks: %v", picked)
+	}
+
+	// 已上报的部分不再计入剩余工作量
+	jobs[2].Progress = etcd.JobProgress{Total: 990, Reported: 985}
+	picked = map[string]int{}
+	for i := 0; i < 5000; i++ {
+		picked[strategies[StrategyFair].Pick(jobs).Name]++
+	}
+	if picked["first"] == 0 || picked["first"] > picked["small"] || picked["urgent"] < picked["small"] {
+		t.Fatalf("fair share picks after report: %v", picked)
+	}
+}
+
+func TestSetMatchStrategy(t *testing.T) {
+	defer SetMatchStrategy(StrategyRandom, nil)
+
+	if err := SetMatchStrategy("unknown", nil); err == nil {
+		t.Fatal("unknown default strategy accepted")
+	}
+	if err := SetMatchStrategy(StrategyFIFO, map[int]string{2: StrategyPriority}); err != nil {
+		t.Fatal(err)
+	}
+	jobs := []etcd.JobEtcd{
+		{Name: "old", CreatedAt: "2020-02-18 09:00:00"},
+		{Name: "urgent", Priority: 5, CreatedAt: "2020-02-18 10:00:00"},
+	}
+	if got := StrategyFor(2).Pick(jobs); got.Name != "urgent" {
+		t.Fatalf("crawl type 2 picked %v", got.Name)
+	}
+	if got := StrategyFor(1).Pick(jobs); got.Name != "old" {
+		t.Fatalf("default picked %v", got.Name)
+	}
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+
+	"github.com/MolenZhang/log"
+	"github.com/coreos/etcd/clientv3"
+	"go.uber.org/zap"
+)
+
+// DeliveryTTL 投递记录的保留时长 单位秒
+const DeliveryTTL int64 = 7 * 24 * 3600
+
+var _ storage.WebhookStore = (*JobManager)(nil)
+
+// WebhookSave 保存webhook
+func (JobMgr *JobManager) WebhookSave(h storage.Webhook) error {
+	value, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	_, err = JobMgr.kv.Put(context.TODO(), common.WebhookPrefix+h.ID, string(value))
+	metrics.StorageError(metrics.BackendEtcd, "put", err)
+	return err
+}
+
+// WebhookDelete 删除webhook 投递记录随租约过期
+func (JobMgr *JobManager) WebhookDelete(id string) (h storage.Webhook, err error) {
+	resp, err := JobMgr.kv.Delete(context.TODO(), common.WebhookPrefix+id, clientv3.WithPrevKV())
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "delete", err)
+		return
+	}
+	if len(resp.PrevKvs) == 0 {
+		err = storage.ErrWebhookNotFound
+		return
+	}
+	err = json.Unmarshal(resp.PrevKvs[0].Value, &h)
+	return
+}
+
+// WebhookLists 所有webhook
+func (JobMgr *JobManager) WebhookLists() (hooks []storage.Webhook, err error) {
+	resp, err := JobMgr.kv.Get(context.TODO(), common.WebhookPrefix, clientv3.WithPrefix())
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "get", err)
+		return
+	}
+	hooks = []storage.Webhook{}
+	for _, kv := range resp.Kvs {
+		h := storage.Webhook{}
+		if err := json.Unmarshal(kv.Value, &h); err != nil {
+			log.Error("[WebhookLists] Unmarshal Webhook Error", zap.Error(err), zap.String("key", string(kv.Key)))
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	return
+}
+
+// DeliverySave 保存投递记录 每次保存续期
+func (JobMgr *JobManager) DeliverySave(d storage.Delivery) error {
+	value, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	grant, err := JobMgr.lease.Grant(ctx, DeliveryTTL)
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "grant", err)
+		return err
+	}
+	_, err = JobMgr.kv.Put(ctx, common.DeliveryPrefix+d.WebhookID+"/"+d.ID, string(value), clientv3.WithLease(grant.ID))
+	metrics.StorageError(metrics.BackendEtcd, "put", err)
+	return err
+}
+
+// DeliveryLists webhook的投递记录 按创建时间排序
+func (JobMgr *JobManager) DeliveryLists(webhookID string) (ds []storage.Delivery, err error) {
+	resp, err := JobMgr.kv.Get(context.TODO(), common.DeliveryPrefix+webhookID+"/", clientv3.WithPrefix())
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "get", err)
+		return
+	}
+	ds = []storage.Delivery{}
+	for _, kv := range resp.Kvs {
+		d := storage.Delivery{}
+		if err := json.Unmarshal(kv.Value, &d); err != nil {
+			log.Error("[DeliveryLists] Unmarshal Delivery Error", zap.Error(err), zap.String("key", string(kv.Key)))
+			continue
+		}
+		ds = append(ds, d)
+	}
+	storage.SortDeliveries(ds)
+	return
+}
+
+// NotifyRevision 通知恢复时开始订阅的任务版本 未保存过时返回0
+func (JobMgr *JobManager) NotifyRevision() (int64, error) {
+	resp, err := JobMgr.kv.Get(context.TODO(), common.NotifyRevisionKey)
+	if err != nil {
+		metrics.StorageError(metrics.BackendEtcd, "get", err)
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+}
+
+// NotifyRevisionSave 保存通知恢复时开始订阅的任务版本
+func (JobMgr *JobManager) NotifyRevisionSave(rev int64) error {
+	_, err := JobMgr.kv.Put(context.TODO(), common.NotifyRevisionKey, strconv.FormatInt(rev, 10))
+	metrics.StorageError(metrics.BackendEtcd, "put", err)
+	return err
+}
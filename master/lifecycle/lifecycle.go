@@ -0,0 +1,91 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MolenZhang/log"
+)
+
+// Hook 退出时执行的清理动作
+type Hook struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// Manager 服务生命周期管理
+// 收到SIGINT/SIGTERM后按注册顺序执行清理 所有清理共享同一个截止时间
+type Manager struct {
+	mu      sync.Mutex
+	hooks   []Hook
+	timeout time.Duration
+	signals chan os.Signal
+	errs    chan error
+}
+
+// New 创建生命周期管理器 timeout为整个退出流程的截止时间
+func New(timeout time.Duration) *Manager {
+	m := &Manager{
+		timeout: timeout,
+		signals: make(chan os.Signal, 1),
+		errs:    make(chan error, 1),
+	}
+	signal.Notify(m.signals, os.Interrupt, syscall.SIGTERM)
+	return m
+}
+
+// OnShutdown 注册清理动作 先注册的先执行
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, Hook{Name: name, Fn: fn})
+}
+
+// Fail 服务运行出错 触发退出流程
+func (m *Manager) Fail(err error) {
+	select {
+	case m.errs <- err:
+	default:
+	}
+}
+
+// Wait 阻塞直到收到退出信号或服务出错
+func (m *Manager) Wait() {
+	select {
+	case sig := <-m.signals:
+		log.Infof("[Lifecycle] Received Signal: %v", sig)
+	case err := <-m.errs:
+		log.Errorf("[Lifecycle] Server Failed: %v", err)
+	}
+	signal.Stop(m.signals)
+}
+
+// Shutdown 依次执行清理动作 单个清理出错不影响后续清理
+func (m *Manager) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	m.mu.Lock()
+	hooks := append([]Hook{}, m.hooks...)
+	m.mu.Unlock()
+
+	failed := []string{}
+	for _, h := range hooks {
+		start := time.Now()
+		if err := h.Fn(ctx); err != nil {
+			log.Errorf("[Lifecycle] Shutdown %v Error: %v", h.Name, err)
+			failed = append(failed, h.Name)
+			continue
+		}
+		log.Infof("[Lifecycle] Shutdown %v Done in %v", h.Name, time.Since(start))
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("shutdown failed: %v", failed)
+	}
+	return nil
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"runtime"
 	"time"
 
 	"code.safe.molen.com/molen/haoma/greedy/master/config"
 	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/lifecycle"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
 	"code.safe.molen.com/molen/haoma/greedy/master/routers"
+	"code.safe.molen.com/molen/haoma/greedy/master/rpc"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
 	mgo "code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
 	mysql "code.safe.molen.com/molen/haoma/greedy/master/storage/mysql"
 
@@ -47,28 +51,53 @@ func main() {
 		}
 	}()
 
-	// 仅仅适配云手机 初始化master任务管理器
-	if err := core.InitJobManager([]string{"10.99.91.62:8378"}); err != nil {
-		panic(fmt.Sprintf("Init Job Manager error: %v", err))
-	}
-
 	// init config
 	// TODO add config
 	config.InitConfig()
 
+	// 初始化master任务管理器 任一依赖不可用时直接退出
+	if err := core.InitJobManager(config.Cfg.Etcd.Addrs); err != nil {
+		fatal("Init Job Manager error: %v", err)
+	}
+	core.JobMgr.SetLockTTL(config.Cfg.Etcd.LockTTL)
+	core.SetAttemptTimeout(config.Cfg.Server.AttemptTimeout)
+	if err := core.SetMatchStrategy(config.Cfg.Match.Strategy, config.Cfg.Match.ByCrawlType); err != nil {
+		fatal("Init Match Strategy error: %v", err)
+	}
+	metrics.SetJobLister(core.JobMgr.JobLists)
+
 	// init mongo
 	if err := mgo.InitMongo(config.Cfg.Mongo); err != nil {
-		panic(fmt.Sprintf("Init Mongo error: %v", err))
+		fatal("Init Mongo error: %v", err)
 	}
 
 	// init mysql
 	if err := mysql.InitMysql(config.Cfg.Mysql); err != nil {
-		panic(fmt.Sprintf("Init Mongo error: %v", err))
+		fatal("Init Mysql error: %v", err)
+	}
+
+	api := &routers.API{
+		Stores: storage.Stores{
+			Jobs:     &core.JobMgr,
+			Locks:    &core.JobMgr,
+			Events:   &core.JobMgr,
+			Webhooks: &core.JobMgr,
+			Sources:  mgo.Store{},
+			Results:  mgo.Store{},
+			JobData:  mysql.Store{},
+		},
+		Checkers: map[string]routers.Checker{
+			"etcd":  core.JobMgr.Ping,
+			"mongo": mgo.Ping,
+			"mysql": mysql.Ping,
+		},
+		MaxBodyBytes: config.Cfg.Server.MaxBodyBytes,
+		Simulator:    config.Cfg.Simulator,
 	}
 
 	srv := &http.Server{
-		Addr:              ":8585",
-		Handler:           routers.Handler,
+		Addr:              config.Cfg.Server.Addr,
+		Handler:           routers.NewHandler(api),
 		ReadTimeout:       time.Second * 120,
 		ReadHeaderTimeout: time.Second * 120,
 		WriteTimeout:      time.Second * 120,
@@ -76,21 +105,98 @@ func main() {
 		MaxHeaderBytes:    10240,
 	}
 
-	log.Debugf("listen at \x1b[95m%s\x1b[0m", ":8585")
-	if err := srv.ListenAndServe(); err != nil {
-		log.Info("listen %s", zap.Error(err))
+	// 退出时先结束事件流 否则Shutdown会等待这些长连接直到超时
+	srv.RegisterOnShutdown(api.CloseStreams)
+
+	var grpcSrv *rpc.Server
+	if len(config.Cfg.Server.GRPCAddr) != 0 {
+		grpcSrv = rpc.NewServer(api)
 	}
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	// 注册master节点
+	hostname, _ := os.Hostname()
+	node := core.Node{
+		ID:   fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		Addr: config.Cfg.Server.Addr,
+	}
+	if err := core.JobMgr.Register(node, config.Cfg.Server.RegisterTTL); err != nil {
+		fatal("Register Node error: %v", err)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
+	// 周期任务调度、webhook投递及超时执行的回收 多个master中仅leader执行
+	schedCtx, stopSched := context.WithCancel(context.Background())
+	schedDone := make(chan struct{})
+	go func() {
+		defer close(schedDone)
+		core.JobMgr.RunLeader(schedCtx, node.ID,
+			core.NewScheduler(&core.JobMgr, api.ImportJobData).Run,
+			core.NewNotifier(&core.JobMgr, &core.JobMgr).Run,
+			core.NewReaper(&core.JobMgr, api.ReleaseJobData).Run)
+	}()
 
+	// 退出顺序: 停止接收HTTP及gRPC请求并等待处理中的请求 -> 停止调度及webhook投递 -> 等待异步写入 -> 释放锁及注册 -> 关闭连接
+	lc := lifecycle.New(config.Cfg.Server.ShutdownTimeout)
+	lc.OnShutdown("http server", srv.Shutdown)
+	if grpcSrv != nil {
+		lc.OnShutdown("grpc server", grpcSrv.Shutdown)
+	}
+	lc.OnShutdown("leader tasks", func(ctx context.Context) error {
+		stopSched()
+		select {
+		case <-schedDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	lc.OnShutdown("async writes", routers.WaitAsync)
+	lc.OnShutdown("job locks", core.JobMgr.ReleaseLocks)
+	lc.OnShutdown("node registration", core.JobMgr.Deregister)
+	lc.OnShutdown("etcd", func(context.Context) error {
+		return core.JobMgr.Close()
+	})
+	lc.OnShutdown("mongo", func(context.Context) error {
+		mgo.Close()
+		return nil
+	})
+	lc.OnShutdown("mysql", func(context.Context) error {
+		return mysql.Close()
+	})
+
+	go func() {
+		log.Debugf("listen at \x1b[95m%s\x1b[0m", config.Cfg.Server.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("listen error", zap.Error(err))
+			lc.Fail(err)
+		}
+	}()
+
+	if grpcSrv != nil {
+		go func() {
+			lis, err := net.Listen("tcp", config.Cfg.Server.GRPCAddr)
+			if err != nil {
+				log.Error("grpc listen error", zap.Error(err))
+				lc.Fail(err)
+				return
+			}
+			log.Debugf("grpc listen at \x1b[95m%s\x1b[0m", config.Cfg.Server.GRPCAddr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Error("grpc serve error", zap.Error(err))
+				lc.Fail(err)
+			}
+		}()
+	}
+
+	lc.Wait()
 	log.Info("shutting down ...")
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := lc.Shutdown(); err != nil {
 		log.Errorf("shutting down : %s", err)
 	}
 	log.Info("Shutdown byte")
 }
+
+// fatal 启动失败 日志仅写文件 同时输出到标准错误便于排查
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	log.Fatalf(format, args...)
+}
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "greedy"
+
+// 存储类型
+const (
+	BackendEtcd  = "etcd"
+	BackendMongo = "mongo"
+	BackendMysql = "mysql"
+)
+
+var (
+	// HTTPRequests 请求数
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPDuration 请求耗时
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// PhonesDispatched 下发的号码数
+	PhonesDispatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "phones_dispatched_total",
+		Help:      "Phones handed out by /data/phones, by env type.",
+	}, []string{"env_type"})
+
+	// RecordsIngested 上报的数据条数
+	RecordsIngested = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_ingested_total",
+		Help:      "Records received by /data/report, by job result.",
+	}, []string{"job_result"})
+
+	// LockFailures 抢锁失败次数
+	LockFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lock_failures_total",
+		Help:      "JobLock acquisition failures by reason.",
+	}, []string{"reason"})
+
+	// StorageErrors 存储访问出错次数
+	StorageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_errors_total",
+		Help:      "Errors returned by etcd, mongo and mysql, by operation.",
+	}, []string{"backend", "op"})
+
+	// AsyncQueueDepth 等待完成的异步写入
+	AsyncQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "async_insert_queue_depth",
+		Help:      "Async inserts queued or in flight, by backend.",
+	}, []string{"backend"})
+
+	// WebhookDeliveries webhook投递结果
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook deliveries by event and final status.",
+	}, []string{"event", "status"})
+
+	// JobTransitions 任务状态转换次数
+	JobTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_transitions_total",
+		Help:      "Job state transitions by from and to state.",
+	}, []string{"from", "to"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		HTTPRequests,
+		HTTPDuration,
+		PhonesDispatched,
+		RecordsIngested,
+		LockFailures,
+		StorageErrors,
+		AsyncQueueDepth,
+		WebhookDeliveries,
+		JobTransitions,
+		jobs,
+	)
+}
+
+// StorageError 记录存储错误 err为nil时忽略
+func StorageError(backend, op string, err error) {
+	if err != nil {
+		StorageErrors.WithLabelValues(backend, op).Inc()
+	}
+}
+
+// JobLister 采集任务指标时获取任务列表
+type JobLister func() ([]etcd.JobEtcd, error)
+
+// jobCollector 每次采集时从etcd读取任务 按状态及执行方式统计
+type jobCollector struct {
+	mu     sync.RWMutex
+	lister JobLister
+	desc   *prometheus.Desc
+}
+
+var jobs = &jobCollector{
+	desc: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "jobs"),
+		"Jobs by status and crawl type.",
+		[]string{"status", "crawl_type"}, nil,
+	),
+}
+
+// SetJobLister 设置任务列表来源
+func SetJobLister(l JobLister) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.lister = l
+}
+
+// Describe .
+func (jc *jobCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jc.desc
+}
+
+// Collect .
+func (jc *jobCollector) Collect(ch chan<- prometheus.Metric) {
+	jc.mu.RLock()
+	lister := jc.lister
+	jc.mu.RUnlock()
+	if lister == nil {
+		return
+	}
+	list, err := lister()
+	if err != nil {
+		return
+	}
+	type key struct{ status, crawlType int }
+	counts := map[key]int{}
+	for _, job := range list {
+		counts[key{job.Status, job.CrawlType}]++
+	}
+	for k, v := range counts {
+		ch <- prometheus.MustNewConstMetric(jc.desc, prometheus.GaugeValue, float64(v),
+			strconv.Itoa(k.status), strconv.Itoa(k.crawlType))
+	}
+}
+
+// GinMiddleware 统计请求数及耗时
+// gin当前版本拿不到匹配的路由 根据处理函数名反查注册时的路径
+func GinMiddleware(engine *gin.Engine) gin.HandlerFunc {
+	var once sync.Once
+	routes := map[string]string{}
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		once.Do(func() {
+			for _, r := range engine.Routes() {
+				routes[r.Method+r.Handler] = r.Path
+			}
+		})
+		route, ok := routes[c.Request.Method+c.HandlerName()]
+		if !ok {
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
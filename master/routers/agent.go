@@ -0,0 +1,14 @@
+package routers
+
+import (
+	"context"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+)
+
+// SuitAgent 适配破解api
+// worker中的agent直接查询各来源 结果通过Report上报
+func (s *PhonesResp) SuitAgent(ctx context.Context, st storage.Stores, holder storage.LockHolder) (err error) {
+	return s.dispatch(ctx, st, common.Agent, holder)
+}
@@ -0,0 +1,76 @@
+package routers
+
+import (
+	"context"
+	"sync"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+)
+
+// asyncWG 未完成的异步写入 退出时需等待其完成
+var asyncWG sync.WaitGroup
+
+// goAsync 异步写入 backend用于统计等待中的写入数量
+func goAsync(backend string, f func()) {
+	metrics.AsyncQueueDepth.WithLabelValues(backend).Inc()
+	asyncWG.Add(1)
+	go func() {
+		defer asyncWG.Done()
+		defer metrics.AsyncQueueDepth.WithLabelValues(backend).Dec()
+		f()
+	}()
+}
+
+// jobQueues 每个任务待执行的异步写入 存在时说明该任务的写入正在执行
+var jobQueues = struct {
+	sync.Mutex
+	m map[string][]func()
+}{m: map[string][]func(){}}
+
+// goAsyncJob 同一任务的异步写入按提交顺序依次执行
+// 避免导入数据、下发及上报的号码状态修改互相覆盖
+func goAsyncJob(backend, jobID string, f func()) {
+	metrics.AsyncQueueDepth.WithLabelValues(backend).Inc()
+	asyncWG.Add(1)
+	task := func() {
+		defer asyncWG.Done()
+		defer metrics.AsyncQueueDepth.WithLabelValues(backend).Dec()
+		f()
+	}
+	jobQueues.Lock()
+	pending, running := jobQueues.m[jobID]
+	jobQueues.m[jobID] = append(pending, task)
+	jobQueues.Unlock()
+	if running {
+		return
+	}
+	go func() {
+		for {
+			jobQueues.Lock()
+			q := jobQueues.m[jobID]
+			if len(q) == 0 {
+				delete(jobQueues.m, jobID)
+				jobQueues.Unlock()
+				return
+			}
+			jobQueues.m[jobID] = q[1:]
+			jobQueues.Unlock()
+			q[0]()
+		}
+	}()
+}
+
+// WaitAsync 等待所有异步写入完成 超时以ctx为准
+func WaitAsync(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		asyncWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package routers
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMaxBodyBytes 默认的请求体大小上限 gzip请求按解压后的大小计算
+const DefaultMaxBodyBytes = 8 << 20
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitedBody 读取超过上限时返回errBodyTooLarge
+type limitedBody struct {
+	r io.Reader
+	c io.Closer
+	n int64 // 剩余可读的字节数 小于0时已超过上限
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	// 多读一个字节判断是否超过上限
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.r.Read(p)
+	if int64(n) > b.n {
+		b.n = -1
+		return 0, errBodyTooLarge
+	}
+	b.n -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.c.Close()
+}
+
+// limitBody 限制请求体大小 Content-Encoding为gzip时先解压
+// 解压后的大小同样受限 防止压缩炸弹
+func limitBody(max int64) gin.HandlerFunc {
+	if max <= 0 {
+		max = DefaultMaxBodyBytes
+	}
+	return func(c *gin.Context) {
+		req := c.Request
+		if req.Body == nil || req.Body == http.NoBody {
+			return
+		}
+		if req.ContentLength > max {
+			abortBody(c, common.CodeBodyTooLarge)
+			return
+		}
+		body := &limitedBody{r: req.Body, c: req.Body, n: max}
+		switch strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))) {
+		case "", "identity":
+			req.Body = body
+		case "gzip":
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				if body.n < 0 {
+					abortBody(c, common.CodeBodyTooLarge)
+					return
+				}
+				abortBody(c, common.CodeInvalidBody)
+				return
+			}
+			req.Body = &limitedBody{r: zr, c: req.Body, n: max}
+			req.Header.Del("Content-Encoding")
+			req.ContentLength = -1
+		default:
+			abortBody(c, common.CodeUnsupportedEncoding)
+			return
+		}
+	}
+}
+
+// abortBody 中间件中拒绝请求 此时还未匹配到handler 按EnvelopeJob返回
+func abortBody(c *gin.Context, code common.Code) {
+	common.Write(c, common.EnvelopeJob, nil, common.NewError(code, ""))
+}
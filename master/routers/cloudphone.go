@@ -1,12 +1,14 @@
 package routers
 
 import (
-	"fmt"
-	"net/http"
+	"context"
+	"errors"
 	"time"
 
 	"code.safe.molen.com/molen/haoma/greedy/master/common"
 	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
 	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
 	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
 	mysql "code.safe.molen.com/molen/haoma/greedy/master/storage/mysql"
@@ -33,48 +35,92 @@ type SourceResp struct {
 }
 
 // Phones 获取号码列表
-func Phones(c *gin.Context) {
-	res := common.Result{}
-	h := &common.RespHeader{
-		Version: "1.0.0",
-		Time:    time.Now().Unix(),
-		Status:  http.StatusOK,
-	}
+func (a *API) Phones(c *gin.Context) {
 	resp := &PhonesResp{
 		Phones: []string{},
 	}
-	defer res.SetHeader(h).SetData(resp).Done(c)
+	res := common.NewResponse(common.EnvelopeResult).SetData(resp)
+	defer res.Done(c)
 
 	// 执行方式
 	crawlType := c.Query("act_type")
 	if len(crawlType) == 0 {
-		h.SetStatus(http.StatusBadRequest).SetMsg("Invalid Parameter of act_type")
+		res.Fail(common.NewError(common.CodeInvalidParameters, "act_type is required"))
 		return
 	}
 	// 运行环境
 	envType := c.Query("env_type")
 	if len(envType) == 0 {
-		h.SetStatus(http.StatusBadRequest).SetMsg("Invalid Parameter of env_type")
+		res.Fail(common.NewError(common.CodeInvalidParameters, "env_type is required"))
 		return
 	}
 	log.Debug("[Phones] Parameters",
 		zap.String("act_type", crawlType),
 		zap.String("env_type", envType))
 
-	switch envType {
+	// 记录锁的持有者 设备异常退出时便于排查
+	phones, err := a.FetchPhones(c.Request.Context(), FetchRequest{
+		EnvType:    envType,
+		Holder:     storage.LockHolder{WorkerID: c.Query("worker_id"), IP: c.ClientIP()},
+		Image:      c.Query("image"),
+		AppVersion: c.Query("app_version"),
+	})
+	if err != nil {
+		res.Fail(err)
+		return
+	}
+	*resp = *phones
+}
+
+var (
+	// ErrInvalidEnvType 不支持的运行环境
+	ErrInvalidEnvType = errors.New("Invalid Parameter of env_type")
+	// ErrNoDataMatched 任务没有待抓取的号码
+	ErrNoDataMatched = errors.New("No Data Matched")
+)
+
+// FetchRequest 领取号码的参数 HTTP及gRPC共用
+type FetchRequest struct {
+	EnvType    string             // 运行环境 1-模拟器 2-云手机 3-破解api
+	Holder     storage.LockHolder // 领取的设备
+	Image      string             // 模拟器镜像
+	AppVersion string             // 模拟器中的app版本
+}
+
+// FetchPhones 按运行环境匹配任务 加锁后下发号码 出错时返回*common.Error
+func (a *API) FetchPhones(ctx context.Context, req FetchRequest) (resp *PhonesResp, err error) {
+	resp = &PhonesResp{
+		Phones: []string{},
+	}
+	// 云手机及agent以worker_id区分设备 上报及心跳时需与下发时一致
+	switch req.EnvType {
+	case common.CrawlTypeCloudPhone, common.CrawlTypeAgent:
+		if len(req.Holder.WorkerID) == 0 {
+			err = common.NewError(common.CodeInvalidParameters, "worker_id is required")
+			return
+		}
+	}
+	switch req.EnvType {
 	case common.CrawlTypeSimulator:
+		// 模拟器镜像决定每轮抓取的号码数 app版本决定可执行的任务
+		err = resp.SuitSimulator(ctx, a.Stores, a.Simulator, req.Holder, req.Image, req.AppVersion)
 	case common.CrawlTypeCloudPhone:
-		if err := resp.SuitCloudPhone(); err != nil {
-			h.SetStatus(http.StatusBadRequest).SetMsg(err.Error())
-		}
+		err = resp.SuitCloudPhone(ctx, a.Stores, req.Holder)
 	case common.CrawlTypeAgent:
+		err = resp.SuitAgent(ctx, a.Stores, req.Holder)
 	default:
-		h.SetStatus(http.StatusBadRequest).SetMsg("Invalid Parameter of act_type")
+		err = ErrInvalidEnvType
 	}
+	if err != nil {
+		err = apiError(err)
+		return
+	}
+	metrics.PhonesDispatched.WithLabelValues(req.EnvType).Add(float64(len(resp.Phones)))
+	return
 }
 
 // SuitCloudPhone 适配云手机
-func (s *PhonesResp) SuitCloudPhone() (err error) {
+func (s *PhonesResp) SuitCloudPhone(ctx context.Context, st storage.Stores, holder storage.LockHolder) (err error) {
 	// Mock TODO delete
 	/*if true {
 		s.Phones = append(s.Phones, "15330091234", "15757121234")
@@ -82,41 +128,65 @@ func (s *PhonesResp) SuitCloudPhone() (err error) {
 		s.JobID = "1234-asdf-4567"
 		return
 	}*/
+	return s.dispatch(ctx, st, common.CloudPhone, holder)
+}
 
+// dispatch 匹配任务 加锁后获取数据并更新任务状态
+func (s *PhonesResp) dispatch(ctx context.Context, st storage.Stores, crawlType common.CrawlType,
+	holder storage.LockHolder, filters ...core.JobFilter) (err error) {
 	// 获取任务 任务加锁
-	job, err := core.JobMgr.JobMatch(2)
+	job, err := core.JobMatch(st.Jobs, int(crawlType), filters...)
 	if err != nil {
 		return
 	}
-	log.Debugf("[SuitCloudPhone] JobMatched Is: %v", job)
+	log.Debugf("[Dispatch] JobMatched Is: %v", job)
 	// 抢锁
-	jobLock := core.JobMgr.JobLockMade(job.Name)
-	err = jobLock.TryLock()
+	jobLock := st.Jobs.JobLockMade(job.Name, holder)
+	err = jobLock.TryLock(ctx)
 	if err != nil {
-		log.Error("[SuitCloudPhone] JobLock Made Error", zap.Error(err))
+		log.Error("[Dispatch] JobLock Made Error", zap.Error(err))
 		return
 	}
+	// 锁只在下发期间持有 上报等写入不依赖锁 见core.JobLock
 	defer jobLock.UnLock()
-	log.Debugf("[SuitCloudPhone] TryLock SUCC")
+	log.Debugf("[Dispatch] TryLock SUCC")
+
+	// 匹配与加锁之间任务可能已被其他节点下发 加锁后重新读取
+	if job, err = st.Jobs.JobGetWithID(job.ID); err != nil || !core.Eligible(job, time.Now()) {
+		log.Infof("[Dispatch] Job: %v Changed Before Locked, err: %v", job.Name, err)
+		err = core.ErrNoJobMatched
+		return
+	}
 
 	// 加锁成功后获取数据
 	// 当数据获取成功后 修改任务执行状态
-	err = s.CrawlSource(job)
+	err = s.CrawlSource(st, job)
 	if err != nil {
-		log.Errorf("[SuitCloudPhone] CrawlSource Error: %v", err)
+		log.Errorf("[Dispatch] CrawlSource Error: %v", err)
 		return
 	}
 	s.Batch = job.Source.Batch
 	s.JobID = job.ID
 
-	job.Status = int(common.Running)
-	job.StartedAt = time.Now().Format("2006-01-02 15:04:05")
-	curJob, err := core.JobMgr.JobSave(job)
+	// 基于最新的任务开始执行 期间被修改(如上报、重跑)时重新校验
+	curJob, err := st.Jobs.JobUpdate(job.ID, func(job *etcd.JobEtcd) error {
+		if err := core.StartAttempt(job, holder.Device(), time.Now()); err != nil {
+			return err
+		}
+		core.RecordDispatch(job, len(s.Phones))
+		return nil
+	})
 	if err != nil {
-		log.Error("[SuitCloudPhone] Update Job Status Error", zap.Error(err))
+		log.Error("[Dispatch] Update Job Status Error", zap.Error(err))
 		return
 	}
-	log.Infof("[SuitCloudPhone] Job Matched is: %#v", curJob)
+	log.Infof("[Dispatch] Job Matched is: %#v", curJob)
+
+	// 上报时按号码状态校验 需在返回前修改
+	// 首次下发时数据可能还未导入mysql 由导入完成后修改
+	if _, err := st.JobData.UpdateStatus(job.ID, s.Phones, mysql.DataUnfinished, mysql.DataDispatched); err != nil {
+		log.Error("[Dispatch] Update Data Status Error", zap.Error(err), zap.String("job_id", job.ID))
+	}
 
 	return
 }
@@ -124,20 +194,20 @@ func (s *PhonesResp) SuitCloudPhone() (err error) {
 // CrawlSource .
 // 根据任务的状态选取数据来源
 // 如果是首次 先从mongo中获取(此处从mongo中是因为担心 任务执行时 mysql中还未抓取到待执行数据)
-// 如果是非首次 从mysql中获取(此时 mysql中应该有该任务待执行的数据)
-func (s *PhonesResp) CrawlSource(job etcd.JobEtcd) (err error) {
-	switch common.JobResult(job.Result) {
-	case common.Failed:
+// 如果是非首次 从mysql中获取(此时 mysql中应该有该任务待执行的数据) 只下发未完成的号码
+func (s *PhonesResp) CrawlSource(st storage.Stores, job etcd.JobEtcd) (err error) {
+	switch {
+	case len(job.History) != 0 || common.JobResult(job.Result) == common.Failed:
 		dsMysql := []mysql.JobDatum{}
 		// 读取mysql 非首次次如果能命中该任务则说明该任务曾经执行失败过一次 再次命中时mysql中已经有任务相关的数据
-		dsMysql, err = mysql.BatchFetch(job.ID)
+		dsMysql, err = st.JobData.BatchFetch(job.ID, mysql.DataUnfinished...)
 		if err != nil {
 			log.Error("[CrawlSource] Get CrawlSource Data Error", zap.Error(err))
 			return
 		}
 		if len(dsMysql) == 0 {
-			err = fmt.Errorf("No Data Matched")
-			log.Errorf("[CrawlSource] No Data Matched From Mongo With Job: %v", job)
+			err = ErrNoDataMatched
+			log.Errorf("[CrawlSource] No Unfinished Data Matched From Mysql With Job: %v", job)
 			return
 		}
 		for _, d := range dsMysql {
@@ -146,13 +216,13 @@ func (s *PhonesResp) CrawlSource(job etcd.JobEtcd) (err error) {
 	default:
 		// 读取mongo 首次命中 先从mongo中获取数据
 		docs := []mongo.CrawlSource{}
-		docs, err = mongo.CrawlSourceGet(job.Source.Batch, job.Source.Count)
+		docs, err = st.Sources.CrawlSourceGet(job.Source.Batch, job.Source.Count)
 		if err != nil {
 			log.Error("[CrawlSource] Get CrawlSource Data Error", zap.Error(err))
 			return
 		}
 		if len(docs) == 0 {
-			err = fmt.Errorf("No Data Matched")
+			err = ErrNoDataMatched
 			log.Errorf("[CrawlSource] No Data Matched From Mongo With Job: %v", job)
 			return
 		}
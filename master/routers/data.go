@@ -2,13 +2,18 @@ package routers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"strconv"
 	"time"
 
 	"code.safe.molen.com/molen/haoma/greedy/master/common"
 	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
 	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
+	mysql "code.safe.molen.com/molen/haoma/greedy/master/storage/mysql"
 
 	"github.com/MolenZhang/log"
 	"github.com/gin-gonic/gin"
@@ -18,14 +23,15 @@ import (
 
 // ReportBody 上报数据内容
 type ReportBody struct {
-	JobID     string      `json:"job_id"`     // 任务id
-	JobResult int         `json:"job_result"` // 任务结果 1-成功 2-失败
-	JobDetail []JobDetail `json:"job_detail"` // 任务详情
+	JobID     string      `json:"job_id" binding:"required"`           // 任务id
+	JobResult int         `json:"job_result" binding:"eq=0|eq=1|eq=2"` // 任务结果 1-成功 2-失败 追加上报时不填
+	ErrorMsg  string      `json:"error_msg"`                           // 失败原因
+	JobDetail []JobDetail `json:"job_detail" binding:"dive"`           // 任务详情
 }
 
 // JobDetail .
 type JobDetail struct {
-	Phone  string `json:"phone"`
+	Phone  string `json:"phone" binding:"required"`
 	Result string `json:"crawl_result"`
 	Type   int    `json:"crawl_type"`   // 1-模拟器;2-云手机;3-api
 	Source int    `json:"crawl_source"` // 1-360手机卫士;2-搜狗号码通;3-电话邦
@@ -33,71 +39,352 @@ type JobDetail struct {
 }
 
 // Report 数据上报
-func Report(c *gin.Context) {
-	res := common.Result{}
-	h := &common.RespHeader{
-		Version: "1.0.0",
-		Time:    time.Now().Unix(),
-		Status:  http.StatusOK,
-	}
-	defer res.SetHeader(h).Done(c)
-
-	body := ReportBody{}
-	if err := c.BindJSON(&body); err != nil {
-		log.Error("[Report] json unmarshal requst body error:%v", zap.Error(err))
-		h.SetStatus(http.StatusBadRequest).SetMsg("Invalid request body")
+func (a *API) Report(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeResult)
+	defer res.Done(c)
+
+	// 支持JSON及msgpack 可用gzip压缩 由validateBody解析
+	body := *validated(c).(*ReportBody)
+	if _, err := a.SubmitReport(reportDevice(c), body, true); err != nil {
+		res.Fail(err)
 		return
 	}
+	res.SetData(gin.H{"result": "success"})
+}
 
-	job, err := core.JobMgr.JobGetWithID(body.JobID)
+// ReportAppend 追加上报部分号码的结果 任务保持执行中
+// 结果即时入库并更新号码状态及进度 设备异常退出后重试只会下发未上报的号码
+// 全部号码完成后调用/report/complete结束任务 同一号码多个来源的结果需在同一次追加中上报
+func (a *API) ReportAppend(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeResult)
+	defer res.Done(c)
+
+	// 支持JSON及msgpack 可用gzip压缩 由validateBody解析
+	body := *validated(c).(*ReportBody)
+	n, err := a.SubmitReport(reportDevice(c), body, false)
 	if err != nil {
-		log.Errorf("[Report] Get Job With ID: %v, Error:%v", body.JobID, err)
-		h.SetStatus(http.StatusBadRequest).SetMsg(err.Error())
+		res.Fail(err)
 		return
 	}
+	res.SetData(gin.H{"result": "success", "appended": n})
+}
 
-	job.Status = int(common.Done)
-	job.StopedAt = time.Now().Format("2006-01-02 15:04:05")
-	switch body.JobResult {
-	case 1:
-		job.Result = int(common.Successful)
-		// 插入数据库, 如果出错 打印到日志文件
-		go func(datas []JobDetail) {
-			var docs = []interface{}{}
-			for _, data := range datas {
-				doc := mongo.CrawlResult{
-					ID:        bson.NewObjectId(),
-					Phone:     data.Phone,
-					CreatedAt: time.Now(),
-					UpdatedAt: time.Now(),
-					Source:    data.Source,
-					Result:    data.Result,
-					Batch:     data.Batch,
-					JobID:     job.ID,
-					Type:      data.Type,
-				}
-				docs = append(docs, doc)
-			}
-			if err := mongo.CrawlResultInsert(docs); err != nil {
-				records, _ := json.Marshal(datas)
-				log.Error("[Report] data report error", zap.Error(err),
-					zap.String("phones", string(records)))
-			}
-			return
-		}(body.JobDetail)
-	default:
-		// 任务失败时 status=3/执行完成 result=2/失败
-		job.Result = int(common.Failed)
+// HeartbeatResp 心跳的返回
+type HeartbeatResp struct {
+	Assigned   bool   `json:"assigned"` // 为false时设备应停止执行
+	State      string `json:"state"`
+	ServerTime int64  `json:"server_time"`
+}
+
+// errNotAssigned 心跳的设备不是任务当前的执行者 不延长期限
+var errNotAssigned = errors.New("job is not assigned to this device")
+
+// Heartbeat 返回任务是否仍由该设备执行 HTTP及gRPC共用
+// 仍由该设备执行时延长执行期限 超过期限没有心跳及追加上报的执行会被回收
+// 出错时返回*common.Error
+func (a *API) Heartbeat(device, jobID string) (resp HeartbeatResp, err error) {
+	resp.Assigned = true
+	job, err := a.Stores.Jobs.JobUpdate(jobID, func(job *etcd.JobEtcd) error {
+		if !core.AssignedTo(*job, device) {
+			return errNotAssigned
+		}
+		core.ExtendAttempt(job, time.Now())
+		return nil
+	})
+	if err == errNotAssigned {
+		resp.Assigned = false
+		job, err = a.Stores.Jobs.JobGetWithID(jobID)
+	}
+	if err != nil {
+		log.Error("[Heartbeat] Update Job Error", zap.Error(err), zap.String("job_id", jobID))
+		err = apiError(err)
+		return
 	}
+	resp.State = core.StateOf(job).String()
+	resp.ServerTime = time.Now().Unix()
+	return
+}
+
+// JobHeartbeat 设备执行任务期间定期发送心跳 只在最后上报的设备也需发送
+func (a *API) JobHeartbeat(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeResult)
+	defer res.Done(c)
 
-	oldJob, err := core.JobMgr.JobSave(job)
+	jobID := c.Query("job_id")
+	if len(jobID) == 0 {
+		res.Fail(common.NewError(common.CodeInvalidParameters, "job_id is required"))
+		return
+	}
+	hb, err := a.Heartbeat(reportDevice(c), jobID)
 	if err != nil {
-		log.Errorf("[Report] Update Status Of Job: %#v, With Error: %v", job, err)
-		h.SetStatus(http.StatusInternalServerError).SetMsg(err.Error())
+		res.Fail(err)
 		return
 	}
-	log.Debugf("[Report] Before Update, Job is: %v", oldJob)
-	res.SetData(gin.H{"result": "success"})
+	res.SetData(hb)
+}
+
+// SubmitReport 校验并保存上报的结果 HTTP及gRPC共用
+// final为false时追加上报 任务保持执行中 为true时结束本次执行
+// 返回本次入库的号码数 出错时返回*common.Error
+func (a *API) SubmitReport(device string, body ReportBody, final bool) (int, error) {
+	// gRPC的上报不经过validateBody 在此统一校验
+	if err := Validate(&body); err != nil {
+		e := err.(*ValidationError)
+		return 0, &common.Error{Code: common.CodeValidationFailed, Detail: e.Error(), Fields: e.Fields}
+	}
+	if final {
+		return a.finishReport(device, body)
+	}
+	return a.appendReport(device, body)
+}
+
+// finishReport 结束本次执行 未上报的号码任务成功时为无结果 失败时为失败
+// 号码的状态以mysql为准 先修改号码状态再以CAS方式写回任务
+// 与追加上报或其他节点的上报并发时每个号码只会计数及入库一次
+func (a *API) finishReport(device string, body ReportBody) (int, error) {
+	result := common.Successful
+	if body.JobResult != 1 {
+		// 任务失败时 status=3/执行完成 result=2/失败 按退避策略等待重试
+		// 达到最大执行次数后 status=4/重试次数用尽
+		result = common.Failed
+	}
+	job, err := a.Stores.Jobs.JobGetWithID(body.JobID)
+	if err == nil {
+		// 只有执行中的任务可以上报 重复上报或任务已重跑时拒绝
+		err = core.ValidateReport(job, result, device)
+	}
+	var datas []JobDetail
+	if err == nil {
+		datas, err = a.claimReported(job, body.JobDetail)
+	}
+	if err != nil {
+		log.Error("[Report] Report Rejected", zap.Error(err), zap.String("job_id", body.JobID), zap.String("device", device))
+		return 0, apiError(err)
+	}
+	// 插入数据库, 如果出错 打印到日志文件
+	// 失败的任务也可能带有部分号码的结果
+	a.saveResults(job, datas)
+	metrics.RecordsIngested.WithLabelValues(strconv.Itoa(body.JobResult)).Add(float64(len(datas)))
+
+	reported, hits, now := len(detailPhones(datas)), countHits(datas), time.Now()
+	job, err = a.Stores.Jobs.JobUpdate(body.JobID, func(job *etcd.JobEtcd) error {
+		if err := core.ValidateReport(*job, result, device); err != nil {
+			return err
+		}
+		core.RecordReport(job, reported, hits, result == common.Failed, now)
+		return core.FinishAttempt(job, result, body.ErrorMsg, now)
+	})
+	if err != nil {
+		log.Error("[Report] Update Job Error", zap.Error(err), zap.String("job_id", body.JobID), zap.String("device", device))
+		return 0, apiError(err)
+	}
+	rest := mysql.DataNoResult
+	if result == common.Failed {
+		rest = mysql.DataFailed
+	}
+	goAsyncJob(metrics.BackendMysql, job.ID, func() {
+		a.updateDataStatus(job.ID, nil, rest)
+	})
+	return reported, nil
+}
+
+// appendReport 追加上报 重试的追加请求中已上报过的号码直接忽略
+func (a *API) appendReport(device string, body ReportBody) (int, error) {
+	job, err := a.Stores.Jobs.JobGetWithID(body.JobID)
+	if err == nil {
+		err = core.ValidateAppend(job, device)
+	}
+	var datas []JobDetail
+	if err == nil {
+		datas, err = a.claimReported(job, body.JobDetail)
+	}
+	if err != nil {
+		log.Error("[ReportAppend] Report Rejected", zap.Error(err), zap.String("job_id", body.JobID), zap.String("device", device))
+		return 0, apiError(err)
+	}
+	a.saveResults(job, datas)
+	metrics.RecordsIngested.WithLabelValues("0").Add(float64(len(datas)))
+
+	// 追加的号码均已上报过时只延长执行期限
+	// 校验后本次执行被并发的最终上报结束时 仍需补记已修改状态的号码
+	attempt, reported, hits := len(job.History), len(detailPhones(datas)), countHits(datas)
+	_, err = a.Stores.Jobs.JobUpdate(body.JobID, func(job *etcd.JobEtcd) error {
+		if n := len(job.History); n != attempt || job.History[n-1].Device != device {
+			return core.ErrWrongDevice
+		}
+		core.AppendReport(job, reported, hits, time.Now())
+		return nil
+	})
+	if err != nil {
+		log.Error("[ReportAppend] Update Job Error", zap.Error(err), zap.String("job_id", body.JobID), zap.String("device", device))
+		return 0, apiError(err)
+	}
+	return reported, nil
+}
+
+// claimReported 将上报的号码由已下发改为成功或无结果 返回实际修改的号码的结果
+// 已上报过的号码忽略 有未下发的号码时返回*core.UnknownPhonesError 此时不做修改
+// 首次下发的号码可能还未导入mysql 此时不校验 导入完成后再修改状态
+func (a *API) claimReported(job etcd.JobEtcd, datas []JobDetail) ([]JobDetail, error) {
+	phones := detailPhones(datas)
+	if len(phones) == 0 {
+		return nil, nil
+	}
+	ds, err := a.Stores.JobData.FetchPhones(job.ID, phones)
+	if err != nil {
+		return nil, err
+	}
+	if len(ds) == 0 && len(job.History) == 1 {
+		if all, err := a.Stores.JobData.BatchFetch(job.ID); err != nil || len(all) == 0 {
+			goAsyncJob(metrics.BackendMysql, job.ID, func() {
+				a.updateDataStatus(job.ID, datas, 0)
+			})
+			return datas, err
+		}
+	}
+	status := make(map[string]int8, len(ds))
+	for _, d := range ds {
+		status[d.Phone.String] = d.Status
+	}
+	unknown := []string{}
+	for _, p := range phones {
+		switch status[p] {
+		case mysql.DataDispatched, mysql.DataSucceeded, mysql.DataNoResult:
+		default:
+			unknown = append(unknown, p)
+		}
+	}
+	if len(unknown) != 0 {
+		return nil, &core.UnknownPhonesError{Phones: unknown}
+	}
+
+	hit, miss := splitHits(datas)
+	claimed := map[string]bool{}
+	from := []int8{mysql.DataDispatched}
+	for _, u := range []struct {
+		phones []string
+		to     int8
+	}{
+		{hit, mysql.DataSucceeded},
+		{miss, mysql.DataNoResult},
+	} {
+		ps, err := a.Stores.JobData.ClaimStatus(job.ID, u.phones, from, u.to)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ps {
+			claimed[p] = true
+		}
+	}
+	rest := []JobDetail{}
+	for _, d := range datas {
+		if claimed[d.Phone] {
+			rest = append(rest, d)
+		}
+	}
+	return rest, nil
+}
+
+// reportDevice 上报的设备 与下发时的LockHolder一致
+func reportDevice(c *gin.Context) string {
+	return storage.LockHolder{WorkerID: c.Query("worker_id"), IP: c.ClientIP()}.Device()
+}
+
+// detailPhones 上报的号码 同一号码每个来源一条结果 按号码去重
+func detailPhones(datas []JobDetail) []string {
+	seen := make(map[string]bool, len(datas))
+	phones := make([]string, 0, len(datas))
+	for _, d := range datas {
+		if !seen[d.Phone] {
+			seen[d.Phone] = true
+			phones = append(phones, d.Phone)
+		}
+	}
+	return phones
+}
+
+// countHits 任一来源有结果的号码数
+func countHits(datas []JobDetail) int {
+	hits := map[string]bool{}
+	for _, d := range datas {
+		if mongo.IsHit(d.Result) {
+			hits[d.Phone] = true
+		}
+	}
+	return len(hits)
+}
+
+// splitHits 按号码分为有结果及无结果 任一来源有结果的号码为有结果
+func splitHits(datas []JobDetail) (hit, miss []string) {
+	hits := map[string]bool{}
+	for _, d := range datas {
+		if mongo.IsHit(d.Result) {
+			hits[d.Phone] = true
+		}
+	}
+	for _, p := range detailPhones(datas) {
+		if hits[p] {
+			hit = append(hit, p)
+		} else {
+			miss = append(miss, p)
+		}
+	}
+	return
+}
+
+// saveResults 结果异步写入mongo 出错时打印到日志文件
+func (a *API) saveResults(job etcd.JobEtcd, datas []JobDetail) {
+	if len(datas) == 0 {
+		return
+	}
+	goAsync(metrics.BackendMongo, func() {
+		var docs = []mongo.CrawlResult{}
+		for _, data := range datas {
+			fields := mongo.ParseResult(data.Result)
+			doc := mongo.CrawlResult{
+				ID:        bson.NewObjectId(),
+				Phone:     data.Phone,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+				Name:      fields.Name,
+				Tag:       fields.Tag,
+				Category:  fields.Category,
+				Source:    data.Source,
+				Result:    data.Result,
+				Batch:     data.Batch,
+				JobID:     job.ID,
+				Type:      data.Type,
+			}
+			docs = append(docs, doc)
+		}
+		if err := a.Stores.Results.CrawlResultInsert(docs); err != nil {
+			records, _ := json.Marshal(datas)
+			log.Error("[Report] data report error", zap.Error(err),
+				zap.String("phones", string(records)))
+		}
+	})
+}
+
+// updateDataStatus 更新号码的抓取状态
+// 有结果的号码为成功 其余上报的号码为无结果 rest不为0时未上报的号码改为rest
+func (a *API) updateDataStatus(jobID string, datas []JobDetail, rest int8) {
+	hit, miss := splitHits(datas)
+	from := []int8{mysql.DataDispatched}
+	update := func(phones []string, to int8) {
+		if _, err := a.Stores.JobData.UpdateStatus(jobID, phones, from, to); err != nil {
+			log.Error("[Report] Update Data Status Error", zap.Error(err),
+				zap.String("job_id", jobID), zap.Int8("status", to))
+		}
+	}
+	if len(hit) != 0 {
+		update(hit, mysql.DataSucceeded)
+	}
+	if len(miss) != 0 {
+		update(miss, mysql.DataNoResult)
+	}
+	if rest != 0 {
+		update(nil, rest)
+	}
 }
 
 // FilterPool 条件筛选池
@@ -134,10 +421,8 @@ type DetailShow struct {
 
 // Show 数据展示
 // 根据任务ID 或者 批次进行查看
-func Show(c *gin.Context) {
-	res := common.ResultJob{
-		Status: http.StatusOK,
-	}
+func (a *API) Show(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
 	defer res.Done(c)
 
 	fMap := map[string]string{}
@@ -147,7 +432,7 @@ func Show(c *gin.Context) {
 	if len(rge) != 0 {
 		if err := json.Unmarshal([]byte(pRange), &rge); err != nil {
 			log.Error("[Show] Json Unmarshal range Error", zap.Error(err))
-			res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg("range is invalid")
+			res.Fail(common.NewError(common.CodeInvalidParameters, "range is invalid"))
 			return
 		}
 		if len(rge) == 2 {
@@ -158,13 +443,13 @@ func Show(c *gin.Context) {
 	fs := c.Query("filter")
 	if len(fs) == 0 {
 		log.Error("[Show] Parameter of filter is required")
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg("filter is required")
+		res.Fail(common.NewError(common.CodeInvalidParameters, "filter is required"))
 		return
 	}
 
 	if err := json.Unmarshal([]byte(fs), &fMap); err != nil {
 		log.Error("[Show] Json Unmarshal filter Error", zap.Error(err))
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg("filter is invalid")
+		res.Fail(common.NewError(common.CodeInvalidParameters, "filter is invalid"))
 		return
 	}
 
@@ -178,7 +463,7 @@ func Show(c *gin.Context) {
 	log.Debugf("[Show] Data Show With JobID: %v", filter.JobID)
 	if len(filter.JobID) == 0 {
 		log.Error("[Show] JobID is required")
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg("job_id is required")
+		res.Fail(common.NewError(common.CodeInvalidParameters, "job_id is required"))
 		return
 	}
 
@@ -188,10 +473,10 @@ func Show(c *gin.Context) {
 		"job_id": filter.JobID,
 	}
 
-	ds, err := mongo.CrawlResultGet(selecttor)
+	ds, err := a.Stores.Results.CrawlResultGet(selecttor)
 	if err != nil {
 		log.Error("[Show] Get Result From Mongo Error", zap.Error(err))
-		res.SetStatus(http.StatusInternalServerError).ErrMsg.SetMsg("Get Data Error")
+		res.Fail(apiError(err))
 		return
 	}
 
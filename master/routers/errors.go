@@ -0,0 +1,45 @@
+package routers
+
+import (
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+)
+
+// apiError 业务错误转换为接口错误 未知的错误按内部错误处理
+func apiError(err error) *common.Error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *common.Error:
+		return e
+	case *core.TransitionError:
+		return common.WrapError(common.CodeInvalidTransition, err)
+	case *core.UnknownPhonesError:
+		return common.WrapError(common.CodeUnknownPhones, err)
+	}
+	code := common.CodeInternal
+	switch err {
+	case ErrInvalidEnvType:
+		code = common.CodeInvalidParameters
+	case errBodyTooLarge:
+		code = common.CodeBodyTooLarge
+	case core.ErrWrongDevice:
+		code = common.CodeWrongDevice
+	case storage.ErrJobNotFound:
+		code = common.CodeJobNotFound
+	case core.ErrNoJobMatched:
+		code = common.CodeNoJobMatched
+	case ErrNoDataMatched:
+		code = common.CodeNoDataMatched
+	case storage.ErrLockNotFound:
+		code = common.CodeLockNotFound
+	case storage.ErrWebhookNotFound:
+		code = common.CodeWebhookNotFound
+	case storage.ErrJobConflict:
+		code = common.CodeJobConflict
+	case storage.ErrLockOccupied, storage.ErrLockFenced:
+		code = common.CodeLockConflict
+	}
+	return common.WrapError(code, err)
+}
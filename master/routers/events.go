@@ -0,0 +1,117 @@
+package routers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+
+	"github.com/MolenZhang/log"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// eventsHeartbeat SSE心跳间隔 避免代理断开空闲连接
+var eventsHeartbeat = 15 * time.Second
+
+// streams 退出时通知所有事件流结束
+type streams struct {
+	init  sync.Once
+	close sync.Once
+	done  chan struct{}
+}
+
+func (s *streams) closing() <-chan struct{} {
+	s.init.Do(func() { s.done = make(chan struct{}) })
+	return s.done
+}
+
+// CloseStreams 结束所有事件流
+// http.Server.Shutdown会等待进行中的事件流 需通过RegisterOnShutdown注册 否则退出时会一直等到超时
+func (a *API) CloseStreams() {
+	a.streams.closing()
+	a.streams.close.Do(func() { close(a.streams.done) })
+}
+
+// EventFilter 事件筛选条件 为空时不筛选
+type EventFilter struct {
+	JobID     string
+	CrawlType int
+}
+
+// Match .
+func (f EventFilter) Match(e storage.JobEvent) bool {
+	if len(f.JobID) != 0 && e.Job.ID != f.JobID {
+		return false
+	}
+	if f.CrawlType != 0 && e.Job.CrawlType != f.CrawlType {
+		return false
+	}
+	return true
+}
+
+// Events 任务事件流 Server-Sent Events
+// 支持job_id/crawl_type筛选 断线重连时根据Last-Event-ID从对应的etcd版本继续
+// 连接在服务端WriteTimeout到期后断开 由客户端自动重连
+func (a *API) Events(c *gin.Context) {
+	filter := EventFilter{JobID: c.Query("job_id")}
+	if ct := c.Query("crawl_type"); len(ct) != 0 {
+		v, err := strconv.Atoi(ct)
+		if err != nil {
+			log.Errorf("[Events] Parameter crawl_type is invalid: %v", ct)
+			common.Write(c, common.EnvelopeRaw, nil, common.NewError(common.CodeInvalidParameters, "crawl_type is invalid"))
+			return
+		}
+		filter.CrawlType = v
+	}
+	var rev int64
+	if id := c.GetHeader("Last-Event-ID"); len(id) != 0 {
+		if v, err := strconv.ParseInt(id, 10, 64); err == nil {
+			rev = v + 1
+		}
+	}
+
+	ctx := c.Request.Context()
+	events := a.Stores.Events.JobWatch(ctx, rev)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	closing := a.streams.closing()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closing:
+			// 进程退出 客户端会重连到其他节点
+			return
+		case e, ok := <-events:
+			if !ok {
+				// watch异常结束 客户端会自动重连
+				return
+			}
+			if !filter.Match(e) {
+				continue
+			}
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatInt(e.Revision, 10),
+				Event: e.Type,
+				Data:  e,
+			})
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(":heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
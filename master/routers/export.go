@@ -0,0 +1,259 @@
+package routers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
+
+	"github.com/MolenZhang/log"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 导出格式
+const (
+	ExportCSV    = "csv"
+	ExportXLSX   = "xlsx"
+	ExportNDJSON = "ndjson"
+)
+
+// 每写入多少行刷新一次输出
+const exportFlushRows = 500
+
+// exportHeader 导出列名
+var exportHeader = []string{
+	"id", "job_id", "phone", "batch", "name", "tag", "category",
+	"crawl_source", "crawl_source_name", "crawl_type", "crawl_type_name",
+	"crawl_result", "create_time",
+}
+
+// ExportRow 导出的单条结果
+type ExportRow struct {
+	ID              string `json:"id"`
+	JobID           string `json:"job_id"`
+	Phone           string `json:"phone"`
+	Batch           string `json:"batch"`
+	Name            string `json:"name"`
+	Tag             string `json:"tag"`
+	Category        string `json:"category"`
+	CrawlSource     int    `json:"crawl_source"`
+	CrawlSourceName string `json:"crawl_source_name"` // 1-360手机卫士;2-搜狗号码通;3-电话邦
+	CrawlType       int    `json:"crawl_type"`
+	CrawlTypeName   string `json:"crawl_type_name"` // 1-模拟器;2-云手机;3-api
+	CrawlResult     string `json:"crawl_result"`
+	CreatedAt       string `json:"create_time"`
+}
+
+// newExportRow .
+func newExportRow(d mongo.CrawlResult) ExportRow {
+	// 早期入库的结果没有解析名称、标记及分类 导出时从结果中解析
+	if len(d.Name) == 0 && len(d.Tag) == 0 && len(d.Category) == 0 {
+		f := mongo.ParseResult(d.Result)
+		d.Name, d.Tag, d.Category = f.Name, f.Tag, f.Category
+	}
+	return ExportRow{
+		ID:              d.ID.Hex(),
+		JobID:           d.JobID,
+		Phone:           d.Phone,
+		Batch:           d.Batch,
+		Name:            d.Name,
+		Tag:             d.Tag,
+		Category:        d.Category,
+		CrawlSource:     d.Source,
+		CrawlSourceName: parseCrawlSource(d.Source),
+		CrawlType:       d.Type,
+		CrawlTypeName:   parseCrawlType(d.Type),
+		CrawlResult:     d.Result,
+		CreatedAt:       d.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// cells 按exportHeader的顺序输出
+func (r ExportRow) cells() []string {
+	return []string{
+		r.ID, r.JobID, r.Phone, r.Batch, r.Name, r.Tag, r.Category,
+		strconv.Itoa(r.CrawlSource), r.CrawlSourceName,
+		strconv.Itoa(r.CrawlType), r.CrawlTypeName,
+		r.CrawlResult, r.CreatedAt,
+	}
+}
+
+// rowWriter 不同导出格式的统一写入
+type rowWriter interface {
+	WriteRow(ExportRow) error
+	Flush() error
+	Close() error
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func newCSVRowWriter(w io.Writer) (rowWriter, error) {
+	// 写入BOM 避免excel打开中文乱码
+	if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return nil, err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader); err != nil {
+		return nil, err
+	}
+	return &csvRowWriter{w: cw}, nil
+}
+
+func (cw *csvRowWriter) WriteRow(r ExportRow) error {
+	return cw.w.Write(r.cells())
+}
+
+func (cw *csvRowWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvRowWriter) Close() error {
+	return cw.Flush()
+}
+
+type xlsxRowWriter struct {
+	w *common.XLSXWriter
+}
+
+func newXLSXRowWriter(w io.Writer) (rowWriter, error) {
+	xw, err := common.NewXLSXWriter(w, "result")
+	if err != nil {
+		return nil, err
+	}
+	if err := xw.WriteRow(exportHeader); err != nil {
+		return nil, err
+	}
+	return &xlsxRowWriter{w: xw}, nil
+}
+
+func (xw *xlsxRowWriter) WriteRow(r ExportRow) error {
+	return xw.w.WriteRow(r.cells())
+}
+
+func (xw *xlsxRowWriter) Flush() error {
+	return xw.w.Flush()
+}
+
+func (xw *xlsxRowWriter) Close() error {
+	return xw.w.Close()
+}
+
+type ndjsonRowWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONRowWriter(w io.Writer) (rowWriter, error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &ndjsonRowWriter{enc: enc}, nil
+}
+
+func (nw *ndjsonRowWriter) WriteRow(r ExportRow) error {
+	return nw.enc.Encode(r)
+}
+
+func (nw *ndjsonRowWriter) Flush() error {
+	return nil
+}
+
+func (nw *ndjsonRowWriter) Close() error {
+	return nil
+}
+
+// exportFormats 导出格式对应的content-type及写入器
+var exportFormats = map[string]struct {
+	contentType string
+	newWriter   func(io.Writer) (rowWriter, error)
+}{
+	ExportCSV:    {"text/csv; charset=utf-8", newCSVRowWriter},
+	ExportXLSX:   {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", newXLSXRowWriter},
+	ExportNDJSON: {"application/x-ndjson", newNDJSONRowWriter},
+}
+
+// Export 结果导出
+// 筛选条件与Show一致 支持csv/xlsx/ndjson 数据通过mongo游标边读边写
+func (a *API) Export(c *gin.Context) {
+	format := c.DefaultQuery("format", ExportCSV)
+	f, ok := exportFormats[format]
+	if !ok {
+		log.Errorf("[Export] Unsupported Format: %v", format)
+		common.Write(c, common.EnvelopeRaw, nil, common.NewError(common.CodeInvalidParameters, "format is invalid"))
+		return
+	}
+
+	fs := c.Query("filter")
+	if len(fs) == 0 {
+		log.Error("[Export] Parameter of filter is required")
+		common.Write(c, common.EnvelopeRaw, nil, common.NewError(common.CodeInvalidParameters, "filter is required"))
+		return
+	}
+	fMap := map[string]string{}
+	if err := json.Unmarshal([]byte(fs), &fMap); err != nil {
+		log.Error("[Export] Json Unmarshal filter Error", zap.Error(err))
+		common.Write(c, common.EnvelopeRaw, nil, common.NewError(common.CodeInvalidParameters, "filter is invalid"))
+		return
+	}
+	filter := FilterPool{
+		JobID: parseParams("job_id", fMap),
+		Batch: parseParams("batch", fMap),
+	}
+	if len(filter.JobID) == 0 && len(filter.Batch) == 0 {
+		log.Error("[Export] JobID or Batch is required")
+		common.Write(c, common.EnvelopeRaw, nil, common.NewError(common.CodeInvalidParameters, "job_id or batch is required"))
+		return
+	}
+
+	name := filter.JobID
+	if len(name) == 0 {
+		name = filter.Batch
+	}
+	c.Header("Content-Type", f.contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="greedy-%s.%s"`, name, format))
+	c.Status(http.StatusOK)
+
+	w, err := f.newWriter(c.Writer)
+	if err != nil {
+		log.Error("[Export] Create Writer Error", zap.Error(err))
+		return
+	}
+
+	rows := 0
+	selector := map[string]string{
+		"job_id": filter.JobID,
+		"batch":  filter.Batch,
+	}
+	err = a.Stores.Results.CrawlResultIter(selector, func(d mongo.CrawlResult) error {
+		if err := w.WriteRow(newExportRow(d)); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// 响应头已发出 只能记录日志 客户端会收到不完整的文件
+		log.Error("[Export] Export Result Error", zap.Error(err),
+			zap.String("job_id", filter.JobID), zap.String("batch", filter.Batch),
+			zap.Int("rows", rows))
+		return
+	}
+	if err := w.Close(); err != nil {
+		log.Error("[Export] Close Writer Error", zap.Error(err))
+		return
+	}
+	log.Infof("[Export] Export %v Rows With Filter: %v, Format: %v", rows, fMap, format)
+}
@@ -0,0 +1,87 @@
+package routers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/config"
+
+	"github.com/MolenZhang/log"
+	"github.com/gin-gonic/gin"
+)
+
+// 检查结果
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Checker 依赖检查
+type Checker func(ctx context.Context) error
+
+// CheckResult 单个依赖的检查结果
+type CheckResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ReadyResp 就绪检查结果
+type ReadyResp struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Healthz 存活检查 进程能处理请求即可
+func (a *API) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": StatusUp})
+}
+
+// Readyz 就绪检查 并发检查所有依赖 任一不可用时返回503
+func (a *API) Readyz(c *gin.Context) {
+	timeout := config.Cfg.Server.CheckTimeout
+	if timeout == 0 {
+		timeout = 3 * time.Second
+	}
+
+	resp := ReadyResp{
+		Status: StatusUp,
+		Checks: map[string]CheckResult{},
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range a.Checkers {
+		wg.Add(1)
+		go func(name string, check Checker) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			defer cancel()
+			start := time.Now()
+			err := check(ctx)
+			r := CheckResult{
+				Status:  StatusUp,
+				Latency: time.Since(start).String(),
+			}
+			if err != nil {
+				r.Status = StatusDown
+				r.Error = err.Error()
+				log.Errorf("[Readyz] Check %v Error: %v", name, err)
+			}
+			mu.Lock()
+			resp.Checks[name] = r
+			if err != nil {
+				resp.Status = StatusDown
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if resp.Status != StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, resp)
+}
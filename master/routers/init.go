@@ -3,39 +3,87 @@ package routers
 import (
 	"net/http"
 
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/config"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Handler .
-var Handler *gin.Engine
+// API 接口依赖的存储 由调用方注入
+type API struct {
+	Stores   storage.Stores
+	Checkers map[string]Checker // 就绪检查的依赖项
+
+	MaxBodyBytes int64                  // 请求体大小上限 为0时使用DefaultMaxBodyBytes
+	Simulator    config.SimulatorConfig // 模拟器下发策略 未配置的项使用默认值
+
+	streams streams // 进行中的事件流 退出时结束
+}
 
-func init() {
-	Handler = gin.New()
-	Handler.Use(gin.Recovery())
+// NewHandler 注册路由
+func NewHandler(api *API) *gin.Engine {
+	handler := gin.New()
+	handler.Use(gin.Recovery(), metrics.GinMiddleware(handler), limitBody(api.MaxBodyBytes))
 
-	Handler.GET("/ping", func(c *gin.Context) {
+	handler.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "STATUS OK")
 	})
+	handler.GET("/healthz", api.Healthz)                   // 存活检查
+	handler.GET("/readyz", api.Readyz)                     // 就绪检查 etcd/mongo/mysql
+	handler.GET("/metrics", gin.WrapH(promhttp.Handler())) // prometheus指标
 
-	greedy := Handler.Group("/greedy/")
+	greedy := handler.Group("/greedy/")
+	greedy.GET("/openapi.json", api.OpenAPI) // OpenAPI文档 请求体在进入handler前按validate标签校验
 
 	// 任务相关
 	job := greedy.Group("/job/")
 	{
-		job.POST("/", JobSave)        // 增加任务
-		job.GET("/:id", JobGet)       // 任务列表
-		job.DELETE("/:id", JobDelete) // 删除任务
-		job.GET("/", JobLists)        // 任务列表
-		job.PUT("/")                  // 理论上不需要 任务一旦处于可执行状态是无法修改的 如果要修改任务 建议重新创建任务
-		job.POST("/kill")             // TODO 强杀任务
+		job.POST("/", validateBody(WebJob{}, common.EnvelopeJob), api.JobSave) // 增加任务
+		job.GET("/:id", api.JobGet)                                            // 任务列表
+		job.DELETE("/:id", api.JobDelete)                                      // 删除任务
+		job.GET("/", api.JobLists)                                             // 任务列表
+		job.PUT("/:id/pause", api.JobPause)                                    // 暂停周期任务
+		job.PUT("/:id/resume", api.JobResume)                                  // 恢复周期任务
+		job.PUT("/")                                                           // 理论上不需要 任务一旦处于可执行状态是无法修改的 如果要修改任务 建议重新创建任务
+		job.POST("/:id/rerun", api.JobRerun)                                   // 重跑任务 only=failed时只重跑失败的号码
+		job.POST("/:id/kill")                                                  // TODO 强杀任务
 	}
 
 	// 数据相关
 	data := greedy.Group("/data/")
 	{
-		data.GET("/phones", Phones)  // 下发给云手机的号码数据
-		data.POST("/report", Report) // 号码结果上报
-		data.GET("/", Show)          // 库里所有已抓取数据结果展示
+		data.GET("/phones", api.Phones)                                                                  // 下发给云手机的号码数据
+		data.POST("/report", validateBody(ReportBody{}, common.EnvelopeResult), api.Report)              // 号码结果上报 并结束任务
+		data.POST("/report/append", validateBody(ReportBody{}, common.EnvelopeResult), api.ReportAppend) // 追加上报部分号码 任务保持执行中
+		data.POST("/report/complete", validateBody(ReportBody{}, common.EnvelopeResult), api.Report)     // 追加上报后结束任务
+		data.POST("/heartbeat", api.JobHeartbeat)                                                        // 执行期间的心跳 延长执行期限
+		data.GET("/", api.Show)                                                                          // 库里所有已抓取数据结果展示
+		data.GET("/export", api.Export)                                                                  // 结果导出 csv/xlsx/ndjson
+	}
+
+	// 统计相关
+	greedy.GET("/stats", api.Stats) // 任务及结果统计面板
+
+	// 任务事件流
+	greedy.GET("/events", api.Events)
+
+	// 任务锁相关
+	locks := greedy.Group("/locks")
+	{
+		locks.GET("", api.LockLists)          // 当前持有的任务锁
+		locks.DELETE("/:job", api.LockRevoke) // 强制释放任务锁
+	}
+
+	// webhook相关
+	webhooks := greedy.Group("/webhooks")
+	{
+		webhooks.GET("", api.WebhookLists)                                                 // 所有webhook
+		webhooks.POST("", validateBody(WebWebhook{}, common.EnvelopeJob), api.WebhookSave) // 创建webhook
+		webhooks.DELETE("/:id", api.WebhookDelete)                                         // 删除webhook
+		webhooks.GET("/:id/deliveries", api.WebhookDeliveries)                             // 投递记录
 	}
 
 	// TODO 策略相关
@@ -47,4 +95,5 @@ func init() {
 		policy.GET("/fetch")     // 获取单个策略
 		policy.GET("/list")      // 获取所有策略
 	}
+	return handler
 }
@@ -2,15 +2,15 @@ package routers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"strconv"
 	"time"
 
 	"code.safe.molen.com/molen/haoma/greedy/master/common"
 	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
 	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
-	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
 	mysql "code.safe.molen.com/molen/haoma/greedy/master/storage/mysql"
 
 	"github.com/MolenZhang/log"
@@ -22,14 +22,43 @@ import (
 // WebJob 定义平台任务相关字段
 type WebJob struct {
 	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Batch       string `json:"batch"`
-	Count       int    `json:"count"`
+	Name        string `json:"name" binding:"required"`
+	Batch       string `json:"batch" binding:"required"`
+	Count       int    `json:"count" binding:"min=0"`
 	CreatedAt   string `json:"create_time"`
-	Status      string `json:"status"` // 1-未执行 2-执行中 3-执行完成
+	Status      string `json:"status"` // 1-未执行 2-执行中 3-执行完成 4-重试次数用尽
 	Description string `json:"desc"`
-	CrawlType   string `json:"crawl_type"`   // 执行方式 1-模拟器;2-云手机;3-api
-	Result      string `json:"crawl_result"` // 执行结果 1-成功 2-失败
+	CrawlType   string `json:"crawl_type" binding:"required,eq=1|eq=2|eq=3"` // 执行方式 1-模拟器;2-云手机;3-api
+	Priority    int    `json:"priority"`                                     // 优先级 越大越优先
+	Result      string `json:"crawl_result"`                                 // 执行结果 1-成功 2-失败
+	Schedule    string `json:"schedule,omitempty"`                           // cron表达式 不为空时为周期任务
+	Paused      bool   `json:"paused,omitempty"`                             // 周期任务是否暂停
+	ParentID    string `json:"parent_id,omitempty"`                          // 周期任务的执行 对应的任务定义id
+	RunID       int    `json:"run_id,omitempty"`                             // 周期任务的第几次执行
+	NextRunAt   string `json:"next_run_at,omitempty"`                        // 周期任务下次触发的时间
+	MaxAttempts int    `json:"max_attempts" binding:"min=0"`                 // 最大执行次数 默认3次
+	Attempts    int    `json:"attempts"`                                     // 已执行次数
+
+	MinAppVersion string `json:"min_app_version,omitempty"` // 模拟器要求的最低app版本 如8.2.0
+
+	Backoff  etcd.BackoffPolicy `json:"backoff"`  // 失败后的退避策略 默认1分钟起每次翻倍 最长1小时
+	Progress core.Progress      `json:"progress"` // 执行进度 仅在查询时返回
+}
+
+// JobView 任务详情
+type JobView struct {
+	etcd.JobEtcd
+	NextRunAt string        `json:"next_run_at,omitempty"` // 周期任务下次触发的时间
+	Progress  core.Progress `json:"progress"`              // 执行进度 覆盖任务中的原始计数
+}
+
+// newJobView .
+func newJobView(job etcd.JobEtcd) JobView {
+	v := JobView{JobEtcd: job, Progress: core.ProgressOf(job, time.Now())}
+	if next, ok := core.NextRunTime(job); ok {
+		v.NextRunAt = next.Format(core.TimeLayout)
+	}
+	return v
 }
 
 // Source 数据源
@@ -39,28 +68,41 @@ type Source struct {
 }
 
 // JobSave 保存任务
-func JobSave(c *gin.Context) {
-	res := common.ResultJob{
-		Status: http.StatusOK,
-	}
+func (a *API) JobSave(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
 	defer res.Done(c)
 
-	req := &WebJob{}
-	if err := c.BindJSON(req); err != nil {
-		log.Errorf("[JobSave] Parse Request Body Error: %v", err)
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg(err.Error())
-		return
-	}
+	req := validated(c).(*WebJob)
 	ct, err := strconv.Atoi(req.CrawlType)
 	if err != nil {
 		log.Errorf("[JobSave] Parse CrawlType Error: %v", err)
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg(err.Error())
+		res.Fail(common.NewError(common.CodeInvalidParameters, "crawl_type is invalid"))
 		return
 	}
+	if err := core.ValidateRetry(req.MaxAttempts, req.Backoff); err != nil {
+		log.Errorf("[JobSave] Validate Retry Policy Error: %v", err)
+		res.Fail(common.WrapError(common.CodeInvalidParameters, err))
+		return
+	}
+	if len(req.MinAppVersion) != 0 {
+		if _, err := core.ParseVersion(req.MinAppVersion); err != nil {
+			log.Errorf("[JobSave] Parse MinAppVersion Error: %v", err)
+			res.Fail(common.NewError(common.CodeInvalidParameters, "min_app_version is invalid"))
+			return
+		}
+	}
+	if len(req.Schedule) != 0 {
+		if _, err := core.ParseSchedule(req.Schedule); err != nil {
+			log.Errorf("[JobSave] Parse Schedule Error: %v", err)
+			res.Fail(common.NewError(common.CodeInvalidParameters, "schedule is invalid"))
+			return
+		}
+	}
 	job := etcd.JobEtcd{
 		ID:          common.GenerateID(),
 		Name:        req.Name,
 		CrawlType:   ct,
+		Priority:    req.Priority,
 		Description: req.Description,
 		CreatedAt:   time.Now().Format("2006-01-02 15:04:05"),
 		Status:      int(common.Pendding), /*未执行*/
@@ -68,17 +110,28 @@ func JobSave(c *gin.Context) {
 			Batch: req.Batch,
 			Count: req.Count,
 		},
+		Schedule:      req.Schedule,
+		MinAppVersion: req.MinAppVersion,
 	}
-	if _, err := core.JobMgr.JobSave(job); err != nil {
+	job.MaxAttempts, job.Backoff = core.RetryPolicy(etcd.JobEtcd{MaxAttempts: req.MaxAttempts, Backoff: req.Backoff})
+	if _, err := a.Stores.Jobs.JobSave(job); err != nil {
 		log.Errorf("[JobSave] JobSave Error: %v", err)
-		res.SetStatus(http.StatusInternalServerError).ErrMsg.SetMsg(err.Error())
+		res.Fail(apiError(err))
 		return
 	}
-	// 此时将任务相关的数据从mongo中 导入到mysql中
-	// TODO 此处待优化
-	go func(job etcd.JobEtcd) {
+	// 周期任务的定义不导入数据 每次执行创建时再导入
+	if len(job.Schedule) == 0 {
+		a.ImportJobData(job)
+	}
+	res.SetData(newJobView(job))
+}
+
+// ImportJobData 将任务相关的数据从mongo中 导入到mysql中
+// TODO 此处待优化
+func (a *API) ImportJobData(job etcd.JobEtcd) {
+	goAsyncJob(metrics.BackendMysql, job.ID, func() {
 		ins := []mysql.JobDatum{}
-		dsrc, err := mongo.CrawlSourceGet(job.Source.Batch, job.Source.Count)
+		dsrc, err := a.Stores.Sources.CrawlSourceGet(job.Source.Batch, job.Source.Count)
 		if err != nil {
 			log.Error("[JobSave] Get Source Data Error", zap.Error(err),
 				zap.String("JobName", job.Name), zap.String("JobID", job.ID))
@@ -94,44 +147,60 @@ func JobSave(c *gin.Context) {
 			}
 			ins = append(ins, jd)
 		}
-		mysql.BatchInsert(ins)
-	}(job)
-	res.SetData(job)
+		a.Stores.JobData.BatchInsert(ins)
+
+		// 导入完成前已被其他节点首次下发时 号码仍为待下发 需改为已下发
+		// 首次下发的号码即导入的全部号码 本节点的下发及上报在队列中排在导入之后
+		cur, err := a.Stores.Jobs.JobGetWithID(job.ID)
+		if err != nil || core.StateOf(cur) != core.StateRunning || len(cur.History) != 1 {
+			return
+		}
+		from := []int8{mysql.DataPending}
+		if _, err := a.Stores.JobData.UpdateStatus(job.ID, nil, from, mysql.DataDispatched); err != nil {
+			log.Error("[JobSave] Update Data Status Error", zap.Error(err), zap.String("job_id", job.ID))
+		}
+	})
+}
+
+// ReleaseJobData 执行超时回收后 本次下发但未上报的号码改为失败 重试时重新下发
+func (a *API) ReleaseJobData(job etcd.JobEtcd) {
+	goAsyncJob(metrics.BackendMysql, job.ID, func() {
+		from := []int8{mysql.DataDispatched}
+		if _, err := a.Stores.JobData.UpdateStatus(job.ID, nil, from, mysql.DataFailed); err != nil {
+			log.Error("[ReleaseJobData] Update Data Status Error", zap.Error(err), zap.String("job_id", job.ID))
+		}
+	})
 }
 
 // JobDelete 删除任务
-func JobDelete(c *gin.Context) {
-	res := common.ResultJob{
-		Status: http.StatusOK,
-	}
+func (a *API) JobDelete(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
 	defer res.Done(c)
 	id := c.Param("id")
 	if len(id) == 0 {
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg("id is required")
+		res.Fail(common.NewError(common.CodeInvalidParameters, "id is required"))
 		log.Error("[JobDelete] Parameter id is required")
 		return
 	}
 
-	job, err := core.JobMgr.JobGetWithID(id)
+	job, err := a.Stores.Jobs.JobGetWithID(id)
 	if err != nil {
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg(err.Error())
+		res.Fail(apiError(err))
 		log.Errorf("[JobDelete] No Job Matched With ID: %v", id)
 		return
 	}
 
-	if _, err := core.JobMgr.JobDelete(job.Name); err != nil {
+	if _, err := a.Stores.Jobs.JobDelete(job.Name); err != nil {
 		log.Error("[JobDelete] Delete Job Error", zap.Error(err))
-		res.SetStatus(http.StatusInternalServerError).ErrMsg.SetMsg(err.Error())
+		res.Fail(apiError(err))
 		return
 	}
 	res.SetData(gin.H{"ok": true})
 }
 
 // JobLists 任务列表
-func JobLists(c *gin.Context) {
-	res := common.ResultJob{
-		Status: http.StatusOK,
-	}
+func (a *API) JobLists(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
 	defer res.Done(c)
 	l := Limit{Start: 0, End: 999999}
 
@@ -139,7 +208,7 @@ func JobLists(c *gin.Context) {
 	pRange := c.Query("range")
 	if len(pRange) != 0 {
 		if err := json.Unmarshal([]byte(pRange), &rge); err != nil {
-			res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg("range is invalid")
+			res.Fail(common.NewError(common.CodeInvalidParameters, "range is invalid"))
 			log.Error("[JobLists] Parameter range is invalid")
 			return
 		}
@@ -148,26 +217,42 @@ func JobLists(c *gin.Context) {
 		}
 	}
 
-	jobs, err := core.JobMgr.JobLists()
+	jobs, err := a.Stores.Jobs.JobLists()
 	if err != nil {
-		res.SetStatus(http.StatusInternalServerError).ErrMsg.SetMsg(err.Error())
+		res.Fail(apiError(err))
 		log.Errorf("[JobLists] Get Job Lists Error: %v", err)
 		return
 	}
 
 	resp := []WebJob{}
+	now := time.Now()
 	if len(jobs) != 0 {
 		for i, job := range jobs {
 			if (i <= l.End) && (i >= l.Start) {
 				tmp := WebJob{
-					ID:        job.ID,
-					Name:      job.Name,
-					Batch:     job.Source.Batch,
-					Count:     job.Source.Count,
-					CreatedAt: job.CreatedAt,
-					Status:    strconv.Itoa(job.Status),
-					CrawlType: strconv.Itoa(job.CrawlType),
-					Result:    strconv.Itoa(job.Result),
+					ID:          job.ID,
+					Name:        job.Name,
+					Batch:       job.Source.Batch,
+					Count:       job.Source.Count,
+					CreatedAt:   job.CreatedAt,
+					Status:      strconv.Itoa(job.Status),
+					CrawlType:   strconv.Itoa(job.CrawlType),
+					Priority:    job.Priority,
+					MaxAttempts: job.MaxAttempts,
+					Attempts:    job.Attempts,
+					Backoff:     job.Backoff,
+					Result:      strconv.Itoa(job.Result),
+					Schedule:    job.Schedule,
+					Paused:      job.Paused,
+					ParentID:    job.ParentID,
+					RunID:       job.RunID,
+
+					MinAppVersion: job.MinAppVersion,
+
+					Progress: core.ProgressOf(job, now),
+				}
+				if next, ok := core.NextRunTime(job); ok {
+					tmp.NextRunAt = next.Format(core.TimeLayout)
 				}
 				resp = append(resp, tmp)
 			}
@@ -178,28 +263,143 @@ func JobLists(c *gin.Context) {
 }
 
 // JobKill 强杀任务
-func JobKill(c *gin.Context) {}
+func (a *API) JobKill(c *gin.Context) {}
 
 // JobGet 获取单个任务
-func JobGet(c *gin.Context) {
-	res := common.ResultJob{
-		Status: http.StatusOK,
-	}
+func (a *API) JobGet(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
 	defer res.Done(c)
 
 	id := c.Param("id")
 	if len(id) == 0 {
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg("id is required")
+		res.Fail(common.NewError(common.CodeInvalidParameters, "id is required"))
 		log.Error("[JobGet] Parameter id is required")
 		return
 	}
 
-	job, err := core.JobMgr.JobGetWithID(id)
+	job, err := a.Stores.Jobs.JobGetWithID(id)
 	if err != nil {
-		res.SetStatus(http.StatusBadRequest).ErrMsg.SetMsg(err.Error())
+		res.Fail(apiError(err))
 		log.Errorf("[JobGet] No Job Matched With ID: %v", id)
 		return
 	}
 
-	res.SetData(job)
+	res.SetData(newJobView(job))
+}
+
+// JobPause 暂停周期任务
+func (a *API) JobPause(c *gin.Context) {
+	a.jobSetPaused(c, true)
+}
+
+// JobResume 恢复周期任务 暂停期间错过的触发不再补
+func (a *API) JobResume(c *gin.Context) {
+	a.jobSetPaused(c, false)
+}
+
+// errUnchanged 状态未变化 无需写入
+var errUnchanged = errors.New("job is unchanged")
+
+func (a *API) jobSetPaused(c *gin.Context, paused bool) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	id := c.Param("id")
+	job, err := a.Stores.Jobs.JobUpdate(id, func(job *etcd.JobEtcd) error {
+		if len(job.Schedule) == 0 {
+			return common.NewError(common.CodeInvalidParameters, "job is not scheduled")
+		}
+		if job.Paused == paused {
+			return errUnchanged
+		}
+		job.Paused = paused
+		if !paused {
+			job.LastRunAt = time.Now().Format(core.TimeLayout)
+		}
+		return nil
+	})
+	if err == errUnchanged {
+		job, err = a.Stores.Jobs.JobGetWithID(id)
+	}
+	if err != nil {
+		log.Errorf("[JobPause] Job: %v, JobUpdate Error: %v", id, err)
+		res.Fail(apiError(err))
+		return
+	}
+	res.SetData(newJobView(job))
+}
+
+// JobRerunResp 重跑结果
+type JobRerunResp struct {
+	JobView
+	Requeued int64 `json:"requeued"` // 重新待下发的号码数
+}
+
+// JobRerun 重跑任务
+// only=failed 时只重跑失败的号码 否则重跑所有号码
+func (a *API) JobRerun(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	var from []int8
+	switch only := c.Query("only"); only {
+	case "":
+	case "failed":
+		from = []int8{mysql.DataFailed}
+	default:
+		res.Fail(common.NewError(common.CodeInvalidParameters, "only is invalid"))
+		log.Errorf("[JobRerun] Parameter only is invalid: %v", only)
+		return
+	}
+
+	id := c.Param("id")
+	job, err := a.Stores.Jobs.JobGetWithID(id)
+	if err != nil {
+		res.Fail(apiError(err))
+		log.Errorf("[JobRerun] No Job Matched With ID: %v", id)
+		return
+	}
+	if len(job.Schedule) != 0 {
+		res.Fail(common.NewError(common.CodeInvalidParameters, "scheduled job can not be rerun"))
+		return
+	}
+	ds, err := a.Stores.JobData.BatchFetch(job.ID, from...)
+	if err != nil {
+		log.Error("[JobRerun] Fetch Data Error", zap.Error(err), zap.String("job_id", job.ID))
+		res.Fail(apiError(err))
+		return
+	}
+	if len(ds) == 0 {
+		res.Fail(common.NewError(common.CodeInvalidParameters, "no numbers to rerun"))
+		return
+	}
+
+	// 先修改任务 期间被上报、心跳等修改时基于最新的任务重新校验
+	job, err = a.Stores.Jobs.JobUpdate(job.ID, func(job *etcd.JobEtcd) error {
+		if err := core.Rerun(job, time.Now()); err != nil {
+			return err
+		}
+		p := &job.Progress
+		if from == nil {
+			// 全部重跑时进度重新计算
+			*p = etcd.JobProgress{Total: len(ds)}
+		} else if p.Failed -= len(ds); p.Failed < 0 {
+			p.Failed = 0
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("[JobRerun] Update Job Error: %v", err)
+		res.Fail(apiError(err))
+		return
+	}
+	// 任务写入成功后再修改号码状态 失败的号码无需修改也会再次下发
+	n, err := a.Stores.JobData.UpdateStatus(job.ID, nil, from, mysql.DataPending)
+	if err != nil {
+		log.Error("[JobRerun] Update Data Status Error", zap.Error(err), zap.String("job_id", job.ID))
+		res.Fail(apiError(err))
+		return
+	}
+	log.Infof("[JobRerun] Job: %v Rerun, Requeued: %v", job.Name, n)
+	res.SetData(JobRerunResp{JobView: newJobView(job), Requeued: n})
 }
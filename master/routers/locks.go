@@ -0,0 +1,56 @@
+package routers
+
+import (
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+
+	"github.com/MolenZhang/log"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// LockLists 当前所有的任务锁及持有者
+func (a *API) LockLists(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	locks, err := a.Stores.Locks.LockLists(c.Request.Context())
+	if err != nil {
+		log.Error("[LockLists] Get Locks Error", zap.Error(err))
+		res.Fail(apiError(err))
+		return
+	}
+	res.SetData(locks)
+}
+
+// LockRevoke 强制释放任务锁
+// 撤销持有者的租约 持有者后续携带旧token的写入会被拒绝
+func (a *API) LockRevoke(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	job := c.Param("job")
+	info, err := a.Stores.Locks.LockRevoke(c.Request.Context(), job)
+	switch err {
+	case nil:
+	case storage.ErrLockNotFound:
+		res.Fail(apiError(err))
+		return
+	default:
+		log.Error("[LockRevoke] Revoke Lock Error", zap.Error(err), zap.String("job", job))
+		res.Fail(apiError(err))
+		return
+	}
+
+	// 审计日志
+	log.Info("[Audit] Job Lock Revoked",
+		zap.String("job", info.Job),
+		zap.String("operator_ip", c.ClientIP()),
+		zap.String("operator", c.GetHeader("X-Operator")),
+		zap.String("holder_worker_id", info.Holder.WorkerID),
+		zap.String("holder_ip", info.Holder.IP),
+		zap.String("holder_acquired_at", info.Holder.AcquiredAt),
+		zap.Int64("token", info.Token),
+		zap.Int64("lease_id", info.LeaseID))
+	res.SetData(info)
+}
@@ -0,0 +1,388 @@
+package routers
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryParam 查询参数
+type queryParam struct {
+	Name     string
+	Desc     string
+	Required bool
+	Enum     []string
+	Schema   interface{} // 参数为json时的结构 为空时为字符串
+}
+
+// operation 单个接口的文档 与init.go中注册的路由一一对应
+type operation struct {
+	Method   string
+	Path     string // 与gin的路由一致 路径参数为:id形式
+	Tag      string
+	Summary  string
+	Envelope common.Envelope
+	Query    []queryParam
+	Body     interface{} // 请求体的结构 由validateBody校验
+	Response interface{} // 成功时返回的数据 为空时无数据
+	Produces string      // EnvelopeRaw时的内容类型
+}
+
+var filterParam = queryParam{Name: "filter", Desc: "筛选条件 json格式", Required: true, Schema: FilterPool{}}
+var rangeParam = queryParam{Name: "range", Desc: "数据范围 json数组[start,end]"}
+
+// operations 所有接口的文档
+var operations = []operation{
+	{Method: "GET", Path: "/ping", Tag: "health", Summary: "存活检查", Envelope: common.EnvelopeRaw, Produces: "text/plain"},
+	{Method: "GET", Path: "/healthz", Tag: "health", Summary: "存活检查", Envelope: common.EnvelopeJob, Response: map[string]string{}},
+	{Method: "GET", Path: "/readyz", Tag: "health", Summary: "就绪检查 etcd/mongo/mysql 任一不可用时返回503", Envelope: common.EnvelopeJob, Response: ReadyResp{}},
+	{Method: "GET", Path: "/metrics", Tag: "health", Summary: "prometheus指标", Envelope: common.EnvelopeRaw, Produces: "text/plain"},
+	{Method: "GET", Path: "/greedy/openapi.json", Tag: "health", Summary: "OpenAPI文档", Envelope: common.EnvelopeRaw, Produces: "application/json"},
+
+	{Method: "POST", Path: "/greedy/job/", Tag: "job", Summary: "增加任务", Envelope: common.EnvelopeJob, Body: WebJob{}, Response: JobView{}},
+	{Method: "GET", Path: "/greedy/job/:id", Tag: "job", Summary: "任务详情", Envelope: common.EnvelopeJob, Response: JobView{}},
+	{Method: "DELETE", Path: "/greedy/job/:id", Tag: "job", Summary: "删除任务", Envelope: common.EnvelopeJob, Response: map[string]bool{}},
+	{Method: "GET", Path: "/greedy/job/", Tag: "job", Summary: "任务列表", Envelope: common.EnvelopeJob, Query: []queryParam{rangeParam}, Response: []WebJob{}},
+	{Method: "PUT", Path: "/greedy/job/:id/pause", Tag: "job", Summary: "暂停周期任务", Envelope: common.EnvelopeJob, Response: JobView{}},
+	{Method: "PUT", Path: "/greedy/job/:id/resume", Tag: "job", Summary: "恢复周期任务", Envelope: common.EnvelopeJob, Response: JobView{}},
+	{Method: "POST", Path: "/greedy/job/:id/rerun", Tag: "job", Summary: "重跑任务", Envelope: common.EnvelopeJob,
+		Query:    []queryParam{{Name: "only", Desc: "failed时只重跑失败的号码", Enum: []string{"failed"}}},
+		Response: JobRerunResp{}},
+
+	{Method: "GET", Path: "/greedy/data/phones", Tag: "data", Summary: "领取号码", Envelope: common.EnvelopeResult,
+		Query: []queryParam{
+			{Name: "act_type", Desc: "执行方式", Required: true},
+			{Name: "env_type", Desc: "运行环境 1-模拟器 2-云手机 3-破解api", Required: true, Enum: []string{common.CrawlTypeSimulator, common.CrawlTypeCloudPhone, common.CrawlTypeAgent}},
+			{Name: "worker_id", Desc: "设备标识 云手机及agent必填"},
+			{Name: "image", Desc: "模拟器镜像"},
+			{Name: "app_version", Desc: "模拟器中的app版本"},
+		},
+		Response: PhonesResp{}},
+	{Method: "POST", Path: "/greedy/data/report", Tag: "data", Summary: "号码结果上报 并结束任务", Envelope: common.EnvelopeResult,
+		Query: []queryParam{{Name: "worker_id", Desc: "设备标识 需与领取时一致"}}, Body: ReportBody{}, Response: map[string]string{}},
+	{Method: "POST", Path: "/greedy/data/report/append", Tag: "data", Summary: "追加上报部分号码 任务保持执行中", Envelope: common.EnvelopeResult,
+		Query: []queryParam{{Name: "worker_id", Desc: "设备标识 需与领取时一致"}}, Body: ReportBody{}, Response: map[string]interface{}{}},
+	{Method: "POST", Path: "/greedy/data/report/complete", Tag: "data", Summary: "追加上报后结束任务", Envelope: common.EnvelopeResult,
+		Query: []queryParam{{Name: "worker_id", Desc: "设备标识 需与领取时一致"}}, Body: ReportBody{}, Response: map[string]string{}},
+	{Method: "POST", Path: "/greedy/data/heartbeat", Tag: "data", Summary: "执行期间的心跳 延长执行期限", Envelope: common.EnvelopeResult,
+		Query: []queryParam{
+			{Name: "job_id", Desc: "任务ID", Required: true},
+			{Name: "worker_id", Desc: "设备标识 需与领取时一致"},
+		},
+		Response: HeartbeatResp{}},
+	{Method: "GET", Path: "/greedy/data/", Tag: "data", Summary: "结果展示", Envelope: common.EnvelopeJob, Query: []queryParam{filterParam, rangeParam}, Response: []DetailShow{}},
+	{Method: "GET", Path: "/greedy/data/export", Tag: "data", Summary: "结果导出", Envelope: common.EnvelopeRaw,
+		Query: []queryParam{
+			filterParam,
+			{Name: "format", Desc: "导出格式 默认csv", Enum: []string{ExportCSV, ExportXLSX, ExportNDJSON}},
+		},
+		Produces: "text/csv"},
+
+	{Method: "GET", Path: "/greedy/stats", Tag: "stats", Summary: "任务及结果统计", Envelope: common.EnvelopeJob,
+		Query: []queryParam{{Name: "hours", Desc: "吞吐量统计的时间窗口 默认24小时"}}, Response: StatsResp{}},
+	{Method: "GET", Path: "/greedy/events", Tag: "events", Summary: "任务事件流", Envelope: common.EnvelopeRaw,
+		Query: []queryParam{{Name: "job_id", Desc: "只订阅该任务"}, {Name: "crawl_type", Desc: "只订阅该执行方式的任务"}}, Produces: "text/event-stream"},
+
+	{Method: "GET", Path: "/greedy/locks", Tag: "locks", Summary: "当前持有的任务锁", Envelope: common.EnvelopeJob, Response: []storage.LockInfo{}},
+	{Method: "DELETE", Path: "/greedy/locks/:job", Tag: "locks", Summary: "强制释放任务锁", Envelope: common.EnvelopeJob, Response: storage.LockInfo{}},
+
+	{Method: "GET", Path: "/greedy/webhooks", Tag: "webhooks", Summary: "所有webhook", Envelope: common.EnvelopeJob, Response: []storage.Webhook{}},
+	{Method: "POST", Path: "/greedy/webhooks", Tag: "webhooks", Summary: "创建webhook", Envelope: common.EnvelopeJob, Body: WebWebhook{}, Response: storage.Webhook{}},
+	{Method: "DELETE", Path: "/greedy/webhooks/:id", Tag: "webhooks", Summary: "删除webhook", Envelope: common.EnvelopeJob, Response: storage.Webhook{}},
+	{Method: "GET", Path: "/greedy/webhooks/:id/deliveries", Tag: "webhooks", Summary: "投递记录", Envelope: common.EnvelopeJob, Response: []storage.Delivery{}},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  gin.H
+)
+
+// OpenAPI 返回OpenAPI 3文档
+func (a *API) OpenAPI(c *gin.Context) {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPI(operations)
+	})
+	c.JSON(http.StatusOK, openAPIDoc)
+}
+
+// buildOpenAPI 根据接口文档及请求返回的结构生成OpenAPI文档
+func buildOpenAPI(ops []operation) gin.H {
+	s := &schemas{defs: gin.H{}, types: map[string]reflect.Type{}}
+	s.of(reflect.TypeOf(common.RespHeader{}))
+	s.of(reflect.TypeOf(common.ErrorBody{}))
+
+	paths := gin.H{}
+	for _, op := range ops {
+		path := openAPIPath(op.Path)
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = s.operation(op)
+	}
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "greedy master",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": gin.H{"schemas": s.defs},
+	}
+}
+
+// openAPIPath gin的路径参数:id转换为{id}
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (s *schemas) operation(op operation) gin.H {
+	o := gin.H{
+		"tags":        []string{op.Tag},
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+	}
+	params := []gin.H{}
+	for _, p := range strings.Split(op.Path, "/") {
+		if strings.HasPrefix(p, ":") {
+			params = append(params, gin.H{"name": p[1:], "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+		}
+	}
+	for _, q := range op.Query {
+		p := gin.H{"name": q.Name, "in": "query", "required": q.Required}
+		if len(q.Desc) != 0 {
+			p["description"] = q.Desc
+		}
+		switch {
+		case q.Schema != nil:
+			p["content"] = gin.H{gin.MIMEJSON: gin.H{"schema": s.of(reflect.TypeOf(q.Schema))}}
+		case len(q.Enum) != 0:
+			p["schema"] = gin.H{"type": "string", "enum": q.Enum}
+		default:
+			p["schema"] = gin.H{"type": "string"}
+		}
+		params = append(params, p)
+	}
+	if len(params) != 0 {
+		o["parameters"] = params
+	}
+	if op.Body != nil {
+		schema := s.of(reflect.TypeOf(op.Body))
+		o["requestBody"] = gin.H{
+			"required": true,
+			"content": gin.H{
+				gin.MIMEJSON:       gin.H{"schema": schema},
+				common.MIMEMsgpack: gin.H{"schema": schema},
+			},
+		}
+	}
+
+	var data interface{} = gin.H{}
+	if op.Response != nil {
+		data = s.of(reflect.TypeOf(op.Response))
+	}
+	switch op.Envelope {
+	case common.EnvelopeResult:
+		o["responses"] = gin.H{
+			"200": gin.H{
+				"description": "状态码固定为200 实际状态见responseHeader.status",
+				"content": gin.H{gin.MIMEJSON: gin.H{"schema": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"responseHeader": gin.H{"$ref": "#/components/schemas/RespHeader"},
+						"response":       data,
+					},
+				}}},
+			},
+		}
+	case common.EnvelopeRaw:
+		o["responses"] = gin.H{
+			"200": gin.H{
+				"description": "OK",
+				"content":     gin.H{op.Produces: gin.H{}},
+			},
+			"default": errorResponse(),
+		}
+	default:
+		o["responses"] = gin.H{
+			"200": gin.H{
+				"description": "OK",
+				"content":     gin.H{gin.MIMEJSON: gin.H{"schema": data}},
+			},
+			"default": errorResponse(),
+		}
+	}
+	return o
+}
+
+func errorResponse() gin.H {
+	return gin.H{
+		"description": "错误码及错误信息 校验失败时包含出错的字段",
+		"content":     gin.H{gin.MIMEJSON: gin.H{"schema": gin.H{"$ref": "#/components/schemas/ErrorBody"}}},
+	}
+}
+
+// operationID 如POST /greedy/job/:id/rerun -> postGreedyJobIdRerun
+func operationID(op operation) string {
+	id := strings.ToLower(op.Method)
+	for _, p := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == '.' || r == ':' }) {
+		id += strings.ToUpper(p[:1]) + p[1:]
+	}
+	return id
+}
+
+// schemas 结构体转换为json schema 具名结构体放在components中
+type schemas struct {
+	defs  gin.H
+	types map[string]reflect.Type
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s *schemas) of(t reflect.Type) gin.H {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return gin.H{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return gin.H{"type": "number"}
+	case t.Kind() == reflect.String:
+		return gin.H{"type": "string"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return gin.H{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return gin.H{"type": "array", "items": s.of(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		if len(t.Name()) == 0 {
+			return s.object(t)
+		}
+		name := s.name(t)
+		if _, ok := s.defs[name]; !ok {
+			// 先占位 避免递归的结构体死循环
+			s.defs[name] = gin.H{}
+			s.defs[name] = s.object(t)
+		}
+		return gin.H{"$ref": "#/components/schemas/" + name}
+	}
+	return gin.H{}
+}
+
+// name 不同包的同名结构体加上包名区分
+func (s *schemas) name(t reflect.Type) string {
+	name := t.Name()
+	if prev, ok := s.types[name]; ok && prev != t {
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + name
+	}
+	s.types[name] = t
+	return name
+}
+
+func (s *schemas) object(t reflect.Type) gin.H {
+	props := gin.H{}
+	required := []string{}
+	s.fields(t, props, &required, false)
+	o := gin.H{"type": "object", "properties": props}
+	if len(required) != 0 {
+		sort.Strings(required)
+		o["required"] = required
+	}
+	return o
+}
+
+// fields 嵌入的结构体字段展开 与encoding/json一致外层字段优先
+func (s *schemas) fields(t reflect.Type, props gin.H, required *[]string, embedded bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && len(f.Tag.Get("json")) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.fields(ft, props, required, true)
+				continue
+			}
+		}
+		if len(f.PkgPath) != 0 {
+			continue
+		}
+		name := jsonName(f)
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := props[name]; ok && embedded {
+			continue
+		}
+		p := s.of(f.Type)
+		if tag, ok := f.Tag.Lookup("binding"); ok {
+			p = withRules(p, f.Type, parseRules(tag))
+			if parseRules(tag).required {
+				*required = append(*required, name)
+			}
+		}
+		props[name] = p
+	}
+}
+
+// withRules 校验规则转换为schema的约束
+func withRules(p gin.H, t reflect.Type, r rules) gin.H {
+	if _, ok := p["$ref"]; ok {
+		return p
+	}
+	out := gin.H{}
+	for k, v := range p {
+		out[k] = v
+	}
+	if len(r.enum) != 0 {
+		if isNumber(t.Kind()) {
+			enum := []int{}
+			for _, e := range r.enum {
+				if v, err := strconv.Atoi(e); err == nil {
+					enum = append(enum, v)
+				}
+			}
+			out["enum"] = enum
+		} else {
+			out["enum"] = r.enum
+		}
+	}
+	minKey, maxKey := "minimum", "maximum"
+	switch t.Kind() {
+	case reflect.String:
+		minKey, maxKey = "minLength", "maxLength"
+	case reflect.Slice, reflect.Array:
+		minKey, maxKey = "minItems", "maxItems"
+	}
+	if r.min != nil {
+		out[minKey] = *r.min
+	}
+	if r.max != nil {
+		out[maxKey] = *r.max
+	}
+	return out
+}
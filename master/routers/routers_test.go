@@ -0,0 +1,1216 @@
+package routers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/config"
+	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/memory"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
+	mysql "code.safe.molen.com/molen/haoma/greedy/master/storage/mysql"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// testServer 使用内存存储的完整路由
+type testServer struct {
+	t       *testing.T
+	api     *API
+	stores  storage.Stores
+	sources *memory.SourceStore
+	handler http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	gin.SetMode(gin.TestMode)
+	stores := memory.NewStores()
+	api := &API{Stores: stores}
+	return &testServer{
+		t:       t,
+		api:     api,
+		stores:  stores,
+		sources: stores.Sources.(*memory.SourceStore),
+		handler: NewHandler(api),
+	}
+}
+
+// do 发起请求 body不为nil时以json提交
+func (ts *testServer) do(method, path string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			ts.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	return w
+}
+
+// waitAsync 等待异步写入完成
+func (ts *testServer) waitAsync() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := WaitAsync(ctx); err != nil {
+		ts.t.Fatalf("wait async writes: %v", err)
+	}
+}
+
+// createJob 创建任务并等待数据导入
+func (ts *testServer) createJob(name, batch string, count int, crawlType string) etcd.JobEtcd {
+	w := ts.do(http.MethodPost, "/greedy/job/", WebJob{
+		Name:      name,
+		Batch:     batch,
+		Count:     count,
+		CrawlType: crawlType,
+	})
+	if w.Code != http.StatusOK {
+		ts.t.Fatalf("create job: status %v, body %v", w.Code, w.Body.String())
+	}
+	job := etcd.JobEtcd{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.waitAsync()
+	return job
+}
+
+// phonesResult 号码下发的返回
+type phonesResult struct {
+	Header common.RespHeader `json:"responseHeader"`
+	Data   PhonesResp        `json:"response"`
+}
+
+// testWorker 测试中默认的设备
+const testWorker = "dev-1"
+
+func (ts *testServer) fetchPhones(actType, envType string) phonesResult {
+	q := url.Values{"act_type": {actType}, "env_type": {envType}, "worker_id": {testWorker}}
+	w := ts.do(http.MethodGet, "/greedy/data/phones?"+q.Encode(), nil)
+	res := phonesResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		ts.t.Fatalf("decode phones: %v, body %v", err, w.Body.String())
+	}
+	return res
+}
+
+func (ts *testServer) report(body ReportBody) common.RespHeader {
+	w := ts.do(http.MethodPost, "/greedy/data/report?worker_id="+testWorker, body)
+	res := struct {
+		Header common.RespHeader `json:"responseHeader"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		ts.t.Fatalf("decode report: %v, body %v", err, w.Body.String())
+	}
+	ts.waitAsync()
+	return res.Header
+}
+
+func (ts *testServer) job(id string) etcd.JobEtcd {
+	job, err := ts.stores.Jobs.JobGetWithID(id)
+	if err != nil {
+		ts.t.Fatalf("get job %v: %v", id, err)
+	}
+	return job
+}
+
+func addSources(ts *testServer, batch string, phones ...string) {
+	for _, p := range phones {
+		ts.sources.Add(mongo.CrawlSource{Phone: p, Batch: batch})
+	}
+}
+
+func TestDispatchAndReport(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234", "13800001234")
+	addSources(ts, "20200219", "13900001234")
+
+	job := ts.createJob("cloud-1", "20200218", 3, common.CrawlTypeCloudPhone)
+	if job.Status != int(common.Pendding) {
+		t.Fatalf("new job status = %v, want %v", job.Status, common.Pendding)
+	}
+	ds, _ := ts.stores.JobData.BatchFetch(job.ID)
+	if len(ds) != 3 {
+		t.Fatalf("job data = %v, want 3", len(ds))
+	}
+
+	res := ts.fetchPhones("1", common.CrawlTypeCloudPhone)
+	if res.Header.Status != http.StatusOK {
+		t.Fatalf("fetch phones: %+v", res.Header)
+	}
+	if res.Data.JobID != job.ID || len(res.Data.Phones) != 3 || res.Data.Batch != "20200218" {
+		t.Fatalf("unexpected phones response: %+v", res.Data)
+	}
+	if got := ts.job(job.ID); got.Status != int(common.Running) || len(got.StartedAt) == 0 {
+		t.Fatalf("job after dispatch: %+v", got)
+	}
+
+	// 唯一的任务已在执行中 不会重复下发
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); res.Header.Code != common.CodeNoJobMatched {
+		t.Fatalf("second fetch: %+v", res.Header)
+	}
+
+	details := []JobDetail{}
+	for _, p := range res.Data.Phones {
+		details = append(details, JobDetail{
+			Phone:  p,
+			Result: `{"tag":"快递"}`,
+			Type:   int(common.CloudPhone),
+			Source: 1,
+			Batch:  res.Data.Batch,
+		})
+	}
+	if h := ts.report(ReportBody{JobID: job.ID, JobResult: 1, JobDetail: details}); h.Status != http.StatusOK {
+		t.Fatalf("report: %+v", h)
+	}
+	got := ts.job(job.ID)
+	if got.Status != int(common.Done) || got.Result != int(common.Successful) {
+		t.Fatalf("job after report: %+v", got)
+	}
+	w := ts.do(http.MethodGet, "/greedy/job/"+job.ID, nil)
+	view := JobView{}
+	if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+	if p := view.Progress; p.Total != 3 || p.Reported != 3 || p.WithResult != 3 || p.Percent != 100 || p.ETASeconds != 0 {
+		t.Fatalf("progress after report: %+v", p)
+	}
+	w = ts.do(http.MethodGet, "/greedy/job/", nil)
+	list := []WebJob{}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Progress.Reported != 3 || list[0].Progress.Percent != 100 {
+		t.Fatalf("progress in job lists: %+v", list)
+	}
+
+	filter, _ := json.Marshal(map[string]string{"job_id": job.ID})
+	w = ts.do(http.MethodGet, "/greedy/data/?filter="+url.QueryEscape(string(filter)), nil)
+	shown := []DetailShow{}
+	if err := json.Unmarshal(w.Body.Bytes(), &shown); err != nil {
+		t.Fatalf("decode show: %v, body %v", err, w.Body.String())
+	}
+	if len(shown) != 3 || shown[0].CrawlType != "云手机" || shown[0].CrawlSource != "360手机卫士" {
+		t.Fatalf("unexpected results: %+v", shown)
+	}
+
+	// 成功的任务不会再次下发
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); res.Header.Code != common.CodeNoJobMatched {
+		t.Fatalf("fetch after success: %+v", res.Header)
+	}
+}
+
+func TestFailedJobRedispatch(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234")
+
+	w := ts.do(http.MethodPost, "/greedy/job/", WebJob{
+		Name:        "cloud-1",
+		Batch:       "20200218",
+		Count:       2,
+		CrawlType:   common.CrawlTypeCloudPhone,
+		MaxAttempts: 2,
+	})
+	job := JobView{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitAsync()
+	if job.Backoff.Initial != core.DefaultBackoff.Initial {
+		t.Fatalf("default backoff not applied: %+v", job.Backoff)
+	}
+
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); res.Data.JobID != job.ID {
+		t.Fatalf("fetch phones: %+v", res)
+	}
+	if h := ts.report(ReportBody{JobID: job.ID, JobResult: 2, ErrorMsg: "captcha"}); h.Status != http.StatusOK {
+		t.Fatalf("report: %+v", h)
+	}
+	got := ts.job(job.ID)
+	if got.Status != int(common.Done) || got.Result != int(common.Failed) || len(got.NextEligibleAt) == 0 {
+		t.Fatalf("job after failed report: %+v", got)
+	}
+
+	// 退避期间不会下发
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); res.Header.Code != common.CodeNoJobMatched {
+		t.Fatalf("fetch during backoff: %+v", res.Header)
+	}
+
+	// 退避结束后再次下发 数据来自任务数据存储
+	got.NextEligibleAt = time.Now().Add(-time.Second).Format(core.TimeLayout)
+	ts.stores.Jobs.JobSave(got)
+	res := ts.fetchPhones("1", common.CrawlTypeCloudPhone)
+	if res.Data.JobID != job.ID || len(res.Data.Phones) != 2 {
+		t.Fatalf("redispatch: %+v", res)
+	}
+
+	// 达到最大执行次数后不再下发
+	ts.report(ReportBody{JobID: job.ID, JobResult: 2, ErrorMsg: "timeout"})
+	w = ts.do(http.MethodGet, "/greedy/job/"+job.ID, nil)
+	job = JobView{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != int(common.Exhausted) || job.Attempts != 2 || len(job.History) != 2 {
+		t.Fatalf("job after max attempts: %+v", job)
+	}
+	if a := job.History[1]; a.No != 2 || a.Error != "timeout" || a.Result != int(common.Failed) || len(a.FinishedAt) == 0 {
+		t.Fatalf("attempt history: %+v", job.History)
+	}
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); res.Header.Code != common.CodeNoJobMatched {
+		t.Fatalf("fetch exhausted job: %+v", res.Header)
+	}
+}
+
+func TestDispatchValidation(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		name  string
+		query string
+		code  common.Code
+	}{
+		{"missing act_type", "env_type=2", common.CodeInvalidParameters},
+		{"missing env_type", "act_type=1", common.CodeInvalidParameters},
+		{"unknown env_type", "act_type=1&env_type=9", common.CodeInvalidParameters},
+		{"missing worker_id", "act_type=1&env_type=2", common.CodeInvalidParameters},
+		{"no job", "act_type=1&env_type=2&worker_id=dev-1", common.CodeNoJobMatched},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ts.do(http.MethodGet, "/greedy/data/phones?"+tt.query, nil)
+			res := phonesResult{}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Header.Code != tt.code || res.Header.Status != tt.code.Status() {
+				t.Fatalf("header = %+v, want code %v", res.Header, tt.code)
+			}
+		})
+	}
+}
+
+func TestJobCRUD(t *testing.T) {
+	ts := newTestServer(t)
+	job := ts.createJob("cloud-1", "20200218", 10, common.CrawlTypeCloudPhone)
+
+	w := ts.do(http.MethodGet, "/greedy/job/", nil)
+	jobs := []WebJob{}
+	if err := json.Unmarshal(w.Body.Bytes(), &jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != job.ID || jobs[0].Status != "1" {
+		t.Fatalf("job lists: %+v", jobs)
+	}
+
+	if w := ts.do(http.MethodGet, "/greedy/job/"+job.ID, nil); w.Code != http.StatusOK {
+		t.Fatalf("job get: %v", w.Code)
+	}
+	if w := ts.do(http.MethodDelete, "/greedy/job/"+job.ID, nil); w.Code != http.StatusOK {
+		t.Fatalf("job delete: %v", w.Code)
+	}
+	if w := ts.do(http.MethodGet, "/greedy/job/"+job.ID, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("job get after delete: %v", w.Code)
+	}
+	if w := ts.do(http.MethodPost, "/greedy/job/", WebJob{Name: "bad", CrawlType: "x"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid crawl type: %v", w.Code)
+	}
+}
+
+func TestLockAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	holder := storage.LockHolder{WorkerID: "device-1", IP: "10.0.0.1"}
+	if err := ts.stores.Jobs.JobLockMade("cloud-1", holder).TryLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	w := ts.do(http.MethodGet, "/greedy/locks", nil)
+	locks := []storage.LockInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), &locks); err != nil {
+		t.Fatalf("decode locks: %v, body %v", err, w.Body.String())
+	}
+	if len(locks) != 1 || locks[0].Job != "cloud-1" || locks[0].Holder.WorkerID != "device-1" {
+		t.Fatalf("locks: %+v", locks)
+	}
+
+	if w := ts.do(http.MethodDelete, "/greedy/locks/cloud-1", nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %v %v", w.Code, w.Body.String())
+	}
+	if w := ts.do(http.MethodDelete, "/greedy/locks/cloud-1", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("revoke again: %v", w.Code)
+	}
+	if err := ts.stores.Jobs.JobLockMade("cloud-1", holder).TryLock(context.Background()); err != nil {
+		t.Fatalf("lock after revoke: %v", err)
+	}
+}
+
+func TestScheduledJobPauseResume(t *testing.T) {
+	ts := newTestServer(t)
+	if w := ts.do(http.MethodPost, "/greedy/job/", WebJob{Name: "bad", CrawlType: "2", Schedule: "every day"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid schedule: %v", w.Code)
+	}
+
+	w := ts.do(http.MethodPost, "/greedy/job/", WebJob{Name: "weekly", Batch: "20200218", CrawlType: "2", Schedule: "@weekly"})
+	job := JobView{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Schedule != "@weekly" || len(job.NextRunAt) == 0 {
+		t.Fatalf("scheduled job: %+v", job)
+	}
+
+	id := job.ID
+	w = ts.do(http.MethodPut, "/greedy/job/"+id+"/pause", nil)
+	job = JobView{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if !job.Paused || len(job.NextRunAt) != 0 {
+		t.Fatalf("paused job: %+v", job)
+	}
+	w = ts.do(http.MethodPut, "/greedy/job/"+id+"/resume", nil)
+	job = JobView{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Paused || len(job.NextRunAt) == 0 {
+		t.Fatalf("resumed job: %+v", job)
+	}
+}
+
+func TestRetryOnlyUnfinished(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234", "13800001234")
+
+	w := ts.do(http.MethodPost, "/greedy/job/", WebJob{
+		Name:        "cloud-1",
+		Batch:       "20200218",
+		Count:       3,
+		CrawlType:   common.CrawlTypeCloudPhone,
+		MaxAttempts: 2,
+	})
+	job := JobView{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitAsync()
+
+	res := ts.fetchPhones("1", common.CrawlTypeCloudPhone)
+	if len(res.Data.Phones) != 3 {
+		t.Fatalf("first dispatch: %+v", res)
+	}
+	// 一个号码有结果 一个无结果 一个未上报
+	ts.report(ReportBody{JobID: job.ID, JobResult: 2, JobDetail: []JobDetail{
+		{Phone: "15330091234", Result: `{"tag":"快递"}`, Type: 2, Source: 1, Batch: "20200218"},
+		{Phone: "15757121234", Result: "{}", Type: 2, Source: 1, Batch: "20200218"},
+	}})
+	status := map[string]int8{}
+	ds, _ := ts.stores.JobData.BatchFetch(job.ID)
+	for _, d := range ds {
+		status[d.Phone.String] = d.Status
+	}
+	if status["15330091234"] != mysql.DataSucceeded || status["15757121234"] != mysql.DataNoResult ||
+		status["13800001234"] != mysql.DataFailed {
+		t.Fatalf("data status after report: %v", status)
+	}
+
+	// 再次下发只包含未完成的号码
+	got := ts.job(job.ID)
+	got.NextEligibleAt = ""
+	ts.stores.Jobs.JobSave(got)
+	res = ts.fetchPhones("1", common.CrawlTypeCloudPhone)
+	if len(res.Data.Phones) != 1 || res.Data.Phones[0] != "13800001234" {
+		t.Fatalf("retry dispatch: %+v", res.Data)
+	}
+	ts.report(ReportBody{JobID: job.ID, JobResult: 2})
+	if got := ts.job(job.ID); got.Status != int(common.Exhausted) {
+		t.Fatalf("job after max attempts: %+v", got)
+	}
+
+	if w := ts.do(http.MethodPost, "/greedy/job/"+job.ID+"/rerun?only=bad", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("rerun with invalid only: %v", w.Code)
+	}
+	w = ts.do(http.MethodPost, "/greedy/job/"+job.ID+"/rerun?only=failed", nil)
+	rerun := JobRerunResp{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rerun); err != nil {
+		t.Fatalf("decode rerun: %v, body %v", err, w.Body.String())
+	}
+	if rerun.Requeued != 1 || rerun.Status != int(common.Pendding) || rerun.Attempts != 0 {
+		t.Fatalf("rerun: %+v", rerun)
+	}
+	res = ts.fetchPhones("1", common.CrawlTypeCloudPhone)
+	if len(res.Data.Phones) != 1 || res.Data.Phones[0] != "13800001234" {
+		t.Fatalf("dispatch after rerun: %+v", res.Data)
+	}
+	if got := ts.job(job.ID); len(got.History) != 3 || got.History[2].No != 3 {
+		t.Fatalf("history after rerun: %+v", got.History)
+	}
+
+	// 全部重跑时进度重新计算
+	ts.report(ReportBody{JobID: job.ID, JobResult: 1, JobDetail: []JobDetail{
+		{Phone: "13800001234", Result: "{}", Type: 2, Source: 1, Batch: "20200218"},
+	}})
+	ts.waitAsync()
+	w = ts.do(http.MethodPost, "/greedy/job/"+job.ID+"/rerun", nil)
+	rerun = JobRerunResp{}
+	json.Unmarshal(w.Body.Bytes(), &rerun)
+	if p := rerun.Progress; rerun.Requeued != 3 || p.Total != 3 || p.Reported != 0 || p.Failed != 0 {
+		t.Fatalf("full rerun: %+v, body %v", rerun, w.Body.String())
+	}
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); len(res.Data.Phones) != 3 {
+		t.Fatalf("dispatch after full rerun: %+v", res.Data)
+	}
+}
+
+func TestReportAppend(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234", "13800001234")
+	w := ts.do(http.MethodPost, "/greedy/job/", WebJob{
+		Name:        "cloud-1",
+		Batch:       "20200218",
+		Count:       3,
+		CrawlType:   common.CrawlTypeCloudPhone,
+		MaxAttempts: 2,
+	})
+	job := JobView{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitAsync()
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); len(res.Data.Phones) != 3 {
+		t.Fatalf("dispatch: %+v", res)
+	}
+
+	appendReport := func(details ...JobDetail) (common.RespHeader, int) {
+		w := ts.do(http.MethodPost, "/greedy/data/report/append?worker_id="+testWorker, ReportBody{JobID: job.ID, JobDetail: details})
+		res := struct {
+			Header common.RespHeader `json:"responseHeader"`
+			Data   struct {
+				Appended int `json:"appended"`
+			} `json:"response"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		ts.waitAsync()
+		return res.Header, res.Data.Appended
+	}
+	hit := JobDetail{Phone: "15330091234", Result: `{"tag":"快递"}`, Type: 2, Source: 1, Batch: "20200218"}
+	if h, n := appendReport(hit); h.Status != http.StatusOK || n != 1 {
+		t.Fatalf("append: %+v %v", h, n)
+	}
+	// 重试的追加请求不重复入库
+	if h, n := appendReport(hit); h.Status != http.StatusOK || n != 0 {
+		t.Fatalf("duplicate append: %+v %v", h, n)
+	}
+	got := ts.job(job.ID)
+	if got.Status != int(common.Running) || got.Progress.Reported != 1 || got.Progress.WithResult != 1 {
+		t.Fatalf("job after append: %+v", got)
+	}
+	status := func() map[string]int8 {
+		status := map[string]int8{}
+		ds, _ := ts.stores.JobData.BatchFetch(job.ID)
+		for _, d := range ds {
+			status[d.Phone.String] = d.Status
+		}
+		return status
+	}
+	if s := status(); s["15330091234"] != mysql.DataSucceeded || s["15757121234"] != mysql.DataDispatched {
+		t.Fatalf("data status after append: %v", s)
+	}
+
+	// 设备异常 结束时只上报了一个号码
+	w = ts.do(http.MethodPost, "/greedy/data/report/complete?worker_id="+testWorker, ReportBody{JobID: job.ID, JobResult: 2, JobDetail: []JobDetail{
+		hit,
+		{Phone: "15757121234", Result: "{}", Type: 2, Source: 1, Batch: "20200218"},
+	}})
+	ts.waitAsync()
+	got = ts.job(job.ID)
+	if got.Status != int(common.Done) || got.Progress.Reported != 2 || got.Progress.Failed != 1 || got.History[0].Reported != 2 {
+		t.Fatalf("job after complete: %+v, body %v", got, w.Body.String())
+	}
+	if s := status(); s["15330091234"] != mysql.DataSucceeded || s["15757121234"] != mysql.DataNoResult ||
+		s["13800001234"] != mysql.DataFailed {
+		t.Fatalf("data status after complete: %v", s)
+	}
+	docs, _ := ts.stores.Results.CrawlResultGet(map[string]string{"job_id": job.ID})
+	if len(docs) != 2 {
+		t.Fatalf("results after complete: %v", len(docs))
+	}
+	// 已结束的任务不能再追加
+	if h, _ := appendReport(hit); h.Status != http.StatusConflict {
+		t.Fatalf("append after complete: %+v", h)
+	}
+
+	// 重试只下发未完成的号码
+	got.NextEligibleAt = ""
+	ts.stores.Jobs.JobSave(got)
+	res := ts.fetchPhones("1", common.CrawlTypeCloudPhone)
+	if len(res.Data.Phones) != 1 || res.Data.Phones[0] != "13800001234" {
+		t.Fatalf("retry dispatch: %+v", res.Data)
+	}
+}
+
+func TestConcurrentAppendAndComplete(t *testing.T) {
+	ts := newTestServer(t)
+	phones := []string{}
+	for i := 0; i < 20; i++ {
+		phones = append(phones, fmt.Sprintf("153300912%02d", i))
+	}
+	addSources(ts, "20200218", phones...)
+	job := ts.createJob("cloud-1", "20200218", len(phones), common.CrawlTypeCloudPhone)
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); len(res.Data.Phones) != len(phones) {
+		t.Fatalf("dispatch: %+v", res)
+	}
+
+	detail := func(phone string) JobDetail {
+		return JobDetail{Phone: phone, Result: `{"tag":"快递"}`, Type: 2, Source: 1, Batch: "20200218"}
+	}
+	all := []JobDetail{}
+	for _, p := range phones {
+		all = append(all, detail(p))
+	}
+	var wg sync.WaitGroup
+	for _, p := range phones {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			ts.do(http.MethodPost, "/greedy/data/report/append?worker_id="+testWorker, ReportBody{JobID: job.ID, JobDetail: []JobDetail{detail(p)}})
+		}(p)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ts.do(http.MethodPost, "/greedy/data/report/complete?worker_id="+testWorker, ReportBody{JobID: job.ID, JobResult: 1, JobDetail: all})
+	}()
+	wg.Wait()
+	ts.waitAsync()
+
+	// 完成后的追加被拒绝 每个号码只计数及入库一次
+	got := ts.job(job.ID)
+	if got.Status != int(common.Done) || got.Progress.Reported != len(phones) || got.Progress.WithResult != len(phones) {
+		t.Fatalf("job after concurrent reports: %+v", got)
+	}
+	docs, _ := ts.stores.Results.CrawlResultGet(map[string]string{"job_id": job.ID})
+	if len(docs) != len(phones) {
+		t.Fatalf("results after concurrent reports: %v", len(docs))
+	}
+}
+
+func TestExpiredAttemptRedispatch(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234", "13800001234")
+	job := ts.createJob("cloud-1", "20200218", 3, common.CrawlTypeCloudPhone)
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); len(res.Data.Phones) != 3 {
+		t.Fatalf("dispatch: %+v", res)
+	}
+	hit := JobDetail{Phone: "15330091234", Result: `{"tag":"快递"}`, Type: 2, Source: 1, Batch: "20200218"}
+	ts.do(http.MethodPost, "/greedy/data/report/append?worker_id="+testWorker, ReportBody{JobID: job.ID, JobDetail: []JobDetail{hit}})
+	ts.waitAsync()
+
+	// 心跳延长执行期限 其他设备的心跳不延长
+	w := ts.do(http.MethodPost, "/greedy/data/heartbeat?job_id="+job.ID+"&worker_id=dev-2", nil)
+	if !strings.Contains(w.Body.String(), `"assigned":false`) {
+		t.Fatalf("heartbeat from other device: %v", w.Body.String())
+	}
+	w = ts.do(http.MethodPost, "/greedy/data/heartbeat?job_id="+job.ID+"&worker_id="+testWorker, nil)
+	if !strings.Contains(w.Body.String(), `"assigned":true`) || !strings.Contains(w.Body.String(), `"state":"running"`) {
+		t.Fatalf("heartbeat: %v", w.Body.String())
+	}
+	if w := ts.do(http.MethodPost, "/greedy/data/heartbeat?job_id=missing", nil); !strings.Contains(w.Body.String(), `"status":404`) {
+		t.Fatalf("heartbeat missing job: %v", w.Body.String())
+	}
+
+	// 设备异常退出 超过执行期限后由leader回收
+	reaper := core.NewReaper(ts.stores.Jobs, (&API{Stores: ts.stores}).ReleaseJobData)
+	if jobs, err := reaper.ReapExpired(time.Now().Add(core.DefaultAttemptTimeout + time.Minute)); err != nil || len(jobs) != 1 {
+		t.Fatalf("reap: %+v, %v", jobs, err)
+	}
+	ts.waitAsync()
+	got := ts.job(job.ID)
+	if core.StateOf(got) != core.StateFailed || got.Progress.Failed != 2 {
+		t.Fatalf("job after reap: %+v", got)
+	}
+	// 异常退出的设备不能再上报
+	if h := ts.report(ReportBody{JobID: job.ID, JobResult: 1}); h.Code != common.CodeInvalidTransition {
+		t.Fatalf("report after reap: %+v", h)
+	}
+
+	// 退避结束后其他设备只领取未完成的号码
+	got.NextEligibleAt = ""
+	ts.stores.Jobs.JobSave(got)
+	w = ts.do(http.MethodGet, "/greedy/data/phones?act_type=1&env_type=2&worker_id=dev-2", nil)
+	res := phonesResult{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	sort.Strings(res.Data.Phones)
+	if strings.Join(res.Data.Phones, ",") != "13800001234,15757121234" {
+		t.Fatalf("redispatch: %+v", res)
+	}
+}
+
+func TestMultiSourceReport(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234", "13800001234")
+	job := ts.createJob("cloud-1", "20200218", 3, common.CrawlTypeCloudPhone)
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); len(res.Data.Phones) != 3 {
+		t.Fatalf("dispatch: %+v", res)
+	}
+	// 每个号码每个来源一条结果
+	sources := func(phone string, hitSource int) []JobDetail {
+		details := []JobDetail{}
+		for src := 1; src <= 3; src++ {
+			d := JobDetail{Phone: phone, Result: "{}", Type: 3, Source: src, Batch: "20200218"}
+			if src == hitSource {
+				d.Result = `{"tag":"快递"}`
+			}
+			details = append(details, d)
+		}
+		return details
+	}
+
+	w := ts.do(http.MethodPost, "/greedy/data/report/append?worker_id="+testWorker, ReportBody{JobID: job.ID, JobDetail: sources("15330091234", 2)})
+	ts.waitAsync()
+	got := ts.job(job.ID)
+	if !strings.Contains(w.Body.String(), `"appended":1`) || got.Progress.Reported != 1 || got.Progress.WithResult != 1 ||
+		got.History[0].Reported != 1 {
+		t.Fatalf("job after append: %+v, body %v", got.Progress, w.Body.String())
+	}
+	if p := core.ProgressOf(got, time.Now()); p.Percent > 34 {
+		t.Fatalf("percent after one phone: %v", p.Percent)
+	}
+
+	// 失败时 未上报的号码计为失败
+	ts.report(ReportBody{JobID: job.ID, JobResult: 2, JobDetail: sources("15757121234", 0)})
+	got = ts.job(job.ID)
+	if got.Progress.Reported != 2 || got.Progress.WithResult != 1 || got.Progress.Failed != 1 {
+		t.Fatalf("job after report: %+v", got.Progress)
+	}
+	docs, _ := ts.stores.Results.CrawlResultGet(map[string]string{"job_id": job.ID})
+	if len(docs) != 6 {
+		t.Fatalf("results: %v", len(docs))
+	}
+}
+
+func TestCompressedReport(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234")
+	job := ts.createJob("cloud-1", "20200218", 2, common.CrawlTypeCloudPhone)
+
+	mh := &codec.MsgpackHandle{}
+	// 按Accept返回msgpack
+	q := url.Values{"act_type": {"1"}, "env_type": {common.CrawlTypeCloudPhone}, "worker_id": {testWorker}}
+	req := httptest.NewRequest(http.MethodGet, "/greedy/data/phones?"+q.Encode(), nil)
+	req.Header.Set("Accept", common.MIMEMsgpack)
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	phones := phonesResult{}
+	if err := codec.NewDecoderBytes(w.Body.Bytes(), mh).Decode(&phones); err != nil {
+		t.Fatalf("decode msgpack phones: %v, content type %v", err, w.Header().Get("Content-Type"))
+	}
+	if phones.Data.JobID != job.ID || len(phones.Data.Phones) != 2 {
+		t.Fatalf("msgpack phones: %+v", phones)
+	}
+
+	post := func(body ReportBody, limit int64) common.RespHeader {
+		var raw []byte
+		if err := codec.NewEncoderBytes(&raw, mh).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(raw)
+		zw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/greedy/data/report?worker_id="+testWorker, &buf)
+		req.Header.Set("Content-Type", common.MIMEMsgpack)
+		req.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		NewHandler(&API{Stores: ts.stores, MaxBodyBytes: limit}).ServeHTTP(w, req)
+		res := struct {
+			Header common.RespHeader `json:"responseHeader"`
+		}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("decode report: %v, body %v", err, w.Body.String())
+		}
+		ts.waitAsync()
+		return res.Header
+	}
+	body := ReportBody{JobID: job.ID, JobResult: 1, JobDetail: []JobDetail{
+		{Phone: "15330091234", Result: `{"tag":"快递"}`, Type: 2, Source: 1, Batch: "20200218"},
+		{Phone: "15757121234", Result: strings.Repeat(" ", 4096), Type: 2, Source: 1, Batch: "20200218"},
+	}}
+	// 解压后超过上限
+	if h := post(body, 1024); h.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("report over limit: %+v", h)
+	}
+	if got := ts.job(job.ID); got.Status != int(common.Running) {
+		t.Fatalf("job after rejected report: %+v", got)
+	}
+	if h := post(body, 0); h.Status != http.StatusOK {
+		t.Fatalf("gzip msgpack report: %+v", h)
+	}
+	docs, _ := ts.stores.Results.CrawlResultGet(map[string]string{"job_id": job.ID})
+	if got := ts.job(job.ID); got.Status != int(common.Done) || len(docs) != 2 {
+		t.Fatalf("job after report: %+v, results %v", got, len(docs))
+	}
+
+	// 请求头声明的大小超过上限时直接拒绝
+	req = httptest.NewRequest(http.MethodPost, "/greedy/data/report", strings.NewReader(strings.Repeat("x", 2048)))
+	w = httptest.NewRecorder()
+	NewHandler(&API{Stores: ts.stores, MaxBodyBytes: 1024}).ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("content length over limit: %v", w.Code)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodGet, "/greedy/openapi.json", nil)
+	doc := struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string                          `json:"required"`
+				Properties map[string]map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || doc.OpenAPI != "3.0.3" {
+		t.Fatalf("decode openapi: %v, body %v", err, w.Body.String())
+	}
+	// 注册的路由与文档一一对应 未实现的路由只有中间件
+	routes := map[string]bool{}
+	for _, r := range ts.handler.(*gin.Engine).Routes() {
+		if strings.Contains(r.Handler, "limitBody") {
+			continue
+		}
+		routes[r.Method+" "+r.Path] = true
+		if _, ok := doc.Paths[openAPIPath(r.Path)][strings.ToLower(r.Method)]; !ok {
+			t.Errorf("route %v %v is not documented", r.Method, r.Path)
+		}
+	}
+	for _, op := range operations {
+		if !routes[op.Method+" "+op.Path] {
+			t.Errorf("documented %v %v is not registered", op.Method, op.Path)
+		}
+	}
+	webJob := doc.Components.Schemas["WebJob"]
+	if strings.Join(webJob.Required, ",") != "batch,crawl_type,name" || len(webJob.Properties["crawl_type"]["enum"].([]interface{})) != 3 {
+		t.Fatalf("WebJob schema: %+v", webJob)
+	}
+	if _, ok := doc.Components.Schemas["JobView"].Properties["history"]; !ok {
+		t.Fatalf("embedded fields of JobView: %+v", doc.Components.Schemas["JobView"])
+	}
+
+	fields := func(w *httptest.ResponseRecorder) string {
+		res := struct {
+			Fields []common.FieldError `json:"fields"`
+			Header struct {
+				Fields []common.FieldError `json:"fields"`
+			} `json:"responseHeader"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		names := []string{}
+		for _, f := range append(res.Fields, res.Header.Fields...) {
+			names = append(names, f.Field)
+		}
+		return strings.Join(names, ",")
+	}
+	w = ts.do(http.MethodPost, "/greedy/job/", map[string]interface{}{"name": "bad", "count": "x"})
+	if w.Code != http.StatusBadRequest || fields(w) != "count" {
+		t.Fatalf("job with invalid count: %v %v", w.Code, w.Body.String())
+	}
+	w = ts.do(http.MethodPost, "/greedy/job/", WebJob{CrawlType: "9", MaxAttempts: -1})
+	if w.Code != http.StatusBadRequest || fields(w) != "batch,crawl_type,max_attempts,name" {
+		t.Fatalf("invalid job: %v %v", w.Code, w.Body.String())
+	}
+	w = ts.do(http.MethodPost, "/greedy/data/report", ReportBody{JobID: "1", JobResult: 1, JobDetail: []JobDetail{{Phone: "15330091234"}, {}}})
+	if !strings.Contains(w.Body.String(), `"status":400`) || fields(w) != "job_detail[1].phone" {
+		t.Fatalf("report without phone: %v", w.Body.String())
+	}
+	if jobs, _ := ts.stores.Jobs.JobLists(); len(jobs) != 0 {
+		t.Fatalf("jobs saved for invalid bodies: %v", len(jobs))
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ts := newTestServer(t)
+	srv := httptest.NewServer(ts.handler)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/greedy/events?crawl_type=2", nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %v", ct)
+	}
+
+	// 只推送crawl_type=2的任务
+	ts.createJob("sim-1", "20200218", 1, common.CrawlTypeSimulator)
+	job := ts.createJob("cloud-1", "20200218", 1, common.CrawlTypeCloudPhone)
+	ts.stores.Jobs.JobDelete(job.Name)
+
+	scanner := bufio.NewScanner(resp.Body)
+	got := []string{}
+	for len(got) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event:") {
+			got = append(got, strings.TrimSpace(strings.TrimPrefix(line, "event:")))
+		}
+		if strings.HasPrefix(line, "data:") && !strings.Contains(line, job.ID) {
+			t.Fatalf("unexpected event data: %v", line)
+		}
+	}
+	if len(got) != 2 || got[0] != storage.EventCreated || got[1] != storage.EventDeleted {
+		t.Fatalf("events = %v, err = %v", got, scanner.Err())
+	}
+
+	// 退出时服务端结束事件流 不等待客户端断开
+	ts.api.CloseStreams()
+	for scanner.Scan() {
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("stream not closed on shutdown: %v", ctx.Err())
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ts := newTestServer(t)
+	received := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(core.HeaderHookEvent)
+	}))
+	defer receiver.Close()
+
+	if w := ts.do(http.MethodPost, "/greedy/webhooks", WebWebhook{URL: "ftp://x", Events: []string{storage.HookJobCreated}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid url: %v", w.Code)
+	}
+	if w := ts.do(http.MethodPost, "/greedy/webhooks", WebWebhook{URL: receiver.URL, Events: []string{"job.done"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown event: %v", w.Code)
+	}
+	w := ts.do(http.MethodPost, "/greedy/webhooks", WebWebhook{URL: receiver.URL, Events: []string{storage.HookJobSucceeded}})
+	hook := storage.Webhook{}
+	if err := json.Unmarshal(w.Body.Bytes(), &hook); err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.ID) == 0 || len(hook.Secret) == 0 || !hook.Active {
+		t.Fatalf("webhook: %+v", hook)
+	}
+	// 列表不返回密钥
+	hooks := []storage.Webhook{}
+	json.Unmarshal(ts.do(http.MethodGet, "/greedy/webhooks", nil).Body.Bytes(), &hooks)
+	if len(hooks) != 1 || hooks[0].ID != hook.ID || len(hooks[0].Secret) != 0 {
+		t.Fatalf("webhooks: %+v", hooks)
+	}
+
+	n := core.NewNotifier(ts.stores.Events, ts.stores.Webhooks)
+	n.Notify(context.Background(), storage.HookJobSucceeded, etcd.JobEtcd{ID: "id-1"})
+	select {
+	case event := <-received:
+		if event != storage.HookJobSucceeded {
+			t.Fatalf("event = %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for delivery")
+	}
+	var ds []storage.Delivery
+	for i := 0; i < 100; i++ {
+		json.Unmarshal(ts.do(http.MethodGet, "/greedy/webhooks/"+hook.ID+"/deliveries", nil).Body.Bytes(), &ds)
+		if len(ds) == 1 && ds[0].Status == storage.DeliverySucceeded {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(ds) != 1 || ds[0].Status != storage.DeliverySucceeded || ds[0].JobID != "id-1" {
+		t.Fatalf("deliveries: %+v", ds)
+	}
+
+	if w := ts.do(http.MethodDelete, "/greedy/webhooks/"+hook.ID, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: %v", w.Code)
+	}
+	if w := ts.do(http.MethodDelete, "/greedy/webhooks/"+hook.ID, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("delete again: %v", w.Code)
+	}
+}
+
+func TestSimulatorDispatch(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234")
+	if w := ts.do(http.MethodPost, "/greedy/job/", WebJob{Name: "bad", CrawlType: "1", MinAppVersion: "8.x"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid min_app_version: %v", w.Code)
+	}
+	w := ts.do(http.MethodPost, "/greedy/job/", WebJob{Name: "sim-1", Batch: "20200218", Count: 2, CrawlType: "1", MinAppVersion: "8.2"})
+	job := etcd.JobEtcd{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitAsync()
+
+	ts.api.Simulator = config.SimulatorConfig{BatchSize: 10, BatchSizes: map[string]int{"android-7": 3}, Interval: 2}
+
+	fetch := func(image, version string) phonesResult {
+		q := url.Values{"act_type": {"1"}, "env_type": {common.CrawlTypeSimulator}, "image": {image}, "app_version": {version}}
+		res := phonesResult{}
+		w := ts.do(http.MethodGet, "/greedy/data/phones?"+q.Encode(), nil)
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("decode phones: %v, body %v", err, w.Body.String())
+		}
+		return res
+	}
+	// 版本过低或未上报版本时不匹配
+	for _, v := range []string{"8.1.9", ""} {
+		if res := fetch("android-7", v); res.Header.Code != common.CodeNoJobMatched || len(res.Data.Phones) != 0 {
+			t.Fatalf("app_version %q: %+v", v, res)
+		}
+	}
+	// 云手机不会拿到模拟器的任务
+	if res := ts.fetchPhones("1", common.CrawlTypeCloudPhone); res.Header.Code != common.CodeNoJobMatched {
+		t.Fatalf("cloud phone: %+v", res)
+	}
+
+	res := fetch("android-7", "8.2.1")
+	if res.Header.Status != http.StatusOK || res.Data.JobID != job.ID || len(res.Data.Phones) != 2 {
+		t.Fatalf("simulator dispatch: %+v", res)
+	}
+	if res.Data.Policy.Num != 3 || res.Data.Policy.Interval != 2 {
+		t.Fatalf("policy: %+v", res.Data.Policy)
+	}
+	if got := ts.job(job.ID); got.Status != int(common.Running) || got.Attempts != 1 {
+		t.Fatalf("job after dispatch: %+v", got)
+	}
+	// 任务执行中不再下发
+	if res := fetch("android-8", "9.0"); res.Header.Code != common.CodeNoJobMatched {
+		t.Fatalf("second dispatch: %+v", res)
+	}
+}
+
+func TestInvalidTransitions(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234")
+	job := ts.createJob("cloud-1", "20200218", 1, common.CrawlTypeCloudPhone)
+
+	// 未下发的任务不能上报
+	h := ts.report(ReportBody{JobID: job.ID, JobResult: 1})
+	if h.Code != common.CodeInvalidTransition || !strings.Contains(h.Detail, "pending") {
+		t.Fatalf("report pending job: %+v", h)
+	}
+	if got := ts.job(job.ID); got.Status != int(common.Pendding) || len(got.StopedAt) != 0 {
+		t.Fatalf("job after rejected report: %+v", got)
+	}
+
+	ts.fetchPhones("2", common.CrawlTypeCloudPhone)
+	// 执行中的任务不能重跑
+	w := ts.do(http.MethodPost, "/greedy/job/"+job.ID+"/rerun", nil)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "running") {
+		t.Fatalf("rerun running job: %v %v", w.Code, w.Body.String())
+	}
+	if h := ts.report(ReportBody{JobID: job.ID, JobResult: 1}); h.Status != http.StatusOK {
+		t.Fatalf("report: %+v", h)
+	}
+	// 重复上报
+	if h := ts.report(ReportBody{JobID: job.ID, JobResult: 2}); h.Status != http.StatusConflict {
+		t.Fatalf("second report: %+v", h)
+	}
+	if got := ts.job(job.ID); got.Result != int(common.Successful) || got.Attempts != 1 {
+		t.Fatalf("job after second report: %+v", got)
+	}
+}
+
+func TestReportValidation(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234")
+	job := ts.createJob("cloud-1", "20200218", 2, common.CrawlTypeCloudPhone)
+
+	q := url.Values{"act_type": {"2"}, "env_type": {common.CrawlTypeCloudPhone}, "worker_id": {"dev-1"}}
+	w := ts.do(http.MethodGet, "/greedy/data/phones?"+q.Encode(), nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), job.ID) {
+		t.Fatalf("dispatch: %v", w.Body.String())
+	}
+	if got := ts.job(job.ID); len(got.History) != 1 || got.History[0].Dispatched != 2 {
+		t.Fatalf("history after dispatch: %+v", got.History)
+	}
+
+	report := func(workerID string, phones ...string) common.RespHeader {
+		body := ReportBody{JobID: job.ID, JobResult: 1}
+		for _, p := range phones {
+			body.JobDetail = append(body.JobDetail, JobDetail{Phone: p, Batch: "20200218"})
+		}
+		w := ts.do(http.MethodPost, "/greedy/data/report?worker_id="+workerID, body)
+		res := struct {
+			Header common.RespHeader `json:"responseHeader"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		ts.waitAsync()
+		return res.Header
+	}
+	// 其他设备上报
+	if h := report("dev-2", "15330091234"); h.Status != http.StatusForbidden {
+		t.Fatalf("report from other device: %+v", h)
+	}
+	// 上报未下发的号码
+	if h := report("dev-1", "15330091234", "13900001234"); h.Code != common.CodeUnknownPhones || !strings.Contains(h.Detail, "13900001234") {
+		t.Fatalf("report unknown phone: %+v", h)
+	}
+	if got := ts.job(job.ID); got.Status != int(common.Running) {
+		t.Fatalf("job after rejected reports: %+v", got)
+	}
+	docs, _ := ts.stores.Results.CrawlResultGet(map[string]string{"job_id": job.ID})
+	if len(docs) != 0 {
+		t.Fatalf("results stored for rejected reports: %v", len(docs))
+	}
+
+	if h := report("dev-1", "15330091234"); h.Status != http.StatusOK {
+		t.Fatalf("report: %+v", h)
+	}
+	if got := ts.job(job.ID); got.Status != int(common.Done) || got.History[0].Reported != 1 {
+		t.Fatalf("job after report: %+v", got)
+	}
+}
+
+func TestInterleavedDispatch(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234")
+	job := ts.createJob("cloud-1", "20200218", 2, common.CrawlTypeCloudPhone)
+
+	// A匹配到任务后 B完成整个下发并解锁 A才加锁
+	a, b := &PhonesResp{}, &PhonesResp{}
+	devA := storage.LockHolder{WorkerID: "dev-a", IP: "10.0.0.1"}
+	devB := storage.LockHolder{WorkerID: "dev-b", IP: "10.0.0.2"}
+	var errB error
+	interleave := func(etcd.JobEtcd) bool {
+		if errB == nil && b.JobID == "" {
+			errB = b.dispatch(context.Background(), ts.stores, common.CloudPhone, devB)
+		}
+		return true
+	}
+	errA := a.dispatch(context.Background(), ts.stores, common.CloudPhone, devA, interleave)
+	if errB != nil || b.JobID != job.ID {
+		t.Fatalf("dispatch B: %+v, %v", b, errB)
+	}
+	if errA != core.ErrNoJobMatched {
+		t.Fatalf("dispatch A on stale job: %+v, %v", a, errA)
+	}
+	got := ts.job(job.ID)
+	if got.Attempts != 1 || len(got.History) != 1 || got.History[0].Device != devB.Device() {
+		t.Fatalf("job after interleaved dispatch: %+v", got)
+	}
+}
+
+func TestDispatchBeforeImport(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234")
+	job := etcd.JobEtcd{
+		ID:        "job-1",
+		Name:      "cloud-1",
+		Source:    etcd.JobDataSource{Batch: "20200218", Count: 2},
+		CrawlType: int(common.CloudPhone),
+		Status:    int(common.Pendding),
+		CreatedAt: time.Now().Format(core.TimeLayout),
+	}
+	if err := ts.stores.Jobs.JobCreate(job); err != nil {
+		t.Fatal(err)
+	}
+
+	// 数据导入mysql之前已被首次下发
+	res := ts.fetchPhones("1", common.CrawlTypeCloudPhone)
+	if res.Data.JobID != job.ID || len(res.Data.Phones) != 2 {
+		t.Fatalf("dispatch before import: %+v", res)
+	}
+	ts.api.ImportJobData(job)
+	ts.waitAsync()
+	if ds, _ := ts.stores.JobData.BatchFetch(job.ID, mysql.DataDispatched); len(ds) != 2 {
+		t.Fatalf("dispatched data after import = %v, want 2", len(ds))
+	}
+
+	ts.report(ReportBody{JobID: job.ID, JobResult: 1, JobDetail: []JobDetail{
+		{Phone: "15330091234", Result: `{"tag":"快递"}`, Type: 2, Source: 1, Batch: "20200218"},
+		{Phone: "15757121234", Result: "{}", Type: 2, Source: 1, Batch: "20200218"},
+	}})
+	hit, _ := ts.stores.JobData.BatchFetch(job.ID, mysql.DataSucceeded)
+	miss, _ := ts.stores.JobData.BatchFetch(job.ID, mysql.DataNoResult)
+	if len(hit) != 1 || len(miss) != 1 {
+		t.Fatalf("data after report: hit %v, miss %v", len(hit), len(miss))
+	}
+}
+
+func TestExportColumns(t *testing.T) {
+	ts := newTestServer(t)
+	addSources(ts, "20200218", "15330091234", "15757121234")
+	job := ts.createJob("cloud-1", "20200218", 2, common.CrawlTypeCloudPhone)
+	ts.fetchPhones("1", common.CrawlTypeCloudPhone)
+	ts.report(ReportBody{JobID: job.ID, JobResult: 1, JobDetail: []JobDetail{
+		{Phone: "15330091234", Result: `{"name":"顺丰速运","tag":"快递","category":"物流"}`, Type: 2, Source: 1, Batch: "20200218"},
+		{Phone: "15757121234", Result: "{}", Type: 2, Source: 1, Batch: "20200218"},
+	}})
+	// 早期入库的结果只有原始的抓取结果
+	ts.stores.Results.CrawlResultInsert([]mongo.CrawlResult{{
+		ID: bson.NewObjectId(), Phone: "13800001234", JobID: job.ID, Batch: "20200218", Type: 2, Source: 2,
+		Result: `{"tag":"骚扰电话"}`,
+	}})
+
+	filter, _ := json.Marshal(map[string]string{"job_id": job.ID})
+	w := ts.do(http.MethodGet, "/greedy/data/export?format=csv&filter="+url.QueryEscape(string(filter)), nil)
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), "\xEF\xBB\xBF"))).ReadAll()
+	if err != nil || len(records) != 4 {
+		t.Fatalf("export: %v, body %v", err, w.Body.String())
+	}
+	col := map[string]int{}
+	for i, h := range records[0] {
+		col[h] = i
+	}
+	got := map[string]string{}
+	for _, r := range records[1:] {
+		got[r[col["phone"]]] = strings.Join([]string{r[col["name"]], r[col["tag"]], r[col["category"]], r[col["crawl_source_name"]]}, "|")
+	}
+	want := map[string]string{
+		"15330091234": "顺丰速运|快递|物流|360手机卫士",
+		"15757121234": "|||360手机卫士",
+		"13800001234": "|骚扰电话||搜狗号码通",
+	}
+	for phone, w := range want {
+		if got[phone] != w {
+			t.Fatalf("export row of %v = %q, want %q", phone, got[phone], w)
+		}
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code common.Code
+	}{
+		{storage.ErrJobNotFound, common.CodeJobNotFound},
+		// 并发修改可以重试 与状态不允许的操作区分
+		{storage.ErrJobConflict, common.CodeJobConflict},
+		{storage.ErrLockFenced, common.CodeLockConflict},
+		{fmt.Errorf("boom"), common.CodeInternal},
+	}
+	for _, c := range cases {
+		if e := apiError(c.err); e.Code != c.code {
+			t.Errorf("apiError(%v) = %v, want %v", c.err, e.Code, c.code)
+		}
+	}
+}
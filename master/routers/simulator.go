@@ -0,0 +1,41 @@
+package routers
+
+import (
+	"context"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/config"
+	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+)
+
+// 未配置时模拟器的默认策略
+const (
+	defaultSimulatorBatch    = 20
+	defaultSimulatorInterval = 5
+)
+
+// SuitSimulator 适配模拟器
+// 只匹配app版本满足要求的任务 每轮抓取的号码数按模拟器镜像配置
+func (s *PhonesResp) SuitSimulator(ctx context.Context, st storage.Stores, cfg config.SimulatorConfig, holder storage.LockHolder, image, appVersion string) (err error) {
+	if err = s.dispatch(ctx, st, common.Simulator, holder, core.AppVersionFilter(appVersion)); err != nil {
+		return
+	}
+	s.Policy.Num, s.Policy.Interval = simulatorPolicy(cfg, image)
+	return
+}
+
+// simulatorPolicy 镜像对应的每轮号码数及间隔
+func simulatorPolicy(cfg config.SimulatorConfig, image string) (num, interval int) {
+	num, interval = cfg.BatchSize, cfg.Interval
+	if n, ok := cfg.BatchSizes[image]; ok && n > 0 {
+		num = n
+	}
+	if num <= 0 {
+		num = defaultSimulatorBatch
+	}
+	if interval <= 0 {
+		interval = defaultSimulatorInterval
+	}
+	return
+}
@@ -0,0 +1,192 @@
+package routers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
+
+	"github.com/MolenZhang/log"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// StatsResp 统计面板
+type StatsResp struct {
+	Jobs        core.JobSummary `json:"jobs"`
+	CrawlTypes  []TypeStat      `json:"crawl_types"`  // 按执行方式的结果统计
+	Sources     []SourceStat    `json:"sources"`      // 按结果来源的命中率
+	JobDetails  []JobStat       `json:"job_details"`  // 按任务的号码统计
+	Throughput  []HourStat      `json:"throughput"`   // 每小时上报量
+	GeneratedAt string          `json:"generated_at"` // 统计时间
+}
+
+// TypeStat 执行方式维度统计
+type TypeStat struct {
+	CrawlType int    `json:"crawl_type"`
+	Name      string `json:"name"`
+	Total     int    `json:"total"`
+	Hit       int    `json:"hit"`
+}
+
+// SourceStat 结果来源维度统计
+type SourceStat struct {
+	CrawlSource int     `json:"crawl_source"`
+	Name        string  `json:"name"`
+	Total       int     `json:"total"`
+	Hit         int     `json:"hit"`
+	HitRate     float64 `json:"hit_rate"`
+}
+
+// JobStat 单个任务的号码统计
+type JobStat struct {
+	JobID      string  `json:"job_id"`
+	Name       string  `json:"name"`
+	Status     int     `json:"status"`
+	Dispatched int64   `json:"dispatched"`  // 下发的号码数
+	Reported   int     `json:"reported"`    // 已上报的号码数
+	WithResult int     `json:"with_result"` // 有结果的号码数
+	Duration   float64 `json:"duration"`    // 执行时长 单位秒
+}
+
+// HourStat 每小时上报量
+type HourStat struct {
+	Hour  string `json:"hour"` // UTC
+	Total int    `json:"total"`
+	Hit   int    `json:"hit"`
+}
+
+// Stats 任务及结果统计
+// hours 吞吐量统计的时间窗口 默认24小时
+func (a *API) Stats(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours <= 0 {
+		res.Fail(common.NewError(common.CodeInvalidParameters, "hours is invalid"))
+		log.Errorf("[Stats] Parameter hours is invalid: %v", c.Query("hours"))
+		return
+	}
+
+	jobs, err := a.Stores.Jobs.JobLists()
+	if err != nil {
+		res.Fail(apiError(err))
+		log.Errorf("[Stats] Get Job Lists Error: %v", err)
+		return
+	}
+
+	resp := StatsResp{
+		Jobs:        core.Summarize(jobs),
+		CrawlTypes:  []TypeStat{},
+		Sources:     []SourceStat{},
+		JobDetails:  []JobStat{},
+		Throughput:  []HourStat{},
+		GeneratedAt: time.Now().Format(core.TimeLayout),
+	}
+
+	byType, err := a.Stores.Results.CrawlResultStats("crawl_type")
+	if err != nil {
+		res.Fail(apiError(err))
+		return
+	}
+	for _, s := range byType {
+		t := statKeyInt(s.Key)
+		resp.CrawlTypes = append(resp.CrawlTypes, TypeStat{
+			CrawlType: t,
+			Name:      parseCrawlType(t),
+			Total:     s.Total,
+			Hit:       s.Hit,
+		})
+	}
+
+	bySource, err := a.Stores.Results.CrawlResultStats("crawl_source")
+	if err != nil {
+		res.Fail(apiError(err))
+		return
+	}
+	for _, s := range bySource {
+		src := statKeyInt(s.Key)
+		ss := SourceStat{
+			CrawlSource: src,
+			Name:        parseCrawlSource(src),
+			Total:       s.Total,
+			Hit:         s.Hit,
+		}
+		if s.Total != 0 {
+			ss.HitRate = float64(s.Hit) / float64(s.Total)
+		}
+		resp.Sources = append(resp.Sources, ss)
+	}
+
+	byJob, err := a.Stores.Results.CrawlResultStats("job_id")
+	if err != nil {
+		res.Fail(apiError(err))
+		return
+	}
+	reported := map[string]mongo.ResultStat{}
+	for _, s := range byJob {
+		reported[fmt.Sprint(s.Key)] = s
+	}
+
+	// 已开始执行的任务 其数据集即为下发的号码
+	dispatched := map[string]int64{}
+	counts, err := a.Stores.JobData.CountByJob()
+	if err != nil {
+		// mysql统计失败时不影响其他数据展示
+		log.Error("[Stats] Count Job Data Error", zap.Error(err))
+	}
+	for _, cnt := range counts {
+		dispatched[cnt.JobID.String] = cnt.Total
+	}
+
+	for _, job := range jobs {
+		js := JobStat{
+			JobID:      job.ID,
+			Name:       job.Name,
+			Status:     job.Status,
+			Reported:   reported[job.ID].Total,
+			WithResult: reported[job.ID].Hit,
+		}
+		if len(job.StartedAt) != 0 {
+			js.Dispatched = dispatched[job.ID]
+		}
+		if d, ok := core.JobDuration(job); ok {
+			js.Duration = d.Seconds()
+		}
+		resp.JobDetails = append(resp.JobDetails, js)
+	}
+
+	hourly, err := a.Stores.Results.CrawlResultHourly(time.Now().Add(-time.Duration(hours) * time.Hour))
+	if err != nil {
+		res.Fail(apiError(err))
+		return
+	}
+	for _, s := range hourly {
+		resp.Throughput = append(resp.Throughput, HourStat{
+			Hour:  fmt.Sprint(s.Key),
+			Total: s.Total,
+			Hit:   s.Hit,
+		})
+	}
+
+	res.SetData(resp)
+}
+
+// statKeyInt mongo聚合的分组键可能为int/int64/float64
+func statKeyInt(key interface{}) int {
+	switch v := key.(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
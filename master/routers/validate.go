@@ -0,0 +1,267 @@
+package routers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+
+	"github.com/MolenZhang/log"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+// 请求体的校验规则写在binding标签中 由gin的校验器(go-playground/validator.v8)校验 同时用于生成OpenAPI文档
+// 常用规则 required 不能为空 eq=1|eq=2 取值范围 min=1/max=10 数字的大小或字符串及数组的长度 dive 校验数组中的每一项
+
+func init() {
+	// 与gin默认的校验器相同 出错的字段以json名返回
+	binding.Validator = &structValidator{
+		validate: validator.New(&validator.Config{TagName: "binding", FieldNameTag: "json"}),
+	}
+}
+
+// structValidator 替换gin默认的校验器
+type structValidator struct {
+	validate *validator.Validate
+}
+
+// ValidateStruct .
+func (v *structValidator) ValidateStruct(obj interface{}) error {
+	value := reflect.ValueOf(obj)
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	return v.validate.Struct(obj)
+}
+
+// Engine .
+func (v *structValidator) Engine() interface{} {
+	return v.validate
+}
+
+// ValidationError 请求体校验失败
+type ValidationError struct {
+	Fields []common.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		if len(f.Field) == 0 {
+			msgs = append(msgs, f.Error)
+			continue
+		}
+		msgs = append(msgs, f.Field+" "+f.Error)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate 按binding标签校验结构体 返回所有不满足规则的字段 用于不经过gin绑定的请求
+func Validate(v interface{}) error {
+	if err := binding.Validator.ValidateStruct(v); err != nil {
+		return validationError(v, err)
+	}
+	return nil
+}
+
+// validationError 校验器的错误转换为字段级的错误 按字段排序
+func validationError(obj interface{}, err error) *ValidationError {
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return &ValidationError{Fields: []common.FieldError{{Error: err.Error()}}}
+	}
+	fields := make([]common.FieldError, 0, len(errs))
+	for _, e := range errs {
+		// 去掉结构体名 如WebJob.name
+		name := e.NameNamespace
+		if i := strings.Index(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		fields = append(fields, common.FieldError{Field: name, Error: fieldMessage(obj, e)})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return &ValidationError{Fields: fields}
+}
+
+// fieldMessage 不满足的规则对应的错误信息
+func fieldMessage(obj interface{}, e *validator.FieldError) string {
+	unit := ""
+	switch e.Kind {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " items"
+	}
+	switch e.Tag {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		return "must be at least " + e.Param + unit
+	case "max", "lte":
+		return "must be at most " + e.Param + unit
+	}
+	// eq=1|eq=2 校验器不返回参数 从标签中取出可选的值
+	if r := parseRules(fieldTag(reflect.TypeOf(obj), e.FieldNamespace)); len(r.enum) != 0 {
+		return "must be one of " + strings.Join(r.enum, ",")
+	}
+	return "does not satisfy " + e.Tag
+}
+
+// fieldTag 按字段路径(如ReportBody.JobDetail[1].Phone)取出字段的binding标签
+func fieldTag(t reflect.Type, namespace string) string {
+	parts := strings.Split(namespace, ".")
+	var tag string
+	for _, part := range parts[1:] {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return ""
+		}
+		if i := strings.Index(part, "["); i >= 0 {
+			part = part[:i]
+		}
+		f, ok := t.FieldByName(part)
+		if !ok {
+			return ""
+		}
+		t, tag = f.Type, f.Tag.Get("binding")
+	}
+	return tag
+}
+
+// rules 字段的校验规则 用于错误信息及OpenAPI文档
+type rules struct {
+	required bool
+	enum     []string
+	min, max *float64
+}
+
+// parseRules 解析binding标签 eq=1|eq=2形式的规则视为可选值
+func parseRules(tag string) (r rules) {
+	for _, rule := range strings.Split(tag, ",") {
+		if alts := strings.Split(rule, "|"); len(alts) > 1 {
+			enum := []string{}
+			for _, alt := range alts {
+				kv := strings.SplitN(strings.TrimSpace(alt), "=", 2)
+				if len(kv) != 2 || kv[0] != "eq" {
+					enum = nil
+					break
+				}
+				enum = append(enum, kv[1])
+			}
+			r.enum = enum
+			continue
+		}
+		kv := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		switch kv[0] {
+		case "required":
+			r.required = true
+		case "min", "max":
+			if len(kv) != 2 {
+				continue
+			}
+			v, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				continue
+			}
+			if kv[0] == "min" {
+				r.min = &v
+			} else {
+				r.max = &v
+			}
+		}
+	}
+	return
+}
+
+// jsonName 字段的json名 不参与序列化时返回空
+func jsonName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; len(name) != 0 {
+		return name
+	}
+	return f.Name
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+// decodeError 解析失败时尽量给出出错的字段
+func decodeError(err error) *ValidationError {
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		return &ValidationError{Fields: []common.FieldError{{Field: e.Field, Error: "must be " + jsonType(e.Type)}}}
+	case *json.SyntaxError:
+		return &ValidationError{Fields: []common.FieldError{{Error: fmt.Sprintf("invalid json at offset %d", e.Offset)}}}
+	}
+	return &ValidationError{Fields: []common.FieldError{{Error: "invalid request body"}}}
+}
+
+func jsonType(t reflect.Type) string {
+	switch {
+	case isNumber(t.Kind()):
+		return "a number"
+	case t.Kind() == reflect.String:
+		return "a string"
+	case t.Kind() == reflect.Bool:
+		return "a boolean"
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// bodyKey 校验通过的请求体在gin.Context中的key
+const bodyKey = "greedy/body"
+
+// validateBody 请求体解析并校验通过后才交给handler 失败时返回字段级的错误
+// prototype为请求体的结构 env决定错误的返回格式 handler通过validated取出解析后的请求体
+func validateBody(prototype interface{}, env common.Envelope) gin.HandlerFunc {
+	t := reflect.TypeOf(prototype)
+	return func(c *gin.Context) {
+		var b binding.BindingBody = binding.JSON
+		if common.IsMsgpack(c.ContentType()) {
+			b = binding.MsgPack
+		}
+		v := reflect.New(t).Interface()
+		err := c.ShouldBindBodyWith(v, b)
+		switch err.(type) {
+		case nil:
+			c.Set(bodyKey, v)
+		case validator.ValidationErrors:
+			rejectBody(c, env, common.CodeValidationFailed, validationError(v, err))
+		default:
+			if err == errBodyTooLarge {
+				rejectBody(c, env, common.CodeBodyTooLarge, &ValidationError{Fields: []common.FieldError{{Error: err.Error()}}})
+				return
+			}
+			rejectBody(c, env, common.CodeInvalidBody, decodeError(err))
+		}
+	}
+}
+
+// validated 取出validateBody解析并校验通过的请求体 为prototype类型的指针
+func validated(c *gin.Context) interface{} {
+	return c.MustGet(bodyKey)
+}
+
+// rejectBody 按接口的返回格式返回校验错误
+func rejectBody(c *gin.Context, env common.Envelope, code common.Code, err *ValidationError) {
+	log.Error("[Validate] Invalid Request Body", zap.String("path", c.Request.URL.Path), zap.Error(err))
+	common.Write(c, env, nil, &common.Error{Code: code, Detail: err.Error(), Fields: err.Fields})
+}
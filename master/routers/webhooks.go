@@ -0,0 +1,132 @@
+package routers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+
+	"github.com/MolenZhang/log"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// WebWebhook 创建webhook的请求
+type WebWebhook struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events" binding:"required,min=1"` // job.failed只在任务不再重试时通知
+	Secret string   `json:"secret"`                          // HMAC-SHA256签名密钥 为空时自动生成 仅在创建时返回
+}
+
+// validate 校验地址及事件
+func (w WebWebhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("url is invalid")
+	}
+	if len(w.Events) == 0 {
+		return fmt.Errorf("events is required")
+	}
+	for _, e := range w.Events {
+		if !knownHookEvent(e) {
+			return fmt.Errorf("unknown event: %v, supported: %v", e, strings.Join(storage.HookEvents, ","))
+		}
+	}
+	return nil
+}
+
+func knownHookEvent(event string) bool {
+	for _, e := range storage.HookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookSave 创建webhook
+func (a *API) WebhookSave(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	req := validated(c).(*WebWebhook)
+	if err := req.validate(); err != nil {
+		log.Errorf("[WebhookSave] Validate Webhook Error: %v", err)
+		res.Fail(common.WrapError(common.CodeInvalidParameters, err))
+		return
+	}
+	h := storage.Webhook{
+		ID:        common.GenerateID(),
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		Active:    true,
+		CreatedAt: time.Now().Format(core.TimeLayout),
+	}
+	if len(h.Secret) == 0 {
+		h.Secret = strings.Replace(common.GenerateID(), "-", "", -1)
+	}
+	if err := a.Stores.Webhooks.WebhookSave(h); err != nil {
+		log.Error("[WebhookSave] Save Webhook Error", zap.Error(err))
+		res.Fail(apiError(err))
+		return
+	}
+	res.SetData(h)
+}
+
+// WebhookLists 所有webhook 不返回密钥
+func (a *API) WebhookLists(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	hooks, err := a.Stores.Webhooks.WebhookLists()
+	if err != nil {
+		log.Error("[WebhookLists] Get Webhooks Error", zap.Error(err))
+		res.Fail(apiError(err))
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	res.SetData(hooks)
+}
+
+// WebhookDelete 删除webhook
+func (a *API) WebhookDelete(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	id := c.Param("id")
+	h, err := a.Stores.Webhooks.WebhookDelete(id)
+	switch err {
+	case nil:
+	case storage.ErrWebhookNotFound:
+		res.Fail(apiError(err))
+		return
+	default:
+		log.Error("[WebhookDelete] Delete Webhook Error", zap.Error(err), zap.String("id", id))
+		res.Fail(apiError(err))
+		return
+	}
+	h.Secret = ""
+	res.SetData(h)
+}
+
+// WebhookDeliveries webhook的投递记录
+func (a *API) WebhookDeliveries(c *gin.Context) {
+	res := common.NewResponse(common.EnvelopeJob)
+	defer res.Done(c)
+
+	id := c.Param("id")
+	ds, err := a.Stores.Webhooks.DeliveryLists(id)
+	if err != nil {
+		log.Error("[WebhookDeliveries] Get Deliveries Error", zap.Error(err), zap.String("id", id))
+		res.Fail(apiError(err))
+		return
+	}
+	res.SetData(ds)
+}
@@ -0,0 +1,878 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: greedy.proto
+
+// worker通过gRPC领取号码及上报结果 与HTTP接口共用业务逻辑
+
+package rpc
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type FetchRequest struct {
+	WorkerId             string   `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	EnvType              string   `protobuf:"bytes,2,opt,name=env_type,json=envType,proto3" json:"env_type,omitempty"`
+	Image                string   `protobuf:"bytes,3,opt,name=image,proto3" json:"image,omitempty"`
+	AppVersion           string   `protobuf:"bytes,4,opt,name=app_version,json=appVersion,proto3" json:"app_version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FetchRequest) Reset()         { *m = FetchRequest{} }
+func (m *FetchRequest) String() string { return proto.CompactTextString(m) }
+func (*FetchRequest) ProtoMessage()    {}
+func (*FetchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{0}
+}
+
+func (m *FetchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FetchRequest.Unmarshal(m, b)
+}
+func (m *FetchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FetchRequest.Marshal(b, m, deterministic)
+}
+func (m *FetchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FetchRequest.Merge(m, src)
+}
+func (m *FetchRequest) XXX_Size() int {
+	return xxx_messageInfo_FetchRequest.Size(m)
+}
+func (m *FetchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_FetchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FetchRequest proto.InternalMessageInfo
+
+func (m *FetchRequest) GetWorkerId() string {
+	if m != nil {
+		return m.WorkerId
+	}
+	return ""
+}
+
+func (m *FetchRequest) GetEnvType() string {
+	if m != nil {
+		return m.EnvType
+	}
+	return ""
+}
+
+func (m *FetchRequest) GetImage() string {
+	if m != nil {
+		return m.Image
+	}
+	return ""
+}
+
+func (m *FetchRequest) GetAppVersion() string {
+	if m != nil {
+		return m.AppVersion
+	}
+	return ""
+}
+
+type FetchReply struct {
+	JobId                string   `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	Batch                string   `protobuf:"bytes,2,opt,name=batch,proto3" json:"batch,omitempty"`
+	Phones               []string `protobuf:"bytes,3,rep,name=phones,proto3" json:"phones,omitempty"`
+	PolicyNum            int32    `protobuf:"varint,4,opt,name=policy_num,json=policyNum,proto3" json:"policy_num,omitempty"`
+	PolicyInterval       int32    `protobuf:"varint,5,opt,name=policy_interval,json=policyInterval,proto3" json:"policy_interval,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FetchReply) Reset()         { *m = FetchReply{} }
+func (m *FetchReply) String() string { return proto.CompactTextString(m) }
+func (*FetchReply) ProtoMessage()    {}
+func (*FetchReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{1}
+}
+
+func (m *FetchReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FetchReply.Unmarshal(m, b)
+}
+func (m *FetchReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FetchReply.Marshal(b, m, deterministic)
+}
+func (m *FetchReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FetchReply.Merge(m, src)
+}
+func (m *FetchReply) XXX_Size() int {
+	return xxx_messageInfo_FetchReply.Size(m)
+}
+func (m *FetchReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_FetchReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FetchReply proto.InternalMessageInfo
+
+func (m *FetchReply) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+func (m *FetchReply) GetBatch() string {
+	if m != nil {
+		return m.Batch
+	}
+	return ""
+}
+
+func (m *FetchReply) GetPhones() []string {
+	if m != nil {
+		return m.Phones
+	}
+	return nil
+}
+
+func (m *FetchReply) GetPolicyNum() int32 {
+	if m != nil {
+		return m.PolicyNum
+	}
+	return 0
+}
+
+func (m *FetchReply) GetPolicyInterval() int32 {
+	if m != nil {
+		return m.PolicyInterval
+	}
+	return 0
+}
+
+type Detail struct {
+	Phone                string   `protobuf:"bytes,1,opt,name=phone,proto3" json:"phone,omitempty"`
+	CrawlResult          string   `protobuf:"bytes,2,opt,name=crawl_result,json=crawlResult,proto3" json:"crawl_result,omitempty"`
+	CrawlType            int32    `protobuf:"varint,3,opt,name=crawl_type,json=crawlType,proto3" json:"crawl_type,omitempty"`
+	CrawlSource          int32    `protobuf:"varint,4,opt,name=crawl_source,json=crawlSource,proto3" json:"crawl_source,omitempty"`
+	CrawlBatch           string   `protobuf:"bytes,5,opt,name=crawl_batch,json=crawlBatch,proto3" json:"crawl_batch,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Detail) Reset()         { *m = Detail{} }
+func (m *Detail) String() string { return proto.CompactTextString(m) }
+func (*Detail) ProtoMessage()    {}
+func (*Detail) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{2}
+}
+
+func (m *Detail) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Detail.Unmarshal(m, b)
+}
+func (m *Detail) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Detail.Marshal(b, m, deterministic)
+}
+func (m *Detail) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Detail.Merge(m, src)
+}
+func (m *Detail) XXX_Size() int {
+	return xxx_messageInfo_Detail.Size(m)
+}
+func (m *Detail) XXX_DiscardUnknown() {
+	xxx_messageInfo_Detail.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Detail proto.InternalMessageInfo
+
+func (m *Detail) GetPhone() string {
+	if m != nil {
+		return m.Phone
+	}
+	return ""
+}
+
+func (m *Detail) GetCrawlResult() string {
+	if m != nil {
+		return m.CrawlResult
+	}
+	return ""
+}
+
+func (m *Detail) GetCrawlType() int32 {
+	if m != nil {
+		return m.CrawlType
+	}
+	return 0
+}
+
+func (m *Detail) GetCrawlSource() int32 {
+	if m != nil {
+		return m.CrawlSource
+	}
+	return 0
+}
+
+func (m *Detail) GetCrawlBatch() string {
+	if m != nil {
+		return m.CrawlBatch
+	}
+	return ""
+}
+
+type ReportChunk struct {
+	WorkerId             string    `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	JobId                string    `protobuf:"bytes,2,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	Details              []*Detail `protobuf:"bytes,3,rep,name=details,proto3" json:"details,omitempty"`
+	Complete             bool      `protobuf:"varint,4,opt,name=complete,proto3" json:"complete,omitempty"`
+	JobResult            int32     `protobuf:"varint,5,opt,name=job_result,json=jobResult,proto3" json:"job_result,omitempty"`
+	ErrorMsg             string    `protobuf:"bytes,6,opt,name=error_msg,json=errorMsg,proto3" json:"error_msg,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *ReportChunk) Reset()         { *m = ReportChunk{} }
+func (m *ReportChunk) String() string { return proto.CompactTextString(m) }
+func (*ReportChunk) ProtoMessage()    {}
+func (*ReportChunk) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{3}
+}
+
+func (m *ReportChunk) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReportChunk.Unmarshal(m, b)
+}
+func (m *ReportChunk) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReportChunk.Marshal(b, m, deterministic)
+}
+func (m *ReportChunk) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReportChunk.Merge(m, src)
+}
+func (m *ReportChunk) XXX_Size() int {
+	return xxx_messageInfo_ReportChunk.Size(m)
+}
+func (m *ReportChunk) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReportChunk.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReportChunk proto.InternalMessageInfo
+
+func (m *ReportChunk) GetWorkerId() string {
+	if m != nil {
+		return m.WorkerId
+	}
+	return ""
+}
+
+func (m *ReportChunk) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+func (m *ReportChunk) GetDetails() []*Detail {
+	if m != nil {
+		return m.Details
+	}
+	return nil
+}
+
+func (m *ReportChunk) GetComplete() bool {
+	if m != nil {
+		return m.Complete
+	}
+	return false
+}
+
+func (m *ReportChunk) GetJobResult() int32 {
+	if m != nil {
+		return m.JobResult
+	}
+	return 0
+}
+
+func (m *ReportChunk) GetErrorMsg() string {
+	if m != nil {
+		return m.ErrorMsg
+	}
+	return ""
+}
+
+type ReportSummary struct {
+	Saved                int32    `protobuf:"varint,1,opt,name=saved,proto3" json:"saved,omitempty"`
+	Completed            bool     `protobuf:"varint,2,opt,name=completed,proto3" json:"completed,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReportSummary) Reset()         { *m = ReportSummary{} }
+func (m *ReportSummary) String() string { return proto.CompactTextString(m) }
+func (*ReportSummary) ProtoMessage()    {}
+func (*ReportSummary) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{4}
+}
+
+func (m *ReportSummary) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReportSummary.Unmarshal(m, b)
+}
+func (m *ReportSummary) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReportSummary.Marshal(b, m, deterministic)
+}
+func (m *ReportSummary) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReportSummary.Merge(m, src)
+}
+func (m *ReportSummary) XXX_Size() int {
+	return xxx_messageInfo_ReportSummary.Size(m)
+}
+func (m *ReportSummary) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReportSummary.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReportSummary proto.InternalMessageInfo
+
+func (m *ReportSummary) GetSaved() int32 {
+	if m != nil {
+		return m.Saved
+	}
+	return 0
+}
+
+func (m *ReportSummary) GetCompleted() bool {
+	if m != nil {
+		return m.Completed
+	}
+	return false
+}
+
+type HeartbeatRequest struct {
+	WorkerId             string   `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	JobId                string   `protobuf:"bytes,2,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HeartbeatRequest) Reset()         { *m = HeartbeatRequest{} }
+func (m *HeartbeatRequest) String() string { return proto.CompactTextString(m) }
+func (*HeartbeatRequest) ProtoMessage()    {}
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{5}
+}
+
+func (m *HeartbeatRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HeartbeatRequest.Unmarshal(m, b)
+}
+func (m *HeartbeatRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HeartbeatRequest.Marshal(b, m, deterministic)
+}
+func (m *HeartbeatRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HeartbeatRequest.Merge(m, src)
+}
+func (m *HeartbeatRequest) XXX_Size() int {
+	return xxx_messageInfo_HeartbeatRequest.Size(m)
+}
+func (m *HeartbeatRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_HeartbeatRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HeartbeatRequest proto.InternalMessageInfo
+
+func (m *HeartbeatRequest) GetWorkerId() string {
+	if m != nil {
+		return m.WorkerId
+	}
+	return ""
+}
+
+func (m *HeartbeatRequest) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+type HeartbeatReply struct {
+	Assigned             bool     `protobuf:"varint,1,opt,name=assigned,proto3" json:"assigned,omitempty"`
+	State                string   `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`
+	ServerTime           int64    `protobuf:"varint,3,opt,name=server_time,json=serverTime,proto3" json:"server_time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HeartbeatReply) Reset()         { *m = HeartbeatReply{} }
+func (m *HeartbeatReply) String() string { return proto.CompactTextString(m) }
+func (*HeartbeatReply) ProtoMessage()    {}
+func (*HeartbeatReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{6}
+}
+
+func (m *HeartbeatReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HeartbeatReply.Unmarshal(m, b)
+}
+func (m *HeartbeatReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HeartbeatReply.Marshal(b, m, deterministic)
+}
+func (m *HeartbeatReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HeartbeatReply.Merge(m, src)
+}
+func (m *HeartbeatReply) XXX_Size() int {
+	return xxx_messageInfo_HeartbeatReply.Size(m)
+}
+func (m *HeartbeatReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_HeartbeatReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HeartbeatReply proto.InternalMessageInfo
+
+func (m *HeartbeatReply) GetAssigned() bool {
+	if m != nil {
+		return m.Assigned
+	}
+	return false
+}
+
+func (m *HeartbeatReply) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+func (m *HeartbeatReply) GetServerTime() int64 {
+	if m != nil {
+		return m.ServerTime
+	}
+	return 0
+}
+
+type WatchKillRequest struct {
+	WorkerId             string   `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	JobId                string   `protobuf:"bytes,2,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchKillRequest) Reset()         { *m = WatchKillRequest{} }
+func (m *WatchKillRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchKillRequest) ProtoMessage()    {}
+func (*WatchKillRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{7}
+}
+
+func (m *WatchKillRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchKillRequest.Unmarshal(m, b)
+}
+func (m *WatchKillRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchKillRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchKillRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchKillRequest.Merge(m, src)
+}
+func (m *WatchKillRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchKillRequest.Size(m)
+}
+func (m *WatchKillRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchKillRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchKillRequest proto.InternalMessageInfo
+
+func (m *WatchKillRequest) GetWorkerId() string {
+	if m != nil {
+		return m.WorkerId
+	}
+	return ""
+}
+
+func (m *WatchKillRequest) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+type KillEvent struct {
+	JobId                string   `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	JobName              string   `protobuf:"bytes,2,opt,name=job_name,json=jobName,proto3" json:"job_name,omitempty"`
+	Reason               string   `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *KillEvent) Reset()         { *m = KillEvent{} }
+func (m *KillEvent) String() string { return proto.CompactTextString(m) }
+func (*KillEvent) ProtoMessage()    {}
+func (*KillEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d7e5a056ec91725, []int{8}
+}
+
+func (m *KillEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_KillEvent.Unmarshal(m, b)
+}
+func (m *KillEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_KillEvent.Marshal(b, m, deterministic)
+}
+func (m *KillEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KillEvent.Merge(m, src)
+}
+func (m *KillEvent) XXX_Size() int {
+	return xxx_messageInfo_KillEvent.Size(m)
+}
+func (m *KillEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_KillEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KillEvent proto.InternalMessageInfo
+
+func (m *KillEvent) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+func (m *KillEvent) GetJobName() string {
+	if m != nil {
+		return m.JobName
+	}
+	return ""
+}
+
+func (m *KillEvent) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*FetchRequest)(nil), "greedy.FetchRequest")
+	proto.RegisterType((*FetchReply)(nil), "greedy.FetchReply")
+	proto.RegisterType((*Detail)(nil), "greedy.Detail")
+	proto.RegisterType((*ReportChunk)(nil), "greedy.ReportChunk")
+	proto.RegisterType((*ReportSummary)(nil), "greedy.ReportSummary")
+	proto.RegisterType((*HeartbeatRequest)(nil), "greedy.HeartbeatRequest")
+	proto.RegisterType((*HeartbeatReply)(nil), "greedy.HeartbeatReply")
+	proto.RegisterType((*WatchKillRequest)(nil), "greedy.WatchKillRequest")
+	proto.RegisterType((*KillEvent)(nil), "greedy.KillEvent")
+}
+
+func init() { proto.RegisterFile("greedy.proto", fileDescriptor_3d7e5a056ec91725) }
+
+var fileDescriptor_3d7e5a056ec91725 = []byte{
+	// 632 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x54, 0x41, 0x6f, 0xd3, 0x4a,
+	0x10, 0x96, 0x9b, 0x97, 0xd4, 0x9e, 0xf4, 0xf5, 0x3d, 0x96, 0xb6, 0x72, 0x53, 0x10, 0xc5, 0x17,
+	0x72, 0xaa, 0x50, 0x39, 0x20, 0x21, 0xf5, 0xd2, 0x42, 0xa1, 0x42, 0x54, 0xc8, 0x2d, 0x20, 0x71,
+	0xb1, 0xd6, 0xce, 0x28, 0x71, 0x6a, 0x7b, 0xcd, 0x7a, 0xed, 0x2a, 0x07, 0x7e, 0x08, 0x77, 0xfe,
+	0x09, 0x3f, 0x8b, 0x0b, 0xda, 0x9d, 0xb5, 0x13, 0xaa, 0x82, 0x90, 0x38, 0x7e, 0xdf, 0xac, 0x67,
+	0xbe, 0xf9, 0x66, 0xc6, 0xb0, 0x31, 0x95, 0x88, 0x93, 0xc5, 0x41, 0x29, 0x85, 0x12, 0x6c, 0x40,
+	0x28, 0xf8, 0x0c, 0x1b, 0xa7, 0xa8, 0x92, 0x59, 0x88, 0x9f, 0x6a, 0xac, 0x14, 0xdb, 0x03, 0xef,
+	0x5a, 0xc8, 0x2b, 0x94, 0x51, 0x3a, 0xf1, 0x9d, 0x7d, 0x67, 0xec, 0x85, 0x2e, 0x11, 0x67, 0x13,
+	0xb6, 0x0b, 0x2e, 0x16, 0x4d, 0xa4, 0x16, 0x25, 0xfa, 0x6b, 0x26, 0xb6, 0x8e, 0x45, 0x73, 0xb9,
+	0x28, 0x91, 0x6d, 0x41, 0x3f, 0xcd, 0xf9, 0x14, 0xfd, 0x9e, 0xe1, 0x09, 0xb0, 0x07, 0x30, 0xe4,
+	0x65, 0x19, 0x35, 0x28, 0xab, 0x54, 0x14, 0xfe, 0x3f, 0x26, 0x06, 0xbc, 0x2c, 0xdf, 0x13, 0x13,
+	0x7c, 0x71, 0x00, 0x6c, 0xfd, 0x32, 0x5b, 0xb0, 0x6d, 0x18, 0xcc, 0x45, 0xbc, 0x2c, 0xdd, 0x9f,
+	0x8b, 0xf8, 0x6c, 0xa2, 0x93, 0xc7, 0x5c, 0x25, 0x33, 0x5b, 0x94, 0x00, 0xdb, 0x81, 0x41, 0x39,
+	0x13, 0x05, 0x56, 0x7e, 0x6f, 0xbf, 0x37, 0xf6, 0x42, 0x8b, 0xd8, 0x7d, 0x80, 0x52, 0x64, 0x69,
+	0xb2, 0x88, 0x8a, 0x3a, 0x37, 0x35, 0xfb, 0xa1, 0x47, 0xcc, 0x79, 0x9d, 0xb3, 0x47, 0xf0, 0x9f,
+	0x0d, 0xa7, 0x85, 0x42, 0xd9, 0xf0, 0xcc, 0xef, 0x9b, 0x37, 0x9b, 0x44, 0x9f, 0x59, 0x36, 0xf8,
+	0xea, 0xc0, 0xe0, 0x39, 0x2a, 0x9e, 0x66, 0x5a, 0x80, 0x49, 0xde, 0xca, 0x32, 0x80, 0x3d, 0x84,
+	0x8d, 0x44, 0xf2, 0xeb, 0x2c, 0x92, 0x58, 0xd5, 0x99, 0xb2, 0xea, 0x86, 0x86, 0x0b, 0x0d, 0xa5,
+	0xb5, 0xd0, 0x13, 0xe3, 0x59, 0x8f, 0xb4, 0x18, 0xc6, 0xb8, 0xd6, 0x65, 0xa8, 0x44, 0x2d, 0x13,
+	0xb4, 0x62, 0x29, 0xc3, 0x85, 0xa1, 0xb4, 0x85, 0xf4, 0x84, 0x1c, 0xe8, 0x93, 0x85, 0x86, 0x3a,
+	0xd6, 0x4c, 0xf0, 0xcd, 0x81, 0x61, 0x88, 0xa5, 0x90, 0xea, 0x64, 0x56, 0x17, 0x57, 0xbf, 0x9f,
+	0xe0, 0xd2, 0xe0, 0xb5, 0x55, 0x83, 0xc7, 0xb0, 0x3e, 0x31, 0x9d, 0x92, 0x97, 0xc3, 0xc3, 0xcd,
+	0x03, 0xbb, 0x2d, 0x64, 0x40, 0xd8, 0x86, 0xd9, 0x08, 0xdc, 0x44, 0xe4, 0x65, 0x86, 0x8a, 0xd4,
+	0xba, 0x61, 0x87, 0x75, 0xb3, 0x3a, 0xb9, 0x75, 0x83, 0x4c, 0xf5, 0xe6, 0x22, 0xb6, 0x5e, 0xec,
+	0x81, 0x87, 0x52, 0x0a, 0x19, 0xe5, 0xd5, 0xd4, 0x1f, 0x90, 0x30, 0x43, 0xbc, 0xa9, 0xa6, 0xc1,
+	0x09, 0xfc, 0x4b, 0x4d, 0x5c, 0xd4, 0x79, 0xce, 0xe5, 0x42, 0x5b, 0x5e, 0xf1, 0x06, 0xa9, 0x85,
+	0x7e, 0x48, 0x80, 0xdd, 0x03, 0xaf, 0x2d, 0x47, 0x2d, 0xb8, 0xe1, 0x92, 0x08, 0x4e, 0xe1, 0xff,
+	0x57, 0xc8, 0xa5, 0x8a, 0x91, 0xab, 0x3f, 0x5a, 0xe8, 0xdb, 0xed, 0x08, 0x12, 0xd8, 0x5c, 0xc9,
+	0xa3, 0x17, 0x73, 0x04, 0x2e, 0xaf, 0xaa, 0x74, 0x5a, 0x58, 0x41, 0x6e, 0xd8, 0x61, 0xa3, 0x54,
+	0x71, 0xd5, 0x9e, 0x04, 0x01, 0x3d, 0xb7, 0x0a, 0x65, 0x83, 0x32, 0x52, 0x69, 0x4e, 0xa3, 0xef,
+	0x85, 0x40, 0xd4, 0x65, 0x9a, 0xa3, 0x16, 0xfb, 0x41, 0x0f, 0xf0, 0x75, 0x9a, 0x65, 0x7f, 0x23,
+	0xf6, 0x1d, 0x78, 0x3a, 0xc5, 0x8b, 0x06, 0x0b, 0xf5, 0xab, 0x03, 0xda, 0x05, 0x57, 0xd3, 0x05,
+	0xcf, 0xbb, 0xc3, 0x9d, 0x8b, 0xf8, 0x9c, 0xe7, 0xa8, 0xaf, 0x48, 0x22, 0xaf, 0x44, 0x61, 0x2f,
+	0xd7, 0xa2, 0xc3, 0xef, 0x0e, 0x0c, 0x5e, 0x9a, 0x1d, 0x60, 0x4f, 0x61, 0x68, 0x6e, 0xf4, 0x2d,
+	0xdd, 0xd7, 0x56, 0xbb, 0x1b, 0xab, 0x3f, 0x8e, 0x11, 0xbb, 0xc1, 0x6a, 0xd7, 0x8e, 0xda, 0xa1,
+	0xd2, 0x06, 0x54, 0xec, 0x6e, 0xfb, 0x68, 0x65, 0x61, 0x47, 0xdb, 0x3f, 0x93, 0x76, 0x01, 0xc6,
+	0x0e, 0x3b, 0x02, 0xaf, 0x1b, 0x03, 0xf3, 0xdb, 0x57, 0x37, 0x27, 0x3c, 0xda, 0xb9, 0x25, 0xa2,
+	0xab, 0x3f, 0x03, 0xaf, 0x33, 0x78, 0xf9, 0xf9, 0x4d, 0xcf, 0x47, 0x77, 0xda, 0x48, 0xe7, 0xe2,
+	0x63, 0xe7, 0xb8, 0xff, 0xb1, 0x27, 0xcb, 0x24, 0x1e, 0x98, 0x9f, 0xe5, 0x93, 0x1f, 0x03, 0x00,
+	0xbd, 0x4f, 0x8a, 0xe5, 0x3c, 0x05, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// GreedyClient is the client API for Greedy service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type GreedyClient interface {
+	// 领取号码 无可执行任务时返回NOT_FOUND
+	FetchPhones(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchReply, error)
+	// 流式上报 complete为false的消息追加上报 complete为true的消息结束本次执行
+	ReportResults(ctx context.Context, opts ...grpc.CallOption) (Greedy_ReportResultsClient, error)
+	// 心跳 返回任务是否仍由该设备执行
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatReply, error)
+	// 订阅该设备执行中任务的强杀及删除
+	WatchKill(ctx context.Context, in *WatchKillRequest, opts ...grpc.CallOption) (Greedy_WatchKillClient, error)
+}
+
+type greedyClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewGreedyClient(cc *grpc.ClientConn) GreedyClient {
+	return &greedyClient{cc}
+}
+
+func (c *greedyClient) FetchPhones(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchReply, error) {
+	out := new(FetchReply)
+	err := c.cc.Invoke(ctx, "/greedy.Greedy/FetchPhones", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *greedyClient) ReportResults(ctx context.Context, opts ...grpc.CallOption) (Greedy_ReportResultsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Greedy_serviceDesc.Streams[0], "/greedy.Greedy/ReportResults", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &greedyReportResultsClient{stream}
+	return x, nil
+}
+
+type Greedy_ReportResultsClient interface {
+	Send(*ReportChunk) error
+	CloseAndRecv() (*ReportSummary, error)
+	grpc.ClientStream
+}
+
+type greedyReportResultsClient struct {
+	grpc.ClientStream
+}
+
+func (x *greedyReportResultsClient) Send(m *ReportChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *greedyReportResultsClient) CloseAndRecv() (*ReportSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ReportSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *greedyClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatReply, error) {
+	out := new(HeartbeatReply)
+	err := c.cc.Invoke(ctx, "/greedy.Greedy/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *greedyClient) WatchKill(ctx context.Context, in *WatchKillRequest, opts ...grpc.CallOption) (Greedy_WatchKillClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Greedy_serviceDesc.Streams[1], "/greedy.Greedy/WatchKill", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &greedyWatchKillClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Greedy_WatchKillClient interface {
+	Recv() (*KillEvent, error)
+	grpc.ClientStream
+}
+
+type greedyWatchKillClient struct {
+	grpc.ClientStream
+}
+
+func (x *greedyWatchKillClient) Recv() (*KillEvent, error) {
+	m := new(KillEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GreedyServer is the server API for Greedy service.
+type GreedyServer interface {
+	// 领取号码 无可执行任务时返回NOT_FOUND
+	FetchPhones(context.Context, *FetchRequest) (*FetchReply, error)
+	// 流式上报 complete为false的消息追加上报 complete为true的消息结束本次执行
+	ReportResults(Greedy_ReportResultsServer) error
+	// 心跳 返回任务是否仍由该设备执行
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatReply, error)
+	// 订阅该设备执行中任务的强杀及删除
+	WatchKill(*WatchKillRequest, Greedy_WatchKillServer) error
+}
+
+// UnimplementedGreedyServer can be embedded to have forward compatible implementations.
+type UnimplementedGreedyServer struct {
+}
+
+func (*UnimplementedGreedyServer) FetchPhones(ctx context.Context, req *FetchRequest) (*FetchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchPhones not implemented")
+}
+func (*UnimplementedGreedyServer) ReportResults(srv Greedy_ReportResultsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReportResults not implemented")
+}
+func (*UnimplementedGreedyServer) Heartbeat(ctx context.Context, req *HeartbeatRequest) (*HeartbeatReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (*UnimplementedGreedyServer) WatchKill(req *WatchKillRequest, srv Greedy_WatchKillServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchKill not implemented")
+}
+
+func RegisterGreedyServer(s *grpc.Server, srv GreedyServer) {
+	s.RegisterService(&_Greedy_serviceDesc, srv)
+}
+
+func _Greedy_FetchPhones_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GreedyServer).FetchPhones(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/greedy.Greedy/FetchPhones",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GreedyServer).FetchPhones(ctx, req.(*FetchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Greedy_ReportResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GreedyServer).ReportResults(&greedyReportResultsServer{stream})
+}
+
+type Greedy_ReportResultsServer interface {
+	SendAndClose(*ReportSummary) error
+	Recv() (*ReportChunk, error)
+	grpc.ServerStream
+}
+
+type greedyReportResultsServer struct {
+	grpc.ServerStream
+}
+
+func (x *greedyReportResultsServer) SendAndClose(m *ReportSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *greedyReportResultsServer) Recv() (*ReportChunk, error) {
+	m := new(ReportChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Greedy_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GreedyServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/greedy.Greedy/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GreedyServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Greedy_WatchKill_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchKillRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GreedyServer).WatchKill(m, &greedyWatchKillServer{stream})
+}
+
+type Greedy_WatchKillServer interface {
+	Send(*KillEvent) error
+	grpc.ServerStream
+}
+
+type greedyWatchKillServer struct {
+	grpc.ServerStream
+}
+
+func (x *greedyWatchKillServer) Send(m *KillEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Greedy_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "greedy.Greedy",
+	HandlerType: (*GreedyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "FetchPhones",
+			Handler:    _Greedy_FetchPhones_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Greedy_Heartbeat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReportResults",
+			Handler:       _Greedy_ReportResults_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchKill",
+			Handler:       _Greedy_WatchKill_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "greedy.proto",
+}
@@ -0,0 +1,249 @@
+//go:generate protoc --go_out=plugins=grpc:. greedy.proto
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/routers"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+
+	"github.com/MolenZhang/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Server worker使用的gRPC服务 领取及上报与HTTP接口共用routers.API中的业务逻辑
+type Server struct {
+	api  *routers.API
+	srv  *grpc.Server
+	done chan struct{} // 退出时结束订阅流
+	once sync.Once
+}
+
+var _ GreedyServer = (*Server)(nil)
+
+// NewServer .
+func NewServer(api *routers.API) *Server {
+	s := &Server{
+		api:  api,
+		srv:  grpc.NewServer(),
+		done: make(chan struct{}),
+	}
+	RegisterGreedyServer(s.srv, s)
+	return s
+}
+
+// Serve 在lis上提供服务 Shutdown后返回nil
+func (s *Server) Serve(lis net.Listener) error {
+	if err := s.srv.Serve(lis); err != grpc.ErrServerStopped {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 结束订阅流并等待处理中的请求 超时后强制关闭
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.once.Do(func() { close(s.done) })
+	stopped := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.srv.Stop()
+		return ctx.Err()
+	}
+}
+
+// FetchPhones 领取号码 无可执行任务时返回NotFound
+func (s *Server) FetchPhones(ctx context.Context, in *FetchRequest) (*FetchReply, error) {
+	resp, err := s.api.FetchPhones(ctx, routers.FetchRequest{
+		EnvType:    in.EnvType,
+		Holder:     holder(ctx, in.WorkerId),
+		Image:      in.Image,
+		AppVersion: in.AppVersion,
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &FetchReply{
+		JobId:          resp.JobID,
+		Batch:          resp.Batch,
+		Phones:         resp.Phones,
+		PolicyNum:      int32(resp.Policy.Num),
+		PolicyInterval: int32(resp.Policy.Interval),
+	}, nil
+}
+
+// ReportResults 流式上报 complete为false的消息追加上报 complete为true的消息结束本次执行
+// 结束后再发送的消息返回FailedPrecondition
+func (s *Server) ReportResults(stream Greedy_ReportResultsServer) error {
+	summary := &ReportSummary{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(summary)
+		}
+		if err != nil {
+			return err
+		}
+		if summary.Completed {
+			return status.Error(codes.FailedPrecondition, "report is already completed")
+		}
+		body := routers.ReportBody{
+			JobID:     chunk.JobId,
+			JobResult: int(chunk.JobResult),
+			ErrorMsg:  chunk.ErrorMsg,
+			JobDetail: make([]routers.JobDetail, 0, len(chunk.Details)),
+		}
+		for _, d := range chunk.Details {
+			body.JobDetail = append(body.JobDetail, routers.JobDetail{
+				Phone:  d.Phone,
+				Result: d.CrawlResult,
+				Type:   int(d.CrawlType),
+				Source: int(d.CrawlSource),
+				Batch:  d.CrawlBatch,
+			})
+		}
+		device := holder(stream.Context(), chunk.WorkerId).Device()
+		n, err := s.api.SubmitReport(device, body, chunk.Complete)
+		if err != nil {
+			return grpcError(err)
+		}
+		summary.Saved += int32(n)
+		summary.Completed = chunk.Complete
+	}
+}
+
+// Heartbeat 返回任务是否仍由该设备执行 为false时worker应停止执行
+// 与HTTP接口共用routers.API.Heartbeat
+func (s *Server) Heartbeat(ctx context.Context, in *HeartbeatRequest) (*HeartbeatReply, error) {
+	hb, err := s.api.Heartbeat(holder(ctx, in.WorkerId).Device(), in.JobId)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &HeartbeatReply{
+		Assigned:   hb.Assigned,
+		State:      hb.State,
+		ServerTime: hb.ServerTime,
+	}, nil
+}
+
+// WatchKill 订阅该设备执行中任务的强杀及删除
+// 订阅中断时返回Unavailable worker需重新订阅
+func (s *Server) WatchKill(in *WatchKillRequest, stream Greedy_WatchKillServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	w := &killWatch{
+		device:  holder(ctx, in.WorkerId).Device(),
+		jobID:   in.JobId,
+		running: map[string]string{},
+	}
+	// 先订阅再读取当前的任务 避免遗漏两者之间的变更
+	events := s.api.Stores.Events.JobWatch(ctx, 0)
+	jobs, err := s.api.Stores.Jobs.JobLists()
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	for _, job := range jobs {
+		w.track(job)
+	}
+	for {
+		select {
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case e, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return status.Error(codes.Canceled, ctx.Err().Error())
+				}
+				return status.Error(codes.Unavailable, "job watch closed")
+			}
+			kill := w.handle(e)
+			if kill == nil {
+				continue
+			}
+			if err := stream.Send(kill); err != nil {
+				log.Error("[WatchKill] Send Error", zap.Error(err), zap.String("device", w.device))
+				return err
+			}
+		}
+	}
+}
+
+// killWatch 跟踪设备执行中的任务
+type killWatch struct {
+	device  string
+	jobID   string            // 为空时跟踪该设备的所有任务
+	running map[string]string // 任务名 -> 任务id 强杀事件中只有任务名
+}
+
+func (w *killWatch) track(job etcd.JobEtcd) {
+	if (len(w.jobID) == 0 || job.ID == w.jobID) && core.AssignedTo(job, w.device) {
+		w.running[job.Name] = job.ID
+		return
+	}
+	delete(w.running, job.Name)
+}
+
+func (w *killWatch) handle(e storage.JobEvent) *KillEvent {
+	switch e.Type {
+	case storage.EventKilled, storage.EventDeleted:
+		id, ok := w.running[e.Job.Name]
+		if !ok {
+			return nil
+		}
+		delete(w.running, e.Job.Name)
+		return &KillEvent{JobId: id, JobName: e.Job.Name, Reason: e.Type}
+	default:
+		w.track(e.Job)
+		return nil
+	}
+}
+
+// holder 请求的设备 与HTTP接口一致为worker_id@ip
+func holder(ctx context.Context, workerID string) storage.LockHolder {
+	h := storage.LockHolder{WorkerID: workerID}
+	if p, ok := peer.FromContext(ctx); ok {
+		h.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(h.IP); err == nil {
+			h.IP = host
+		}
+	}
+	return h
+}
+
+// grpcError 接口错误按HTTP状态码转换为gRPC状态码 内部错误不返回原因
+// 并发修改返回Aborted 客户端可以重试
+func grpcError(err error) error {
+	e := common.AsError(err)
+	if e.Code == common.CodeJobConflict {
+		return status.Error(codes.Aborted, e.Error())
+	}
+	switch e.Status() {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return status.Error(codes.InvalidArgument, e.Error())
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, e.Error())
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, e.Error())
+	case http.StatusConflict:
+		return status.Error(codes.FailedPrecondition, e.Error())
+	}
+	log.Error("[RPC] Internal Error", zap.Error(e))
+	return status.Error(codes.Internal, common.NewError(e.Code, "").Error())
+}
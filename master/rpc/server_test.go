@@ -0,0 +1,171 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/routers"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/memory"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestClient 使用内存存储启动gRPC服务 返回客户端及创建任务的方法
+func newTestClient(t *testing.T) (GreedyClient, *routers.API, func(name string, phones ...string) etcd.JobEtcd, func()) {
+	gin.SetMode(gin.TestMode)
+	stores := memory.NewStores()
+	api := &routers.API{Stores: stores}
+	handler := routers.NewHandler(api)
+	createJob := func(name string, phones ...string) etcd.JobEtcd {
+		for _, p := range phones {
+			stores.Sources.(*memory.SourceStore).Add(mongo.CrawlSource{Phone: p, Batch: name})
+		}
+		b, _ := json.Marshal(routers.WebJob{Name: name, Batch: name, Count: len(phones), CrawlType: common.CrawlTypeCloudPhone})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/greedy/job/", bytes.NewReader(b)))
+		job := etcd.JobEtcd{}
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatalf("create job: %v, body %v", err, w.Body.String())
+		}
+		routers.WaitAsync(context.Background())
+		return job
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(api)
+	go srv.Serve(lis)
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGreedyClient(cc), api, createJob, func() {
+		cc.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
+
+func TestFetchAndReport(t *testing.T) {
+	client, api, createJob, stop := newTestClient(t)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job := createJob("20200218", "15330091234", "15757121234")
+	if _, err := client.FetchPhones(ctx, &FetchRequest{WorkerId: "w1", EnvType: "9"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("fetch with invalid env: %v", err)
+	}
+	phones, err := client.FetchPhones(ctx, &FetchRequest{WorkerId: "w1", EnvType: common.CrawlTypeCloudPhone})
+	if err != nil || phones.JobId != job.ID || len(phones.Phones) != 2 {
+		t.Fatalf("fetch: %+v, %v", phones, err)
+	}
+	if _, err := client.FetchPhones(ctx, &FetchRequest{WorkerId: "w1", EnvType: common.CrawlTypeCloudPhone}); status.Code(err) != codes.NotFound {
+		t.Fatalf("fetch without job: %v", err)
+	}
+	hb, err := client.Heartbeat(ctx, &HeartbeatRequest{WorkerId: "w1", JobId: job.ID})
+	if err != nil || !hb.Assigned || hb.State != "running" {
+		t.Fatalf("heartbeat: %+v, %v", hb, err)
+	}
+
+	// 其他设备上报
+	stream, err := client.ReportResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&ReportChunk{WorkerId: "w2", JobId: job.ID, Details: []*Detail{{Phone: "15330091234"}}})
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("report from other worker: %v", err)
+	}
+
+	// 与HTTP接口相同的校验
+	stream, err = client.ReportResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&ReportChunk{WorkerId: "w1", JobId: job.ID, Details: []*Detail{{CrawlResult: "{}"}}})
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("report without phone: %v", err)
+	}
+
+	stream, err = client.ReportResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detail := &Detail{Phone: "15330091234", CrawlResult: `{"tag":"快递"}`, CrawlType: 2, CrawlSource: 1, CrawlBatch: "20200218"}
+	stream.Send(&ReportChunk{WorkerId: "w1", JobId: job.ID, Details: []*Detail{detail}})
+	// 重复的号码不再入库
+	stream.Send(&ReportChunk{WorkerId: "w1", JobId: job.ID, Details: []*Detail{detail}})
+	stream.Send(&ReportChunk{WorkerId: "w1", JobId: job.ID, Complete: true, JobResult: 1, Details: []*Detail{
+		{Phone: "15757121234", CrawlResult: "{}", CrawlType: 2, CrawlSource: 1, CrawlBatch: "20200218"},
+	}})
+	summary, err := stream.CloseAndRecv()
+	if err != nil || summary.Saved != 2 || !summary.Completed {
+		t.Fatalf("report: %+v, %v", summary, err)
+	}
+	routers.WaitAsync(ctx)
+	got, _ := api.Stores.Jobs.JobGetWithID(job.ID)
+	if got.Status != int(common.Done) || got.Progress.Reported != 2 || got.Progress.WithResult != 1 {
+		t.Fatalf("job after report: %+v", got)
+	}
+	docs, _ := api.Stores.Results.CrawlResultGet(map[string]string{"job_id": job.ID})
+	if len(docs) != 2 {
+		t.Fatalf("results: %v", len(docs))
+	}
+	if hb, err := client.Heartbeat(ctx, &HeartbeatRequest{WorkerId: "w1", JobId: job.ID}); err != nil || hb.Assigned {
+		t.Fatalf("heartbeat after report: %+v, %v", hb, err)
+	}
+}
+
+func TestWatchKill(t *testing.T) {
+	client, api, createJob, stop := newTestClient(t)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job := createJob("20200218", "15330091234")
+	if _, err := client.FetchPhones(ctx, &FetchRequest{WorkerId: "w1", EnvType: common.CrawlTypeCloudPhone}); err != nil {
+		t.Fatal(err)
+	}
+	watch, err := client.WatchKill(ctx, &WatchKillRequest{WorkerId: "w1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 订阅建立前的强杀会丢失 持续强杀直到收到事件
+	go func() {
+		for ctx.Err() == nil {
+			api.Stores.Jobs.JobKill(job.Name)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	e, err := watch.Recv()
+	if err != nil || e.JobId != job.ID || e.Reason != "killed" {
+		t.Fatalf("kill event: %+v, %v", e, err)
+	}
+
+	// 其他设备不会收到该任务的强杀
+	otherCtx, otherCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer otherCancel()
+	other, err := client.WatchKill(otherCtx, &WatchKillRequest{WorkerId: "w2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := other.Recv(); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("kill event for other worker: %+v, %v", e, err)
+	}
+}
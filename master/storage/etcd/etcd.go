@@ -6,12 +6,32 @@ type JobEtcd struct {
 	Name        string        `json:"name"`   // 任务名称
 	Source      JobDataSource `json:"source"` // 数据来源
 	CrawlType   int           // 执行方式 1-模拟器;2-云手机;3-api
+	Priority    int           `json:"priority"`    // 优先级 越大越优先
 	Description string        `json:"desc"`        // 备注
-	Status      int           `json:"status"`      // 状态 1-未执行 2-执行中 3-执行完成
+	Status      int           `json:"status"`      // 状态 1-未执行 2-执行中 3-执行完成 4-重试次数用尽
 	Result      int           `json:"result"`      // 执行结果 1-成功 2-失败
 	CreatedAt   string        `json:"create_time"` // 任务创建的时间
 	StartedAt   string        `json:"start_time"`  // 开始执行的时间
 	StopedAt    string        `json:"stop_time"`   // 结束执行的时间
+
+	// 失败重试
+	MaxAttempts    int           `json:"max_attempts,omitempty"`     // 最大执行次数 0表示使用默认值
+	Backoff        BackoffPolicy `json:"backoff"`                    // 失败后的退避策略
+	Attempts       int           `json:"attempts"`                   // 已执行的次数
+	NextEligibleAt string        `json:"next_eligible_at,omitempty"` // 失败后可再次下发的时间
+	History        []Attempt     `json:"history,omitempty"`          // 每次执行的记录
+
+	Progress JobProgress `json:"progress"` // 执行进度
+
+	MinAppVersion string `json:"min_app_version,omitempty"` // 模拟器要求的最低app版本 为空时不限制
+
+	// 周期任务 Schedule不为空的任务只作为定义 每次触发时创建一次新的执行
+	Schedule  string `json:"schedule,omitempty"`    // cron表达式
+	Paused    bool   `json:"paused,omitempty"`      // 是否暂停调度
+	LastRunAt string `json:"last_run_at,omitempty"` // 上次触发的时间
+	Runs      int    `json:"runs,omitempty"`        // 已触发的次数
+	ParentID  string `json:"parent_id,omitempty"`   // 周期任务的执行 对应的任务定义id
+	RunID     int    `json:"run_id,omitempty"`      // 周期任务的第几次执行
 }
 
 // JobDataSource 任务数据来源
@@ -19,3 +39,40 @@ type JobDataSource struct {
 	Batch string `json:"batch"` // 批次
 	Count int    `json:"count"` // 条数
 }
+
+// BackoffPolicy 失败后的退避策略 第n次失败后等待Initial*Multiplier^(n-1) 不超过Max
+type BackoffPolicy struct {
+	Initial    int     `json:"initial"`    // 首次退避 单位秒
+	Max        int     `json:"max"`        // 最大退避 单位秒
+	Multiplier float64 `json:"multiplier"` // 倍数
+}
+
+// Attempt 单次执行记录
+type Attempt struct {
+	No         int    `json:"no"`          // 第几次执行
+	Device     string `json:"device"`      // 执行的设备 worker_id@ip
+	StartedAt  string `json:"start_time"`  // 下发时间
+	FinishedAt string `json:"finish_time"` // 上报时间
+	Result     int    `json:"result"`      // 执行结果 1-成功 2-失败
+	Error      string `json:"error"`       // 失败原因
+	Dispatched int    `json:"dispatched"`  // 下发的号码数
+	Reported   int    `json:"reported"`    // 上报的号码数 含追加上报 号码的状态记录在mysql中
+	Deadline   string `json:"deadline"`    // 执行期限 下发、追加上报及心跳时延长 超过后视为设备异常退出
+}
+
+// JobProgress 任务进度计数 下发及上报时更新
+type JobProgress struct {
+	Total      int              `json:"total"`       // 号码总数
+	Dispatched int              `json:"dispatched"`  // 累计下发的号码数 含重试
+	Reported   int              `json:"reported"`    // 已上报的号码数
+	WithResult int              `json:"with_result"` // 有结果的号码数
+	Failed     int              `json:"failed"`      // 下发后未上报的号码数
+	Samples    []ProgressSample `json:"samples,omitempty"`
+}
+
+// ProgressSample 最近的上报记录 用于计算上报速率
+type ProgressSample struct {
+	At      string `json:"at"`      // 上报时间
+	Count   int    `json:"count"`   // 上报的号码数
+	Seconds int    `json:"seconds"` // 从下发到上报的耗时 单位秒
+}
@@ -0,0 +1,110 @@
+package etcdtest
+
+/*
+   测试用的嵌入式etcd
+   数据目录为临时目录 端口随机 Stop后目录会被删除
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+	"github.com/coreos/pkg/capnslog"
+)
+
+// startTimeout 等待etcd就绪的超时时间
+const startTimeout = 20 * time.Second
+
+// Server 嵌入式etcd
+type Server struct {
+	Endpoints []string
+	etcd      *embed.Etcd
+	dir       string
+}
+
+// Start 在临时目录中启动单节点etcd
+func Start() (s *Server, err error) {
+	// etcd默认日志过多 只保留严重错误
+	capnslog.SetGlobalLogLevel(capnslog.CRITICAL)
+
+	dir, err := ioutil.TempDir("", "greedy-etcd")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	clientURL, err := localURL()
+	if err != nil {
+		return
+	}
+	peerURL, err := localURL()
+	if err != nil {
+		return
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Name = "greedy-test"
+	cfg.Dir = dir
+	cfg.LCUrls = []url.URL{*clientURL}
+	cfg.ACUrls = []url.URL{*clientURL}
+	cfg.LPUrls = []url.URL{*peerURL}
+	cfg.APUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case err = <-e.Err():
+		e.Close()
+		return
+	case <-time.After(startTimeout):
+		e.Server.Stop()
+		e.Close()
+		err = fmt.Errorf("etcd not ready after %v", startTimeout)
+		return
+	}
+
+	s = &Server{
+		Endpoints: []string{clientURL.Host},
+		etcd:      e,
+		dir:       dir,
+	}
+	return
+}
+
+// Client 创建连接到当前etcd的客户端 由调用方关闭
+func (s *Server) Client() (*clientv3.Client, error) {
+	return clientv3.New(clientv3.Config{
+		Endpoints:   s.Endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+}
+
+// Stop 停止etcd并删除数据目录
+func (s *Server) Stop() {
+	s.etcd.Close()
+	os.RemoveAll(s.dir)
+}
+
+// localURL 随机选取一个本地可用端口
+func localURL() (*url.URL, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	return url.Parse("http://" + l.Addr().String())
+}
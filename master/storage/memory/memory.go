@@ -0,0 +1,611 @@
+package memory
+
+/*
+   存储接口的内存实现
+   仅用于测试 数据不落盘 行为与etcd/mongo/mysql实现保持一致
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
+	mysql "code.safe.molen.com/molen/haoma/greedy/master/storage/mysql"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	_ storage.JobStore     = (*JobStore)(nil)
+	_ storage.LockStore    = (*JobStore)(nil)
+	_ storage.JobWatcher   = (*JobStore)(nil)
+	_ storage.WebhookStore = (*WebhookStore)(nil)
+	_ storage.SourceStore  = (*SourceStore)(nil)
+	_ storage.ResultStore  = (*ResultStore)(nil)
+	_ storage.JobDataStore = (*JobDataStore)(nil)
+)
+
+// NewStores 创建一组内存存储
+func NewStores() storage.Stores {
+	jobs := NewJobStore()
+	return storage.Stores{
+		Jobs:     jobs,
+		Locks:    jobs,
+		Events:   jobs,
+		Webhooks: NewWebhookStore(),
+		Sources:  NewSourceStore(),
+		Results:  NewResultStore(),
+		JobData:  NewJobDataStore(),
+	}
+}
+
+// JobStore 任务存储
+type JobStore struct {
+	mu    sync.Mutex
+	jobs  map[string]etcd.JobEtcd
+	locks map[string]storage.LockInfo
+	kills map[string]time.Time
+	rev   int64         // 模拟etcd的版本号 作为fencing token
+	freed chan struct{} // 有锁释放时关闭并重建 唤醒等待者
+	subs  map[chan storage.JobEvent]struct{}
+}
+
+// NewJobStore .
+func NewJobStore() *JobStore {
+	return &JobStore{
+		jobs:  map[string]etcd.JobEtcd{},
+		locks: map[string]storage.LockInfo{},
+		kills: map[string]time.Time{},
+		freed: make(chan struct{}),
+		subs:  map[chan storage.JobEvent]struct{}{},
+	}
+}
+
+// JobWatch 忽略rev 只推送订阅之后的变更
+func (s *JobStore) JobWatch(ctx context.Context, rev int64) <-chan storage.JobEvent {
+	ch := make(chan storage.JobEvent, 64)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.subs, ch)
+		close(ch)
+		s.mu.Unlock()
+	}()
+	return ch
+}
+
+// publish 推送事件 调用方需持有s.mu 订阅者处理不及时时丢弃
+func (s *JobStore) publish(typ string, job etcd.JobEtcd) {
+	s.rev++
+	e := storage.JobEvent{
+		Type:     typ,
+		Job:      job,
+		Revision: s.rev,
+		At:       time.Now().Format("2006-01-02 15:04:05"),
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// save 保存任务并推送事件 调用方需持有s.mu
+func (s *JobStore) save(job etcd.JobEtcd) (oldJob etcd.JobEtcd) {
+	oldJob, ok := s.jobs[job.Name]
+	s.jobs[job.Name] = job
+	var prev *etcd.JobEtcd
+	if ok {
+		prev = &oldJob
+	}
+	s.publish(storage.ClassifyJobEvent(prev, job), job)
+	return
+}
+
+// JobSave .
+func (s *JobStore) JobSave(job etcd.JobEtcd) (oldJob etcd.JobEtcd, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldJob = s.save(job)
+	return
+}
+
+// JobSaveFenced .
+func (s *JobStore) JobSaveFenced(job etcd.JobEtcd, token int64) (oldJob etcd.JobEtcd, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks[job.Name].Token != token {
+		err = storage.ErrLockFenced
+		return
+	}
+	oldJob = s.save(job)
+	return
+}
+
+// JobCreate .
+func (s *JobStore) JobCreate(job etcd.JobEtcd) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.Name]; ok {
+		return storage.ErrJobExists
+	}
+	s.save(job)
+	return nil
+}
+
+// JobUpdate 持有s.mu期间修改 不会并发冲突
+// 修改的是任务的深拷贝 fn返回错误时已保存的任务不受影响
+func (s *JobStore) JobUpdate(id string, fn func(job *etcd.JobEtcd) error) (job etcd.JobEtcd, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.jobs {
+		if v.ID != id {
+			continue
+		}
+		value, _ := json.Marshal(v)
+		json.Unmarshal(value, &job)
+		if err = fn(&job); err != nil {
+			return
+		}
+		s.save(job)
+		return
+	}
+	err = storage.ErrJobNotFound
+	return
+}
+
+// JobDelete .
+func (s *JobStore) JobDelete(name string) (oldJob etcd.JobEtcd, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldJob, ok := s.jobs[name]
+	if ok {
+		delete(s.jobs, name)
+		s.publish(storage.EventDeleted, oldJob)
+	}
+	return
+}
+
+// JobLists 按名称排序 与etcd按key前缀读取的顺序一致
+func (s *JobStore) JobLists() (jobs []etcd.JobEtcd, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	jobs = []etcd.JobEtcd{}
+	for _, name := range names {
+		jobs = append(jobs, s.jobs[name])
+	}
+	return
+}
+
+// JobGetWithID .
+func (s *JobStore) JobGetWithID(id string) (job etcd.JobEtcd, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.jobs {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	err = storage.ErrJobNotFound
+	return
+}
+
+// JobKill .
+func (s *JobStore) JobKill(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kills[name] = time.Now()
+	job, ok := s.jobs[name]
+	if !ok {
+		job = etcd.JobEtcd{Name: name}
+	}
+	s.publish(storage.EventKilled, job)
+	return nil
+}
+
+// JobLockMade .
+func (s *JobStore) JobLockMade(name string, holder storage.LockHolder) storage.Locker {
+	return &jobLock{store: s, name: name, holder: holder, done: make(chan struct{})}
+}
+
+// LockLists 按任务名排序
+func (s *JobStore) LockLists(ctx context.Context) ([]storage.LockInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	locks := []storage.LockInfo{}
+	for _, l := range s.locks {
+		locks = append(locks, l)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Job < locks[j].Job })
+	return locks, nil
+}
+
+// LockRevoke .
+func (s *JobStore) LockRevoke(ctx context.Context, job string) (storage.LockInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := s.locks[job]
+	if !ok {
+		return info, storage.ErrLockNotFound
+	}
+	s.release(job)
+	return info, nil
+}
+
+// release 释放锁并唤醒等待者 调用方需持有s.mu
+func (s *JobStore) release(job string) {
+	delete(s.locks, job)
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
+
+type jobLock struct {
+	store  *JobStore
+	name   string
+	holder storage.LockHolder
+	token  int64 // 0表示未持有
+	done   chan struct{}
+}
+
+// TryLock .
+func (l *jobLock) TryLock(ctx context.Context) error {
+	_, err := l.tryLock()
+	return err
+}
+
+func (l *jobLock) tryLock() (freed chan struct{}, err error) {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	if _, ok := l.store.locks[l.name]; ok {
+		return l.store.freed, storage.ErrLockOccupied
+	}
+	l.store.rev++
+	holder := l.holder
+	holder.AcquiredAt = time.Now().Format("2006-01-02 15:04:05")
+	l.store.locks[l.name] = storage.LockInfo{Job: l.name, Holder: holder, Token: l.store.rev}
+	l.token = l.store.rev
+	l.done = make(chan struct{})
+	return nil, nil
+}
+
+// Lock .
+func (l *jobLock) Lock(ctx context.Context) error {
+	for {
+		freed, err := l.tryLock()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// UnLock .
+func (l *jobLock) UnLock() {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	if info, ok := l.store.locks[l.name]; ok && l.token != 0 && info.Token == l.token {
+		l.store.release(l.name)
+	}
+	if l.token != 0 {
+		l.token = 0
+		close(l.done)
+	}
+}
+
+// Done .
+func (l *jobLock) Done() <-chan struct{} {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	return l.done
+}
+
+// Token .
+func (l *jobLock) Token() int64 {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	return l.token
+}
+
+// SourceStore 源数据
+type SourceStore struct {
+	mu   sync.Mutex
+	docs []mongo.CrawlSource
+}
+
+// NewSourceStore .
+func NewSourceStore() *SourceStore {
+	return &SourceStore{}
+}
+
+// Add 写入源数据
+func (s *SourceStore) Add(docs ...mongo.CrawlSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, doc := range docs {
+		if len(doc.ID) == 0 {
+			doc.ID = bson.NewObjectId()
+		}
+		s.docs = append(s.docs, doc)
+	}
+}
+
+// CrawlSourceGet .
+func (s *SourceStore) CrawlSourceGet(batch string, limit int) ([]mongo.CrawlSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs := []mongo.CrawlSource{}
+	for _, doc := range s.docs {
+		if limit > 0 && len(docs) >= limit {
+			break
+		}
+		if doc.Batch == batch {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// ResultStore 抓取结果
+type ResultStore struct {
+	mu   sync.Mutex
+	docs []mongo.CrawlResult
+}
+
+// NewResultStore .
+func NewResultStore() *ResultStore {
+	return &ResultStore{}
+}
+
+// CrawlResultInsert .
+func (s *ResultStore) CrawlResultInsert(docs []mongo.CrawlResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = append(s.docs, docs...)
+	return nil
+}
+
+// CrawlResultGet .
+func (s *ResultStore) CrawlResultGet(fs map[string]string) ([]mongo.CrawlResult, error) {
+	docs := []mongo.CrawlResult{}
+	err := s.CrawlResultIter(fs, func(doc mongo.CrawlResult) error {
+		docs = append(docs, doc)
+		return nil
+	})
+	return docs, err
+}
+
+// CrawlResultIter .
+func (s *ResultStore) CrawlResultIter(fs map[string]string, f func(mongo.CrawlResult) error) error {
+	batch, jobID := fs["batch"], fs["job_id"]
+	if len(batch) == 0 && len(jobID) == 0 {
+		return fmt.Errorf("Bad Params")
+	}
+	s.mu.Lock()
+	docs := append([]mongo.CrawlResult{}, s.docs...)
+	s.mu.Unlock()
+	for _, doc := range docs {
+		if len(batch) != 0 && doc.Batch != batch {
+			continue
+		}
+		if len(jobID) != 0 && doc.JobID != jobID {
+			continue
+		}
+		if err := f(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CrawlResultStats .
+func (s *ResultStore) CrawlResultStats(field string) ([]mongo.ResultStat, error) {
+	return s.aggregate(func(doc mongo.CrawlResult) (interface{}, bool) {
+		switch field {
+		case "crawl_type":
+			return doc.Type, true
+		case "crawl_source":
+			return doc.Source, true
+		case "job_id":
+			return doc.JobID, true
+		}
+		return nil, false
+	})
+}
+
+// CrawlResultHourly 与mongo一致 按UTC小时分组
+func (s *ResultStore) CrawlResultHourly(since time.Time) ([]mongo.ResultStat, error) {
+	return s.aggregate(func(doc mongo.CrawlResult) (interface{}, bool) {
+		if doc.CreatedAt.Before(since) {
+			return nil, false
+		}
+		return doc.CreatedAt.UTC().Format("2006-01-02 15:00"), true
+	})
+}
+
+// aggregate 按key分组统计 key返回false的数据不参与统计
+func (s *ResultStore) aggregate(key func(mongo.CrawlResult) (interface{}, bool)) ([]mongo.ResultStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := map[interface{}]*mongo.ResultStat{}
+	keys := []interface{}{}
+	for _, doc := range s.docs {
+		k, ok := key(doc)
+		if !ok {
+			continue
+		}
+		g, ok := groups[k]
+		if !ok {
+			g = &mongo.ResultStat{Key: k}
+			groups[k] = g
+			keys = append(keys, k)
+		}
+		g.Total++
+		if mongo.IsHit(doc.Result) {
+			g.Hit++
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	stats := []mongo.ResultStat{}
+	for _, k := range keys {
+		stats = append(stats, *groups[k])
+	}
+	return stats, nil
+}
+
+// JobDataStore 任务数据
+type JobDataStore struct {
+	mu     sync.Mutex
+	nextID int64
+	ds     []mysql.JobDatum
+}
+
+// NewJobDataStore .
+func NewJobDataStore() *JobDataStore {
+	return &JobDataStore{}
+}
+
+// BatchInsert 与mysql唯一索引(job_id, phone)保持一致 重复数据忽略
+func (s *JobDataStore) BatchInsert(ds []mysql.JobDatum) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range ds {
+		dup := false
+		for _, e := range s.ds {
+			if e.JobID == d.JobID && e.Phone == d.Phone {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+		s.nextID++
+		d.ID = s.nextID
+		if d.Status == 0 {
+			d.Status = mysql.DataPending
+		}
+		s.ds = append(s.ds, d)
+	}
+}
+
+// BatchFetch .
+func (s *JobDataStore) BatchFetch(jobID string, status ...int8) ([]mysql.JobDatum, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds := []mysql.JobDatum{}
+	for _, d := range s.ds {
+		if d.JobID.String == jobID && (len(status) == 0 || hasStatus(status, d.Status)) {
+			ds = append(ds, d)
+		}
+	}
+	return ds, nil
+}
+
+// FetchPhones .
+func (s *JobDataStore) FetchPhones(jobID string, phones []string) ([]mysql.JobDatum, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	want := map[string]bool{}
+	for _, p := range phones {
+		want[p] = true
+	}
+	ds := []mysql.JobDatum{}
+	for _, d := range s.ds {
+		if d.JobID.String == jobID && want[d.Phone.String] {
+			ds = append(ds, d)
+		}
+	}
+	return ds, nil
+}
+
+// UpdateStatus .
+func (s *JobDataStore) UpdateStatus(jobID string, phones []string, from []int8, to int8) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.update(jobID, phones, from, to))), nil
+}
+
+// ClaimStatus .
+func (s *JobDataStore) ClaimStatus(jobID string, phones []string, from []int8, to int8) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(phones) == 0 {
+		return []string{}, nil
+	}
+	return s.update(jobID, phones, from, to), nil
+}
+
+// update 修改状态 返回修改的号码
+func (s *JobDataStore) update(jobID string, phones []string, from []int8, to int8) []string {
+	want := map[string]bool{}
+	for _, p := range phones {
+		want[p] = true
+	}
+	updated := []string{}
+	for i, d := range s.ds {
+		if d.JobID.String != jobID {
+			continue
+		}
+		if len(phones) != 0 && !want[d.Phone.String] {
+			continue
+		}
+		if len(from) != 0 && !hasStatus(from, d.Status) {
+			continue
+		}
+		s.ds[i].Status = to
+		s.ds[i].UpdatedAt = time.Now()
+		updated = append(updated, d.Phone.String)
+	}
+	return updated
+}
+
+func hasStatus(status []int8, s int8) bool {
+	for _, v := range status {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CountByJob .
+func (s *JobDataStore) CountByJob() ([]mysql.JobCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int64{}
+	ids := []string{}
+	for _, d := range s.ds {
+		if _, ok := counts[d.JobID.String]; !ok {
+			ids = append(ids, d.JobID.String)
+		}
+		counts[d.JobID.String]++
+	}
+	cs := []mysql.JobCount{}
+	for _, id := range ids {
+		c := mysql.JobCount{Total: counts[id]}
+		c.JobID.SetValid(id)
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
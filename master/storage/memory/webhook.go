@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"sort"
+	"sync"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/storage"
+)
+
+// WebhookStore webhook及投递记录
+type WebhookStore struct {
+	mu         sync.Mutex
+	hooks      map[string]storage.Webhook
+	deliveries map[string]map[string]storage.Delivery
+	rev        int64
+}
+
+// NewWebhookStore .
+func NewWebhookStore() *WebhookStore {
+	return &WebhookStore{
+		hooks:      map[string]storage.Webhook{},
+		deliveries: map[string]map[string]storage.Delivery{},
+	}
+}
+
+// WebhookSave .
+func (s *WebhookStore) WebhookSave(h storage.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks[h.ID] = h
+	return nil
+}
+
+// WebhookDelete 与etcd一致 投递记录保留
+func (s *WebhookStore) WebhookDelete(id string) (storage.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hooks[id]
+	if !ok {
+		return h, storage.ErrWebhookNotFound
+	}
+	delete(s.hooks, id)
+	return h, nil
+}
+
+// WebhookLists 按ID排序 与etcd按key返回一致
+func (s *WebhookStore) WebhookLists() ([]storage.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := []storage.Webhook{}
+	for _, h := range s.hooks {
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks, nil
+}
+
+// DeliverySave .
+func (s *WebhookStore) DeliverySave(d storage.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deliveries[d.WebhookID] == nil {
+		s.deliveries[d.WebhookID] = map[string]storage.Delivery{}
+	}
+	s.deliveries[d.WebhookID][d.ID] = d
+	return nil
+}
+
+// DeliveryLists .
+func (s *WebhookStore) DeliveryLists(webhookID string) ([]storage.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds := []storage.Delivery{}
+	for _, d := range s.deliveries[webhookID] {
+		ds = append(ds, d)
+	}
+	storage.SortDeliveries(ds)
+	return ds, nil
+}
+
+// NotifyRevision .
+func (s *WebhookStore) NotifyRevision() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rev, nil
+}
+
+// NotifyRevisionSave .
+func (s *WebhookStore) NotifyRevisionSave(rev int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rev = rev
+	return nil
+}
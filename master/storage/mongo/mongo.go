@@ -1,11 +1,14 @@
 package mongo
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
 
 	"code.safe.molen.com/molen/haoma/greedy/master/config"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
 	"github.com/MolenZhang/log"
 	"go.uber.org/zap"
 	"gopkg.in/mgo.v2"
@@ -30,31 +33,64 @@ type Client struct {
 // InitMongo init mongodb
 func InitMongo(cfg config.MongoConfig) (err error) {
 	once.Do(func() {
+		timeout := cfg.DialTimeout
+		if timeout == 0 {
+			timeout = time.Second * 60
+		}
 		mgoInfo := &mgo.DialInfo{
 			Addrs:  cfg.Addrs,
 			Direct: false,
 
-			Timeout:   time.Second * 60,
+			Timeout:   timeout,
 			Database:  database,
 			Username:  cfg.User,
 			Password:  cfg.Pwd,
 			PoolLimit: 1024,
 		}
-		s, err := mgo.DialWithInfo(mgoInfo)
-		if err != nil {
+		s, dialErr := mgo.DialWithInfo(mgoInfo)
+		if dialErr != nil {
+			err = fmt.Errorf("dial mongo %v: %v", cfg.Addrs, dialErr)
 			return
 		}
 		s.SetMode(mgo.Monotonic, true) // 分散一些读操作到其他服务器 但是未必读到最新的数据
 		session = s
 	})
+	if err == nil && session == nil {
+		err = fmt.Errorf("mongo is not initialized")
+	}
 	return
 }
 
+// Ping 检查mongo是否可用 超时以ctx为准
+func Ping(ctx context.Context) error {
+	if session == nil {
+		return fmt.Errorf("mongo is not initialized")
+	}
+	s := session.Clone()
+	done := make(chan error, 1)
+	go func() {
+		defer s.Close()
+		done <- s.Ping()
+	}()
+	select {
+	case err := <-done:
+		metrics.StorageError(metrics.BackendMongo, "ping", err)
+		return err
+	case <-ctx.Done():
+		metrics.StorageError(metrics.BackendMongo, "ping", ctx.Err())
+		return ctx.Err()
+	}
+}
+
 // PubCollection 获取collection
 func PubCollection(collection string, f func(*mgo.Collection) error) error {
 	c := NewClient()
 	defer c.Conn.Close()
-	return f(c.Conn.DB(database).C(collection))
+	err := f(c.Conn.DB(database).C(collection))
+	if err != mgo.ErrNotFound {
+		metrics.StorageError(metrics.BackendMongo, collection, err)
+	}
+	return err
 }
 
 // NewClient .
@@ -127,14 +163,7 @@ func CrawlResultGet(fs map[string]string) (docs []CrawlResult, err error) {
 		return
 	}
 
-	selector := bson.M{}
-
-	if v, ok := fs["batch"]; ok {
-		selector["batch"] = v
-	}
-	if v, ok := fs["job_id"]; ok {
-		selector["job_id"] = v
-	}
+	selector := resultSelector(fs)
 	if len(selector) == 0 {
 		err = fmt.Errorf("Bad Params")
 		return
@@ -149,6 +178,41 @@ func CrawlResultGet(fs map[string]string) (docs []CrawlResult, err error) {
 	return
 }
 
+// CrawlResultIter 游标方式逐条遍历结果 不会一次性加载全部数据
+// f 返回错误时终止遍历
+func CrawlResultIter(fs map[string]string, f func(CrawlResult) error) error {
+	selector := resultSelector(fs)
+	if len(selector) == 0 {
+		return fmt.Errorf("Bad Params")
+	}
+
+	iterate := func(c *mgo.Collection) error {
+		iter := c.Find(selector).Sort("_id").Batch(500).Iter()
+		doc := CrawlResult{}
+		for iter.Next(&doc) {
+			if err := f(doc); err != nil {
+				iter.Close()
+				return err
+			}
+			doc = CrawlResult{}
+		}
+		return iter.Close()
+	}
+	return PubCollection(CollectCrawlResult, iterate)
+}
+
+// resultSelector 结果表筛选条件
+func resultSelector(fs map[string]string) bson.M {
+	selector := bson.M{}
+	if v, ok := fs["batch"]; ok && len(v) != 0 {
+		selector["crawl_batch"] = v
+	}
+	if v, ok := fs["job_id"]; ok && len(v) != 0 {
+		selector["job_id"] = v
+	}
+	return selector
+}
+
 // GetCount 获取数据总数
 func GetCount(collection string, fs map[string]string) (count int, err error) {
 	selector := bson.M{}
@@ -172,3 +236,94 @@ func GetCount(collection string, fs map[string]string) (count int, err error) {
 	}
 	return
 }
+
+// ResultStat 结果聚合统计
+type ResultStat struct {
+	Key   interface{} `bson:"_id"`
+	Total int         `bson:"total"` // 上报条数
+	Hit   int         `bson:"hit"`   // 有结果的条数
+}
+
+// emptyResults 视为无结果的抓取结果
+var emptyResults = []string{"", "{}", "[]", "null"}
+
+// IsHit 抓取结果是否有效 与hitCond保持一致
+func IsHit(result string) bool {
+	for _, e := range emptyResults {
+		if result == e {
+			return false
+		}
+	}
+	return true
+}
+
+// ResultFields 抓取结果中的号码名称、标记及分类
+type ResultFields struct {
+	Name     string `json:"name"`
+	Tag      string `json:"tag"`
+	Category string `json:"category"`
+}
+
+// ParseResult 解析抓取结果 不是json对象或字段类型不符时对应字段为空
+func ParseResult(result string) (f ResultFields) {
+	json.Unmarshal([]byte(result), &f)
+	return
+}
+
+// hitCond 判断抓取结果是否有效
+var hitCond = bson.M{
+	"$cond": []interface{}{
+		bson.M{"$in": []interface{}{"$crawl_result", []interface{}{"", "{}", "[]", "null", nil}}},
+		0,
+		1,
+	},
+}
+
+// CrawlResultStats 按字段聚合结果 field可选 crawl_type/crawl_source/job_id
+func CrawlResultStats(field string) (stats []ResultStat, err error) {
+	pipeline := []bson.M{
+		{"$group": bson.M{
+			"_id":   "$" + field,
+			"total": bson.M{"$sum": 1},
+			"hit":   bson.M{"$sum": hitCond},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+	aggregate := func(c *mgo.Collection) error {
+		return c.Pipe(pipeline).AllowDiskUse().All(&stats)
+	}
+	if err = PubCollection(CollectCrawlResult, aggregate); err != nil {
+		log.Error("[CrawlResultStats] Aggregate Result Error", zap.Error(err), zap.String("field", field))
+	}
+	return
+}
+
+// CrawlResultHourly 按小时聚合since之后的上报量 Key为UTC小时 如 2020-02-18 08:00
+func CrawlResultHourly(since time.Time) (stats []ResultStat, err error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"create_time": bson.M{"$gte": since}}},
+		{"$group": bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format": "%Y-%m-%d %H:00",
+				"date":   "$create_time",
+			}},
+			"total": bson.M{"$sum": 1},
+			"hit":   bson.M{"$sum": hitCond},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+	aggregate := func(c *mgo.Collection) error {
+		return c.Pipe(pipeline).AllowDiskUse().All(&stats)
+	}
+	if err = PubCollection(CollectCrawlResult, aggregate); err != nil {
+		log.Error("[CrawlResultHourly] Aggregate Result Error", zap.Error(err))
+	}
+	return
+}
+
+// Close 关闭mongo连接
+func Close() {
+	if session != nil {
+		session.Close()
+	}
+}
@@ -0,0 +1,40 @@
+package mongo
+
+import "time"
+
+// Store 基于mongo的源数据及结果存储
+type Store struct{}
+
+// CrawlSourceGet .
+func (Store) CrawlSourceGet(batch string, limit int) ([]CrawlSource, error) {
+	return CrawlSourceGet(batch, limit)
+}
+
+// CrawlResultInsert .
+func (Store) CrawlResultInsert(docs []CrawlResult) error {
+	ds := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		ds = append(ds, doc)
+	}
+	return CrawlResultInsert(ds)
+}
+
+// CrawlResultGet .
+func (Store) CrawlResultGet(fs map[string]string) ([]CrawlResult, error) {
+	return CrawlResultGet(fs)
+}
+
+// CrawlResultIter .
+func (Store) CrawlResultIter(fs map[string]string, f func(CrawlResult) error) error {
+	return CrawlResultIter(fs, f)
+}
+
+// CrawlResultStats .
+func (Store) CrawlResultStats(field string) ([]ResultStat, error) {
+	return CrawlResultStats(field)
+}
+
+// CrawlResultHourly .
+func (Store) CrawlResultHourly(since time.Time) ([]ResultStat, error) {
+	return CrawlResultHourly(since)
+}
@@ -1,24 +1,61 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"code.safe.molen.com/molen/haoma/greedy/master/config"
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
+
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/volatiletech/sqlboiler/boil"
 )
 
+var db *sql.DB
+
 // InitMysql .
+// sql.Open不会建立连接 此处ping一次确保启动时mysql可用
 func InitMysql(cfg config.MysqlConfig) (err error) {
 	metadata := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local",
 		cfg.User, cfg.Pwd, cfg.Addr, cfg.Database)
-	db, err := sql.Open("mysql", metadata)
+	conn, err := sql.Open("mysql", metadata)
 	if err != nil {
 		return
 	}
-	db.SetMaxIdleConns(100)
-	db.SetMaxOpenConns(100)
+	conn.SetMaxIdleConns(100)
+	conn.SetMaxOpenConns(100)
+
+	timeout := cfg.DialTimeout
+	if timeout == 0 {
+		timeout = time.Second * 10
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err = conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return fmt.Errorf("ping mysql %s/%s: %v", cfg.Addr, cfg.Database, err)
+	}
+	db = conn
 	boil.SetDB(db)
 	return
 }
+
+// Ping 检查mysql是否可用
+func Ping(ctx context.Context) error {
+	if db == nil {
+		return fmt.Errorf("mysql is not initialized")
+	}
+	err := db.PingContext(ctx)
+	metrics.StorageError(metrics.BackendMysql, "ping", err)
+	return err
+}
+
+// Close 关闭mysql连接
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
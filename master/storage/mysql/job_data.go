@@ -30,6 +30,7 @@ type JobDatum struct {
 	JobID     null.String `boil:"job_id" json:"job_id,omitempty" toml:"job_id" yaml:"job_id,omitempty"`
 	CreatedAt time.Time   `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
 	UpdatedAt time.Time   `boil:"updated_at" json:"updated_at" toml:"updated_at" yaml:"updated_at"`
+	Status    int8        `boil:"status" json:"status" toml:"status" yaml:"status"`
 
 	R *jobDatumR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L jobDatumL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -42,6 +43,7 @@ var JobDatumColumns = struct {
 	JobID     string
 	CreatedAt string
 	UpdatedAt string
+	Status    string
 }{
 	ID:        "id",
 	Phone:     "phone",
@@ -49,6 +51,7 @@ var JobDatumColumns = struct {
 	JobID:     "job_id",
 	CreatedAt: "created_at",
 	UpdatedAt: "updated_at",
+	Status:    "status",
 }
 
 // Generated where
@@ -113,6 +116,15 @@ func (w whereHelpertime_Time) GTE(x time.Time) qm.QueryMod {
 	return qmhelper.Where(w.field, qmhelper.GTE, x)
 }
 
+type whereHelperint8 struct{ field string }
+
+func (w whereHelperint8) EQ(x int8) qm.QueryMod  { return qmhelper.Where(w.field, qmhelper.EQ, x) }
+func (w whereHelperint8) NEQ(x int8) qm.QueryMod { return qmhelper.Where(w.field, qmhelper.NEQ, x) }
+func (w whereHelperint8) LT(x int8) qm.QueryMod  { return qmhelper.Where(w.field, qmhelper.LT, x) }
+func (w whereHelperint8) LTE(x int8) qm.QueryMod { return qmhelper.Where(w.field, qmhelper.LTE, x) }
+func (w whereHelperint8) GT(x int8) qm.QueryMod  { return qmhelper.Where(w.field, qmhelper.GT, x) }
+func (w whereHelperint8) GTE(x int8) qm.QueryMod { return qmhelper.Where(w.field, qmhelper.GTE, x) }
+
 var JobDatumWhere = struct {
 	ID        whereHelperint64
 	Phone     whereHelpernull_String
@@ -120,6 +132,7 @@ var JobDatumWhere = struct {
 	JobID     whereHelpernull_String
 	CreatedAt whereHelpertime_Time
 	UpdatedAt whereHelpertime_Time
+	Status    whereHelperint8
 }{
 	ID:        whereHelperint64{field: "`job_data`.`id`"},
 	Phone:     whereHelpernull_String{field: "`job_data`.`phone`"},
@@ -127,6 +140,7 @@ var JobDatumWhere = struct {
 	JobID:     whereHelpernull_String{field: "`job_data`.`job_id`"},
 	CreatedAt: whereHelpertime_Time{field: "`job_data`.`created_at`"},
 	UpdatedAt: whereHelpertime_Time{field: "`job_data`.`updated_at`"},
+	Status:    whereHelperint8{field: "`job_data`.`status`"},
 }
 
 // JobDatumRels is where relationship names are stored.
@@ -146,9 +160,9 @@ func (*jobDatumR) NewStruct() *jobDatumR {
 type jobDatumL struct{}
 
 var (
-	jobDatumAllColumns            = []string{"id", "phone", "batch", "job_id", "created_at", "updated_at"}
+	jobDatumAllColumns            = []string{"id", "phone", "batch", "job_id", "created_at", "updated_at", "status"}
 	jobDatumColumnsWithoutDefault = []string{"phone", "batch", "job_id"}
-	jobDatumColumnsWithDefault    = []string{"id", "created_at", "updated_at"}
+	jobDatumColumnsWithDefault    = []string{"id", "created_at", "updated_at", "status"}
 	jobDatumPrimaryKeyColumns     = []string{"id"}
 )
 
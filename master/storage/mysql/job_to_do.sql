@@ -12,4 +12,8 @@ CREATE TABLE IF NOT EXISTS `job_data`(
     PRIMARY KEY (`id`)
 )DEFAULT CHARACTER SET utf8mb4;
 
-ALTER TABLE job_data ADD UNIQUE `job_phone` (`job_id`,`phone`);
\ No newline at end of file
+ALTER TABLE job_data ADD UNIQUE `job_phone` (`job_id`,`phone`);
+
+-- 号码的抓取状态 1-待下发 2-已下发 3-成功 4-失败 5-无结果
+ALTER TABLE job_data ADD COLUMN `status` TINYINT NOT NULL DEFAULT 1;
+ALTER TABLE job_data ADD INDEX `job_status` (`job_id`,`status`);
@@ -0,0 +1,34 @@
+package storage
+
+// Store 基于mysql的任务数据存储
+type Store struct{}
+
+// BatchInsert .
+func (Store) BatchInsert(ds []JobDatum) {
+	BatchInsert(ds)
+}
+
+// BatchFetch .
+func (Store) BatchFetch(jobID string, status ...int8) ([]JobDatum, error) {
+	return BatchFetch(jobID, status...)
+}
+
+// UpdateStatus .
+func (Store) UpdateStatus(jobID string, phones []string, from []int8, to int8) (int64, error) {
+	return UpdateStatus(jobID, phones, from, to)
+}
+
+// FetchPhones .
+func (Store) FetchPhones(jobID string, phones []string) ([]JobDatum, error) {
+	return FetchPhones(jobID, phones)
+}
+
+// ClaimStatus .
+func (Store) ClaimStatus(jobID string, phones []string, from []int8, to int8) ([]string, error) {
+	return ClaimStatus(jobID, phones, from, to)
+}
+
+// CountByJob .
+func (Store) CountByJob() ([]JobCount, error) {
+	return CountByJob()
+}
@@ -1,10 +1,19 @@
+// job_data.go及boil_*.go由sqlboiler根据job_to_do.sql迁移后的表结构生成 不要手动修改
+// 手写的查询放在本文件及store.go
+//go:generate sqlboiler mysql
+
 package storage
 
 import (
 	"context"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/metrics"
 
 	"github.com/MolenZhang/log"
+	"github.com/volatiletech/null"
 	"github.com/volatiletech/sqlboiler/boil"
+	"github.com/volatiletech/sqlboiler/queries"
 	. "github.com/volatiletech/sqlboiler/queries/qm"
 	"go.uber.org/zap"
 )
@@ -14,17 +23,134 @@ import (
 func BatchInsert(ds []JobDatum) {
 	for _, d := range ds {
 		if err := d.Insert(context.TODO(), boil.GetContextDB(), boil.Infer()); err != nil {
+			metrics.StorageError(metrics.BackendMysql, "insert", err)
 			log.Error("[BatchInsert] Insert Error", zap.Any("data", d), zap.Any("error", err))
 			continue
 		}
 	}
 }
 
+// 号码的抓取状态
+const (
+	DataPending    int8 = iota + 1 // 待下发
+	DataDispatched                 // 已下发
+	DataSucceeded                  // 成功
+	DataFailed                     // 失败
+	DataNoResult                   // 无结果
+)
+
+// DataUnfinished 再次下发时需要重新抓取的状态
+var DataUnfinished = []int8{DataPending, DataDispatched, DataFailed}
+
 // BatchFetch 获取数据
-// 获取 任务 相关的数据
-func BatchFetch(jobID string) (ds []JobDatum, err error) {
+// 获取 任务 相关的数据 status不为空时只返回对应状态的数据
+func BatchFetch(jobID string, status ...int8) (ds []JobDatum, err error) {
 	ds = []JobDatum{}
-	q := NewQuery(Where("job_id = ?", jobID))
-	q.Bind(context.TODO(), boil.GetContextDB(), &ds)
+	mods := []QueryMod{JobDatumWhere.JobID.EQ(null.StringFrom(jobID))}
+	if len(status) != 0 {
+		mods = append(mods, statusIn(status))
+	}
+	err = JobData(mods...).Bind(context.TODO(), boil.GetContextDB(), &ds)
+	metrics.StorageError(metrics.BackendMysql, "fetch", err)
+	return
+}
+
+// statusIn 按抓取状态过滤 生成的代码只为int64及string提供IN
+func statusIn(status []int8) QueryMod {
+	vs := make([]interface{}, 0, len(status))
+	for _, s := range status {
+		vs = append(vs, s)
+	}
+	return WhereIn("`job_data`.`status` IN ?", vs...)
+}
+
+// FetchPhones 获取任务下指定号码的数据
+func FetchPhones(jobID string, phones []string) (ds []JobDatum, err error) {
+	ds = []JobDatum{}
+	if len(phones) == 0 {
+		return
+	}
+	err = JobData(dataMods(jobID, phones, nil)...).Bind(context.TODO(), boil.GetContextDB(), &ds)
+	metrics.StorageError(metrics.BackendMysql, "fetch", err)
+	return
+}
+
+// UpdateStatus 更新号码的抓取状态 phones为空时更新任务下处于from状态的所有号码
+func UpdateStatus(jobID string, phones []string, from []int8, to int8) (n int64, err error) {
+	n, err = JobData(dataMods(jobID, phones, from)...).UpdateAll(context.TODO(), boil.GetContextDB(), M{
+		JobDatumColumns.Status:    to,
+		JobDatumColumns.UpdatedAt: time.Now(),
+	})
+	metrics.StorageError(metrics.BackendMysql, "update", err)
+	return
+}
+
+// ClaimStatus 将处于from状态的号码改为to 返回实际修改的号码
+// 加行锁后修改 并发修改同一号码时只有一方成功
+func ClaimStatus(jobID string, phones []string, from []int8, to int8) (claimed []string, err error) {
+	defer func() { metrics.StorageError(metrics.BackendMysql, "claim", err) }()
+	claimed = []string{}
+	if len(phones) == 0 {
+		return
+	}
+	ctx := context.TODO()
+	tx, err := boil.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	ds, err := JobData(append(dataMods(jobID, phones, from), For("UPDATE"))...).All(ctx, tx)
+	if err != nil {
+		return
+	}
+	if len(ds) != 0 {
+		ids := make([]interface{}, 0, len(ds))
+		for _, d := range ds {
+			ids = append(ids, d.ID)
+			claimed = append(claimed, d.Phone.String)
+		}
+		if _, err = JobData(WhereIn("`job_data`.`id` IN ?", ids...)).UpdateAll(ctx, tx, M{
+			JobDatumColumns.Status:    to,
+			JobDatumColumns.UpdatedAt: time.Now(),
+		}); err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+	return
+}
+
+// dataMods 按任务过滤 phones及from不为空时再按号码及状态过滤
+func dataMods(jobID string, phones []string, from []int8) []QueryMod {
+	mods := []QueryMod{JobDatumWhere.JobID.EQ(null.StringFrom(jobID))}
+	if len(phones) != 0 {
+		ps := make([]interface{}, 0, len(phones))
+		for _, p := range phones {
+			ps = append(ps, p)
+		}
+		mods = append(mods, WhereIn("`job_data`.`phone` IN ?", ps...))
+	}
+	if len(from) != 0 {
+		mods = append(mods, statusIn(from))
+	}
+	return mods
+}
+
+// JobCount 任务数据条数
+type JobCount struct {
+	JobID null.String `boil:"job_id"`
+	Total int64       `boil:"total"`
+}
+
+// CountByJob 按任务统计待抓取数据条数
+func CountByJob() (cs []JobCount, err error) {
+	cs = []JobCount{}
+	err = queries.Raw("SELECT `job_id`, COUNT(*) AS `total` FROM `job_data` GROUP BY `job_id`").
+		Bind(context.TODO(), boil.GetContextDB(), &cs)
+	metrics.StorageError(metrics.BackendMysql, "count", err)
 	return
 }
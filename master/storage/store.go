@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	etcd "code.safe.molen.com/molen/haoma/greedy/master/storage/etcd"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
+	mysql "code.safe.molen.com/molen/haoma/greedy/master/storage/mysql"
+)
+
+/*
+   存储接口
+   etcd实现为core.JobManager mongo实现为mongo.Store mysql实现为mysql.Store
+   memory包提供对应的内存实现 用于测试
+*/
+
+var (
+	// ErrLockOccupied 锁已被占用
+	ErrLockOccupied = errors.New("Lock Failed, Lock is Occupied")
+	// ErrLockFenced fencing token已失效 锁已被他人重新持有
+	ErrLockFenced = errors.New("Lock Token is Stale")
+	// ErrLockNotFound 锁不存在
+	ErrLockNotFound = errors.New("Lock Not Found")
+	// ErrJobNotFound 任务不存在
+	ErrJobNotFound = errors.New("No Job Matched")
+	// ErrJobConflict 任务被并发修改 多次重试后仍未写入
+	ErrJobConflict = errors.New("Job Was Modified Concurrently")
+	// ErrJobExists 同名任务已存在
+	ErrJobExists = errors.New("Job Already Exists")
+)
+
+// LockHolder 锁持有者 作为锁的value保存
+type LockHolder struct {
+	WorkerID   string `json:"worker_id"`
+	IP         string `json:"ip"`
+	AcquiredAt string `json:"acquired_at"`
+}
+
+// Device 设备标识 worker_id@ip
+func (h LockHolder) Device() string {
+	if len(h.WorkerID) == 0 {
+		return h.IP
+	}
+	return h.WorkerID + "@" + h.IP
+}
+
+// LockInfo 锁的当前状态
+type LockInfo struct {
+	Job     string     `json:"job"`
+	Holder  LockHolder `json:"holder"`
+	Token   int64      `json:"token"`
+	LeaseID int64      `json:"lease_id"`
+	TTL     int64      `json:"ttl"` // 租约剩余时长 单位秒
+}
+
+// Locker 任务锁
+type Locker interface {
+	TryLock(ctx context.Context) error // 锁被占用时返回ErrLockOccupied
+	Lock(ctx context.Context) error    // 阻塞直到加锁成功或ctx结束
+	UnLock()
+	Done() <-chan struct{} // 租约丢失或解锁后关闭
+	Token() int64          // fencing token 持锁写入时传给JobSaveFenced
+}
+
+// JobStore 任务存储
+type JobStore interface {
+	JobSave(job etcd.JobEtcd) (oldJob etcd.JobEtcd, err error)
+	JobSaveFenced(job etcd.JobEtcd, token int64) (oldJob etcd.JobEtcd, err error)
+	// JobCreate 仅在同名任务不存在时保存 否则返回ErrJobExists
+	JobCreate(job etcd.JobEtcd) error
+	// JobUpdate 读取最新的任务交给fn修改后写回 期间被并发修改时重新读取再修改
+	// fn返回错误时不写入 任务不存在时返回ErrJobNotFound 多次冲突后返回ErrJobConflict
+	JobUpdate(id string, fn func(job *etcd.JobEtcd) error) (job etcd.JobEtcd, err error)
+	JobDelete(name string) (oldJob etcd.JobEtcd, err error)
+	JobLists() (jobs []etcd.JobEtcd, err error)
+	JobGetWithID(id string) (job etcd.JobEtcd, err error)
+	JobKill(name string) error
+	JobLockMade(name string, holder LockHolder) Locker
+}
+
+// LockStore 任务锁管理
+type LockStore interface {
+	LockLists(ctx context.Context) ([]LockInfo, error)
+	LockRevoke(ctx context.Context, job string) (LockInfo, error) // 锁不存在时返回ErrLockNotFound
+}
+
+// 任务事件类型
+const (
+	EventCreated    = "created"    // 创建任务
+	EventUpdated    = "updated"    // 修改任务
+	EventDispatched = "dispatched" // 任务下发
+	EventReported   = "reported"   // 任务上报
+	EventDeleted    = "deleted"    // 删除任务
+	EventKilled     = "killed"     // 强杀任务
+)
+
+// JobEvent 任务变更事件
+type JobEvent struct {
+	Type     string       `json:"type"`
+	Job      etcd.JobEtcd `json:"job"`
+	Revision int64        `json:"revision"` // etcd版本号 断线重连时从该版本之后继续
+	At       string       `json:"at"`
+}
+
+// ClassifyJobEvent 根据修改前后的任务判断事件类型
+func ClassifyJobEvent(prev *etcd.JobEtcd, cur etcd.JobEtcd) string {
+	switch {
+	case prev == nil:
+		return EventCreated
+	case prev.Status != int(common.Running) && cur.Status == int(common.Running):
+		return EventDispatched
+	case prev.Status == int(common.Running) && cur.Status != int(common.Running):
+		return EventReported
+	}
+	return EventUpdated
+}
+
+// JobWatcher 任务变更订阅
+type JobWatcher interface {
+	// JobWatch 订阅任务变更 rev大于0时从该版本开始 ctx结束时关闭通道
+	JobWatch(ctx context.Context, rev int64) <-chan JobEvent
+}
+
+// SourceStore 待抓取的源数据
+type SourceStore interface {
+	CrawlSourceGet(batch string, limit int) ([]mongo.CrawlSource, error)
+}
+
+// ResultStore 抓取结果
+type ResultStore interface {
+	CrawlResultInsert(docs []mongo.CrawlResult) error
+	CrawlResultGet(fs map[string]string) ([]mongo.CrawlResult, error)
+	CrawlResultIter(fs map[string]string, f func(mongo.CrawlResult) error) error
+	CrawlResultStats(field string) ([]mongo.ResultStat, error)
+	CrawlResultHourly(since time.Time) ([]mongo.ResultStat, error)
+}
+
+// JobDataStore 任务相关的待抓取数据
+type JobDataStore interface {
+	BatchInsert(ds []mysql.JobDatum)
+	BatchFetch(jobID string, status ...int8) ([]mysql.JobDatum, error) // status为空时返回所有数据
+	FetchPhones(jobID string, phones []string) ([]mysql.JobDatum, error)
+	UpdateStatus(jobID string, phones []string, from []int8, to int8) (int64, error)
+	// ClaimStatus 与UpdateStatus相同 但返回实际修改的号码 并发修改同一号码时只有一方成功
+	ClaimStatus(jobID string, phones []string, from []int8, to int8) ([]string, error)
+	CountByJob() ([]mysql.JobCount, error)
+}
+
+// Stores 所有存储
+type Stores struct {
+	Jobs     JobStore
+	Locks    LockStore
+	Events   JobWatcher
+	Webhooks WebhookStore
+	Sources  SourceStore
+	Results  ResultStore
+	JobData  JobDataStore
+}
+
+var (
+	_ SourceStore  = mongo.Store{}
+	_ ResultStore  = mongo.Store{}
+	_ JobDataStore = mysql.Store{}
+)
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+)
+
+// 通知的事件
+// job.failed只在任务不再重试时通知(达到最大执行次数) 仍会重试的失败不通知
+const (
+	HookJobCreated   = "job.created"
+	HookJobStarted   = "job.started"
+	HookJobSucceeded = "job.succeeded"
+	HookJobFailed    = "job.failed"
+	HookJobKilled    = "job.killed"
+)
+
+// HookEvents 支持订阅的事件
+var HookEvents = []string{HookJobCreated, HookJobStarted, HookJobSucceeded, HookJobFailed, HookJobKilled}
+
+// 投递状态
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// ErrWebhookNotFound webhook不存在
+var ErrWebhookNotFound = errors.New("Webhook Not Found")
+
+// Webhook 事件订阅
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"` // 签名密钥
+	Events    []string `json:"events"`           // 订阅的事件
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"create_time"`
+}
+
+// Subscribed 是否订阅了事件
+func (h Webhook) Subscribed(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery 一次事件投递的记录
+type Delivery struct {
+	ID         string `json:"id"`
+	WebhookID  string `json:"webhook_id"`
+	Event      string `json:"event"`
+	JobID      string `json:"job_id"`
+	Status     string `json:"status"` // pending/succeeded/failed
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"status_code"` // 最后一次请求的返回码
+	Error      string `json:"error"`       // 最后一次请求的错误
+	CreatedAt  string `json:"create_time"`
+	UpdatedAt  string `json:"update_time"`
+}
+
+// WebhookStore webhook及投递记录存储
+type WebhookStore interface {
+	WebhookSave(h Webhook) error
+	WebhookDelete(id string) (Webhook, error) // 不存在时返回ErrWebhookNotFound
+	WebhookLists() ([]Webhook, error)
+	DeliverySave(d Delivery) error
+	DeliveryLists(webhookID string) ([]Delivery, error) // 按创建时间排序
+	// NotifyRevision 通知恢复时开始订阅的任务版本 未保存过时返回0
+	NotifyRevision() (int64, error)
+	NotifyRevisionSave(rev int64) error
+}
+
+// SortDeliveries 按创建时间排序 时间相同时按ID
+func SortDeliveries(ds []Delivery) {
+	sort.SliceStable(ds, func(i, j int) bool {
+		if ds[i].CreatedAt != ds[j].CreatedAt {
+			return ds[i].CreatedAt < ds[j].CreatedAt
+		}
+		return ds[i].ID < ds[j].ID
+	})
+}
@@ -0,0 +1,236 @@
+package agent
+
+/*
+   破解api执行方式
+   从master领取crawl_type=3的任务 直接查询各来源 结果按Report的格式上报
+*/
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+
+	"github.com/MolenZhang/log"
+	"go.uber.org/zap"
+)
+
+// Config agent配置
+type Config struct {
+	PollInterval time.Duration // 没有任务时的轮询间隔
+	MaxRetries   int           // 可重试错误的最大重试次数
+	RetryBackoff time.Duration // 首次重试的等待 每次翻倍
+	Heartbeat    time.Duration // 执行期间的心跳间隔 需小于master的执行期限
+}
+
+// DefaultConfig .
+var DefaultConfig = Config{
+	PollInterval: 10 * time.Second,
+	MaxRetries:   3,
+	RetryBackoff: time.Second,
+	Heartbeat:    time.Minute,
+}
+
+// Agent 破解api的执行器
+type Agent struct {
+	master  *MasterClient
+	clients []SourceClient
+	cfg     Config
+}
+
+// New .
+func New(master *MasterClient, clients []SourceClient, cfg Config) *Agent {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultConfig.PollInterval
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultConfig.RetryBackoff
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = DefaultConfig.Heartbeat
+	}
+	return &Agent{master: master, clients: clients, cfg: cfg}
+}
+
+// Run 循环领取并执行任务 直到ctx结束
+func (a *Agent) Run(ctx context.Context) {
+	for {
+		if !a.RunOnce(ctx) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(a.cfg.PollInterval):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// RunOnce 领取并执行一个任务 没有任务或领取失败时返回false
+func (a *Agent) RunOnce(ctx context.Context) bool {
+	task, err := a.master.Fetch(ctx)
+	if err != nil {
+		log.Error("[Agent] Fetch Task Error", zap.Error(err))
+		return false
+	}
+	if task == nil {
+		return false
+	}
+	log.Infof("[Agent] Job: %v, Phones: %v Fetched", task.JobID, len(task.Phones))
+	// 执行期间定期心跳 任务已不由本设备执行(被回收或强杀)时停止查询
+	runCtx, stop := context.WithCancel(ctx)
+	beating := make(chan struct{})
+	lost := false
+	go func() {
+		defer close(beating)
+		a.heartbeat(runCtx, task.JobID, func() {
+			lost = true
+			stop()
+		})
+	}()
+	report := a.Execute(runCtx, *task)
+	stop()
+	<-beating
+	if lost {
+		return true
+	}
+
+	// 退出时也要上报已有的结果 未上报的号码会被master标记为失败后重跑
+	rctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := a.master.Report(rctx, report); err != nil {
+		log.Error("[Agent] Report Error", zap.Error(err), zap.String("job_id", task.JobID))
+	}
+	return true
+}
+
+// heartbeat 按间隔发送心跳直到ctx结束 master返回不再由本设备执行时调用lost
+// 心跳失败只记录日志 由master的执行期限兜底
+func (a *Agent) heartbeat(ctx context.Context, jobID string, lost func()) {
+	ticker := time.NewTicker(a.cfg.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		assigned, err := a.master.Heartbeat(ctx, jobID)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error("[Agent] Heartbeat Error", zap.Error(err), zap.String("job_id", jobID))
+			}
+			continue
+		}
+		if !assigned {
+			log.Warnf("[Agent] Job: %v Is No Longer Assigned, Stop Executing", jobID)
+			lost()
+			return
+		}
+	}
+}
+
+// sourceRun 单个来源的执行结果
+type sourceRun struct {
+	details []Detail
+	failed  map[ErrorKind]int // 放弃查询的号码数
+	stopped error             // 凭证无效等原因提前停止
+}
+
+// Execute 各来源并行查询 同一来源内按号码顺序查询 频率由来源的限流控制
+// 有号码未能查询或来源提前停止时任务失败 已有的结果照常上报
+func (a *Agent) Execute(ctx context.Context, task Task) Report {
+	runs := make([]sourceRun, len(a.clients))
+	var wg sync.WaitGroup
+	for i, c := range a.clients {
+		wg.Add(1)
+		go func(i int, c SourceClient) {
+			defer wg.Done()
+			runs[i] = a.runSource(ctx, c, task)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{JobID: task.JobID, JobResult: int(common.Successful), JobDetail: []Detail{}}
+	msgs := []string{}
+	for i, run := range runs {
+		report.JobDetail = append(report.JobDetail, run.details...)
+		name := SourceName[a.clients[i].Source()]
+		if run.stopped != nil {
+			msgs = append(msgs, fmt.Sprintf("%v stopped: %v", name, run.stopped))
+		}
+		kinds := []string{}
+		for kind, n := range run.failed {
+			kinds = append(kinds, fmt.Sprintf("%v %v", n, kind))
+		}
+		if len(kinds) != 0 {
+			sort.Strings(kinds)
+			msgs = append(msgs, fmt.Sprintf("%v failed: %v", name, strings.Join(kinds, ", ")))
+		}
+	}
+	if ctx.Err() != nil {
+		msgs = append(msgs, ctx.Err().Error())
+	}
+	if len(msgs) != 0 {
+		report.JobResult = int(common.Failed)
+		report.ErrorMsg = strings.Join(msgs, "; ")
+	}
+	return report
+}
+
+func (a *Agent) runSource(ctx context.Context, c SourceClient, task Task) (run sourceRun) {
+	run.failed = map[ErrorKind]int{}
+	for i, phone := range task.Phones {
+		if ctx.Err() != nil {
+			run.failed[KindTemporary] += len(task.Phones) - i
+			return
+		}
+		result, err := a.query(ctx, c, phone)
+		switch kind := KindOf(err); {
+		case err == nil, kind == KindInvalid:
+			// 号码不被来源接受时按无结果上报 避免反复重跑
+			run.details = append(run.details, Detail{
+				Phone:  phone,
+				Result: result,
+				Type:   int(common.Agent),
+				Source: c.Source(),
+				Batch:  task.Batch,
+			})
+		case kind == KindAuth:
+			log.Error("[Agent] Source Stopped", zap.Error(err), zap.String("job_id", task.JobID))
+			run.stopped = err
+			run.failed[kind] += len(task.Phones) - i
+			return
+		default:
+			log.Error("[Agent] Query Error", zap.Error(err), zap.String("phone", phone))
+			run.failed[kind]++
+		}
+	}
+	return
+}
+
+// query 可重试的错误按退避重试
+func (a *Agent) query(ctx context.Context, c SourceClient, phone string) (string, error) {
+	delay := a.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		result, err := c.Query(ctx, phone)
+		if err == nil || !KindOf(err).Retryable() || attempt >= a.cfg.MaxRetries {
+			return result, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
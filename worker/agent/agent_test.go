@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+	"code.safe.molen.com/molen/haoma/greedy/master/core"
+	"code.safe.molen.com/molen/haoma/greedy/master/routers"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/memory"
+	"code.safe.molen.com/molen/haoma/greedy/master/storage/mongo"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testConfig = Config{PollInterval: 10 * time.Millisecond, MaxRetries: 2, RetryBackoff: time.Millisecond}
+
+func TestSourceClients(t *testing.T) {
+	m360, sogou, dhb := newMock360(t, "k1"), newMockSogou(t, "k2"), newMockDHB(t, "k3", "s3")
+	defer m360.Close()
+	defer sogou.Close()
+	defer dhb.Close()
+	fast := func(url, key, secret string) SourceConfig {
+		return SourceConfig{BaseURL: url, Key: key, Secret: secret, QPS: 1000, Burst: 10}
+	}
+	clients := []SourceClient{
+		NewQihoo360Client(fast(m360.URL, "k1", "")),
+		NewSogouClient(fast(sogou.URL, "k2", "")),
+		NewDHBClient(fast(dhb.URL, "k3", "s3")),
+	}
+	for _, c := range clients {
+		name := SourceName[c.Source()]
+		if res, err := c.Query(context.Background(), phoneHit); err != nil || !strings.Contains(res, `"tag"`) {
+			t.Errorf("%v hit = %q, %v", name, res, err)
+		}
+		if res, err := c.Query(context.Background(), phoneMiss); err != nil || res != "" {
+			t.Errorf("%v miss = %q, %v", name, res, err)
+		}
+		if _, err := c.Query(context.Background(), phoneInvalid); KindOf(err) != KindInvalid {
+			t.Errorf("%v invalid = %v, want invalid", name, err)
+		}
+	}
+
+	// 凭证错误 限流 服务异常的分类
+	bad := []SourceClient{
+		NewQihoo360Client(fast(m360.URL, "wrong", "")),
+		NewSogouClient(fast(sogou.URL, "wrong", "")),
+		NewDHBClient(fast(dhb.URL, "k3", "wrong")),
+	}
+	for _, c := range bad {
+		if _, err := c.Query(context.Background(), phoneHit); KindOf(err) != KindAuth {
+			t.Errorf("%v bad credentials = %v, want auth", SourceName[c.Source()], err)
+		}
+	}
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer limited.Close()
+	if _, err := NewSogouClient(fast(limited.URL, "k2", "")).Query(context.Background(), phoneHit); KindOf(err) != KindRateLimited {
+		t.Errorf("429 = %v, want rate_limited", err)
+	}
+	atomic.StoreInt32(&m360.calls, 0)
+	atomic.StoreInt32(&m360.failFrom, 1)
+	if _, err := clients[0].Query(context.Background(), phoneHit); KindOf(err) != KindTemporary {
+		t.Errorf("503 = %v, want temporary", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	m := newMock360(t, "k1")
+	defer m.Close()
+	c := NewQihoo360Client(SourceConfig{BaseURL: m.URL, Key: "k1", QPS: 20, Burst: 1})
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		c.Query(context.Background(), phoneMiss)
+	}
+	// 20qps 5次请求至少间隔4个周期
+	if d := time.Since(start); d < 180*time.Millisecond {
+		t.Fatalf("5 queries took %v, want rate limited", d)
+	}
+}
+
+// newTestMaster 使用内存存储的master
+func newTestMaster(t *testing.T) (*httptest.Server, *routers.API) {
+	gin.SetMode(gin.TestMode)
+	api := &routers.API{Stores: memory.NewStores()}
+	srv := httptest.NewServer(routers.NewHandler(api))
+	src := api.Stores.Sources.(*memory.SourceStore)
+	for _, p := range []string{phoneHit, phoneMiss, phoneInvalid} {
+		src.Add(mongo.CrawlSource{Phone: p, Batch: "20200218"})
+	}
+	resp, err := http.Post(srv.URL+"/greedy/job/", "application/json",
+		strings.NewReader(`{"name":"api-1","batch":"20200218","count":3,"crawl_type":"3"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	waitAsync(t)
+	return srv, api
+}
+
+func waitAsync(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := routers.WaitAsync(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAgentRunOnce(t *testing.T) {
+	master, api := newTestMaster(t)
+	defer master.Close()
+	m360, sogou, dhb := newMock360(t, "k1"), newMockSogou(t, "k2"), newMockDHB(t, "k3", "s3")
+	defer m360.Close()
+	defer sogou.Close()
+	defer dhb.Close()
+	// 360前两次请求异常 重试后成功
+	atomic.StoreInt32(&m360.failFrom, 2)
+
+	clients := []SourceClient{
+		NewQihoo360Client(SourceConfig{BaseURL: m360.URL, Key: "k1", QPS: 1000}),
+		NewSogouClient(SourceConfig{BaseURL: sogou.URL, Key: "k2", QPS: 1000}),
+		NewDHBClient(SourceConfig{BaseURL: dhb.URL, Key: "k3", Secret: "s3", QPS: 1000}),
+	}
+	a := New(NewMasterClient(master.URL, "agent-1"), clients, testConfig)
+	if !a.RunOnce(context.Background()) {
+		t.Fatal("no task fetched")
+	}
+	waitAsync(t)
+
+	jobs, _ := api.Stores.Jobs.JobLists()
+	if len(jobs) != 1 || jobs[0].Status != int(common.Done) || jobs[0].Result != int(common.Successful) {
+		t.Fatalf("job after report: %+v", jobs)
+	}
+	if h := jobs[0].History; len(h) != 1 || h[0].Device != "agent-1@127.0.0.1" {
+		t.Fatalf("history: %+v", h)
+	}
+	docs, _ := api.Stores.Results.CrawlResultGet(map[string]string{"job_id": jobs[0].ID})
+	// 3个号码 x 3个来源
+	hits := 0
+	for _, d := range docs {
+		if d.Type != int(common.Agent) {
+			t.Fatalf("result type: %+v", d)
+		}
+		if mongo.IsHit(d.Result) {
+			hits++
+		}
+	}
+	if len(docs) != 9 || hits != 3 {
+		t.Fatalf("results = %v, hits = %v", len(docs), hits)
+	}
+	// 任务已完成 不再下发
+	if a.RunOnce(context.Background()) {
+		t.Fatal("finished job fetched again")
+	}
+}
+
+func TestAgentSourceStopped(t *testing.T) {
+	master, api := newTestMaster(t)
+	defer master.Close()
+	m360, sogou := newMock360(t, "k1"), newMockSogou(t, "k2")
+	defer m360.Close()
+	defer sogou.Close()
+
+	clients := []SourceClient{
+		NewQihoo360Client(SourceConfig{BaseURL: m360.URL, Key: "k1", QPS: 1000}),
+		NewSogouClient(SourceConfig{BaseURL: sogou.URL, Key: "expired", QPS: 1000}),
+	}
+	a := New(NewMasterClient(master.URL, "agent-1"), clients, testConfig)
+	if !a.RunOnce(context.Background()) {
+		t.Fatal("no task fetched")
+	}
+	waitAsync(t)
+
+	jobs, _ := api.Stores.Jobs.JobLists()
+	job := jobs[0]
+	if job.Result != int(common.Failed) || len(job.NextEligibleAt) == 0 {
+		t.Fatalf("job after report: %+v", job)
+	}
+	if e := job.History[0].Error; !strings.Contains(e, SourceName[SourceSogou]) || !strings.Contains(e, "auth") {
+		t.Fatalf("error = %q", e)
+	}
+	// 其他来源的结果照常入库
+	docs, _ := api.Stores.Results.CrawlResultGet(map[string]string{"job_id": job.ID})
+	if len(docs) != 3 {
+		t.Fatalf("results = %v", len(docs))
+	}
+}
+
+func TestAgentHeartbeat(t *testing.T) {
+	// 执行期限2秒 查询约需2.7秒 只靠最后的上报会被回收
+	core.SetAttemptTimeout(2 * time.Second)
+	defer core.SetAttemptTimeout(core.DefaultAttemptTimeout)
+	master, api := newTestMaster(t)
+	defer master.Close()
+	m360 := newMock360(t, "k1")
+	defer m360.Close()
+
+	done := make(chan struct{})
+	reaped := make(chan struct{})
+	go func() {
+		defer close(reaped)
+		reaper := core.NewReaper(api.Stores.Jobs, api.ReleaseJobData)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			if jobs, _ := reaper.ReapExpired(time.Now()); len(jobs) != 0 {
+				t.Errorf("running job reaped: %+v", jobs)
+			}
+		}
+	}()
+
+	cfg := testConfig
+	cfg.Heartbeat = 100 * time.Millisecond
+	clients := []SourceClient{NewQihoo360Client(SourceConfig{BaseURL: m360.URL, Key: "k1", QPS: 0.75, Burst: 1})}
+	a := New(NewMasterClient(master.URL, "agent-1"), clients, cfg)
+	if !a.RunOnce(context.Background()) {
+		t.Fatal("no task fetched")
+	}
+	close(done)
+	<-reaped
+	waitAsync(t)
+
+	jobs, _ := api.Stores.Jobs.JobLists()
+	if len(jobs) != 1 || jobs[0].Status != int(common.Done) || jobs[0].Result != int(common.Successful) {
+		t.Fatalf("job after long run: %+v", jobs)
+	}
+}
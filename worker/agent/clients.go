@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+
+	"golang.org/x/time/rate"
+)
+
+// SourceConfig 来源的接入配置
+type SourceConfig struct {
+	BaseURL string
+	Key     string        // appkey
+	Secret  string        // 签名密钥 仅电话邦使用
+	QPS     float64       // 每秒请求数上限
+	Burst   int           // 允许的突发请求数
+	Timeout time.Duration // 单次请求超时
+}
+
+// Info 统一后的号码信息 作为crawl_result上报
+type Info struct {
+	Name  string `json:"name,omitempty"`  // 名称 如商户名
+	Tag   string `json:"tag,omitempty"`   // 标记类型 如骚扰电话/快递送餐
+	Count int    `json:"count,omitempty"` // 标记次数
+}
+
+// result 无任何信息时返回空字符串 与master判断无结果的规则一致
+func (i Info) result() string {
+	if i == (Info{}) {
+		return ""
+	}
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+// httpSource 各来源公共的限流及请求处理
+type httpSource struct {
+	source  int
+	cfg     SourceConfig
+	limiter *rate.Limiter
+	client  *http.Client
+}
+
+func newHTTPSource(source int, cfg SourceConfig) httpSource {
+	if cfg.QPS <= 0 {
+		cfg.QPS = 1
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return httpSource{
+		source:  source,
+		cfg:     cfg,
+		limiter: rate.NewLimiter(rate.Limit(cfg.QPS), cfg.Burst),
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// Source .
+func (s *httpSource) Source() int {
+	return s.source
+}
+
+func (s *httpSource) errorf(kind ErrorKind, code int, format string, args ...interface{}) *SourceError {
+	return &SourceError{Source: s.source, Kind: kind, Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// do 限流后发送请求 非2xx按状态码分类 响应体解析到out
+func (s *httpSource) do(ctx context.Context, req *http.Request, out interface{}) error {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return s.errorf(KindTemporary, 0, "rate limiter: %v", err)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return s.errorf(KindTemporary, 0, "%v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return s.errorf(KindTemporary, resp.StatusCode, "read body: %v", err)
+	}
+	if kind := ClassifyStatus(resp.StatusCode); kind != 0 {
+		return s.errorf(kind, resp.StatusCode, "unexpected status: %s", truncate(body, 128))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return s.errorf(KindTemporary, resp.StatusCode, "decode body: %v", err)
+	}
+	return nil
+}
+
+func truncate(b []byte, n int) string {
+	if len(b) > n {
+		b = b[:n]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Qihoo360Client 360手机卫士
+// GET /v1/mobile/query?phone=&appkey= 返回code 0-成功 1001-无结果 4001-号码非法 4003-appkey无效 4029-超出频率
+type Qihoo360Client struct {
+	httpSource
+}
+
+// NewQihoo360Client .
+func NewQihoo360Client(cfg SourceConfig) *Qihoo360Client {
+	return &Qihoo360Client{newHTTPSource(Source360, cfg)}
+}
+
+// Query .
+func (c *Qihoo360Client) Query(ctx context.Context, phone string) (string, error) {
+	q := url.Values{"phone": {phone}, "appkey": {c.cfg.Key}}
+	req, err := http.NewRequest(http.MethodGet, c.cfg.BaseURL+"/v1/mobile/query?"+q.Encode(), nil)
+	if err != nil {
+		return "", c.errorf(KindInvalid, 0, "%v", err)
+	}
+	body := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Data struct {
+			Name        string `json:"name"`
+			Tag         string `json:"tag"`
+			MarkedCount int    `json:"marked_count"`
+		} `json:"data"`
+	}{}
+	if err := c.do(ctx, req, &body); err != nil {
+		return "", err
+	}
+	switch body.Code {
+	case 0:
+		return Info{Name: body.Data.Name, Tag: body.Data.Tag, Count: body.Data.MarkedCount}.result(), nil
+	case 1001:
+		return "", nil
+	case 4001:
+		return "", c.errorf(KindInvalid, body.Code, "%v", body.Msg)
+	case 4003:
+		return "", c.errorf(KindAuth, body.Code, "%v", body.Msg)
+	case 4029:
+		return "", c.errorf(KindRateLimited, body.Code, "%v", body.Msg)
+	}
+	return "", c.errorf(KindTemporary, body.Code, "%v", body.Msg)
+}
+
+// SogouClient 搜狗号码通
+// GET /hmt/lookup?number=&key= 返回status ok/not_found/error 出错时error为错误类型
+type SogouClient struct {
+	httpSource
+}
+
+// NewSogouClient .
+func NewSogouClient(cfg SourceConfig) *SogouClient {
+	return &SogouClient{newHTTPSource(SourceSogou, cfg)}
+}
+
+// Query .
+func (c *SogouClient) Query(ctx context.Context, phone string) (string, error) {
+	q := url.Values{"number": {phone}, "key": {c.cfg.Key}}
+	req, err := http.NewRequest(http.MethodGet, c.cfg.BaseURL+"/hmt/lookup?"+q.Encode(), nil)
+	if err != nil {
+		return "", c.errorf(KindInvalid, 0, "%v", err)
+	}
+	body := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Result struct {
+			Name   string `json:"name"`
+			Tag    string `json:"tag"`
+			Amount int    `json:"amount"`
+		} `json:"result"`
+	}{}
+	if err := c.do(ctx, req, &body); err != nil {
+		return "", err
+	}
+	switch body.Status {
+	case "ok":
+		return Info{Name: body.Result.Name, Tag: body.Result.Tag, Count: body.Result.Amount}.result(), nil
+	case "not_found":
+		return "", nil
+	}
+	switch body.Error {
+	case "invalid_number":
+		return "", c.errorf(KindInvalid, 0, "%v", body.Error)
+	case "forbidden", "invalid_key":
+		return "", c.errorf(KindAuth, 0, "%v", body.Error)
+	case "too_frequent":
+		return "", c.errorf(KindRateLimited, 0, "%v", body.Error)
+	}
+	return "", c.errorf(KindTemporary, 0, "status %v: %v", body.Status, body.Error)
+}
+
+// DHBClient 电话邦
+// POST /api/number/info 表单tel/apikey/timestamp 按url签名规则签名
+// 返回err_code 0-成功 10001-无结果 10002-号码非法 20001-签名或apikey无效 20002-超出频率
+type DHBClient struct {
+	httpSource
+}
+
+// NewDHBClient .
+func NewDHBClient(cfg SourceConfig) *DHBClient {
+	return &DHBClient{newHTTPSource(SourceDHB, cfg)}
+}
+
+// Query .
+func (c *DHBClient) Query(ctx context.Context, phone string) (string, error) {
+	form := url.Values{
+		"tel":       {phone},
+		"apikey":    {c.cfg.Key},
+		"timestamp": {strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	form.Set("s", common.GenerateSign(form, c.cfg.Secret))
+	req, err := http.NewRequest(http.MethodPost, c.cfg.BaseURL+"/api/number/info", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", c.errorf(KindInvalid, 0, "%v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body := struct {
+		ErrCode int    `json:"err_code"`
+		ErrMsg  string `json:"err_msg"`
+		Data    struct {
+			Name     string `json:"name"`
+			Classify string `json:"classify"`
+			Marked   int    `json:"marked"`
+		} `json:"data"`
+	}{}
+	if err := c.do(ctx, req, &body); err != nil {
+		return "", err
+	}
+	switch body.ErrCode {
+	case 0:
+		return Info{Name: body.Data.Name, Tag: body.Data.Classify, Count: body.Data.Marked}.result(), nil
+	case 10001:
+		return "", nil
+	case 10002:
+		return "", c.errorf(KindInvalid, body.ErrCode, "%v", body.ErrMsg)
+	case 20001:
+		return "", c.errorf(KindAuth, body.ErrCode, "%v", body.ErrMsg)
+	case 20002:
+		return "", c.errorf(KindRateLimited, body.ErrCode, "%v", body.ErrMsg)
+	}
+	return "", c.errorf(KindTemporary, body.ErrCode, "%v", body.ErrMsg)
+}
+
+// NewSourceClient 按来源创建客户端
+func NewSourceClient(source int, cfg SourceConfig) (SourceClient, error) {
+	switch source {
+	case Source360:
+		return NewQihoo360Client(cfg), nil
+	case SourceSogou:
+		return NewSogouClient(cfg), nil
+	case SourceDHB:
+		return NewDHBClient(cfg), nil
+	}
+	return nil, fmt.Errorf("unknown crawl source: %v", source)
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+)
+
+// Task master下发的任务 与/greedy/data/phones的返回一致
+type Task struct {
+	Phones []string `json:"phone"`
+	Batch  string   `json:"batch"`
+	JobID  string   `json:"job_id"`
+}
+
+// Report 上报内容 与/greedy/data/report的请求一致
+type Report struct {
+	JobID     string   `json:"job_id"`
+	JobResult int      `json:"job_result"` // 1-成功 2-失败
+	ErrorMsg  string   `json:"error_msg"`
+	JobDetail []Detail `json:"job_detail"`
+}
+
+// Detail 单个号码在单个来源的结果
+type Detail struct {
+	Phone  string `json:"phone"`
+	Result string `json:"crawl_result"`
+	Type   int    `json:"crawl_type"`
+	Source int    `json:"crawl_source"`
+	Batch  string `json:"crawl_batch"`
+}
+
+// MasterClient 从master领取任务并上报结果
+type MasterClient struct {
+	Addr     string // 如http://127.0.0.1:8585
+	WorkerID string
+	client   *http.Client
+}
+
+// NewMasterClient .
+func NewMasterClient(addr, workerID string) *MasterClient {
+	return &MasterClient{
+		Addr:     addr,
+		WorkerID: workerID,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Fetch 领取一个破解api的任务 没有可执行的任务时返回nil
+func (m *MasterClient) Fetch(ctx context.Context) (*Task, error) {
+	q := url.Values{
+		"act_type":  {common.CrawlTypeAgent},
+		"env_type":  {common.CrawlTypeAgent},
+		"worker_id": {m.WorkerID},
+	}
+	req, err := http.NewRequest(http.MethodGet, m.Addr+"/greedy/data/phones?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	task := &Task{}
+	h, err := m.do(ctx, req, task)
+	if err != nil {
+		return nil, err
+	}
+	// master没有匹配到任务时返回400
+	if h.Status != http.StatusOK || len(task.JobID) == 0 || len(task.Phones) == 0 {
+		return nil, nil
+	}
+	return task, nil
+}
+
+// Report 上报结果
+func (m *MasterClient) Report(ctx context.Context, r Report) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	// master校验上报的设备与领取任务的设备一致
+	q := url.Values{"worker_id": {m.WorkerID}}
+	req, err := http.NewRequest(http.MethodPost, m.Addr+"/greedy/data/report?"+q.Encode(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	h, err := m.do(ctx, req, nil)
+	if err != nil {
+		return err
+	}
+	if h.Status != http.StatusOK {
+		return fmt.Errorf("report job %v: %v %v %v", r.JobID, h.Code, h.Msg, h.Detail)
+	}
+	return nil
+}
+
+// Heartbeat 执行期间的心跳 master据此延长执行期限 返回任务是否仍由该设备执行
+func (m *MasterClient) Heartbeat(ctx context.Context, jobID string) (assigned bool, err error) {
+	q := url.Values{"job_id": {jobID}, "worker_id": {m.WorkerID}}
+	req, err := http.NewRequest(http.MethodPost, m.Addr+"/greedy/data/heartbeat?"+q.Encode(), nil)
+	if err != nil {
+		return
+	}
+	hb := struct {
+		Assigned bool `json:"assigned"`
+	}{}
+	h, err := m.do(ctx, req, &hb)
+	if err != nil {
+		return
+	}
+	if h.Status != http.StatusOK {
+		err = fmt.Errorf("heartbeat job %v: %v %v %v", jobID, h.Code, h.Msg, h.Detail)
+		return
+	}
+	return hb.Assigned, nil
+}
+
+// do 解析master统一的返回格式
+func (m *MasterClient) do(ctx context.Context, req *http.Request, data interface{}) (h common.RespHeader, err error) {
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body := struct {
+		Header *common.RespHeader `json:"responseHeader"`
+		Data   interface{}        `json:"response"`
+	}{Header: &h, Data: data}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		err = fmt.Errorf("decode master response: status %v: %v", resp.StatusCode, err)
+	}
+	return
+}
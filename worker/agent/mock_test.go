@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"code.safe.molen.com/molen/haoma/greedy/master/common"
+)
+
+// 模拟各来源的接口
+// 号码以0000结尾时有结果 9999结尾时号码非法 其余无结果
+const (
+	phoneHit     = "13800000000"
+	phoneMiss    = "13811111111"
+	phoneInvalid = "13899999999"
+)
+
+type mockSource struct {
+	*httptest.Server
+	calls    int32
+	failFrom int32 // 前几次请求返回503
+	handler  func(w http.ResponseWriter, r *http.Request)
+}
+
+func newMockSource(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) *mockSource {
+	m := &mockSource{handler: handler}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&m.calls, 1) <= atomic.LoadInt32(&m.failFrom) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		m.handler(w, r)
+	}))
+	return m
+}
+
+func kindOfPhone(phone string) string {
+	switch phone {
+	case phoneHit:
+		return "hit"
+	case phoneInvalid:
+		return "invalid"
+	}
+	return "miss"
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func newMock360(t *testing.T, key string) *mockSource {
+	return newMockSource(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("appkey") != key {
+			writeJSON(w, map[string]interface{}{"code": 4003, "msg": "invalid appkey"})
+			return
+		}
+		switch kindOfPhone(q.Get("phone")) {
+		case "hit":
+			writeJSON(w, map[string]interface{}{"code": 0, "data": map[string]interface{}{"name": "顺丰速运", "tag": "快递送餐", "marked_count": 12}})
+		case "invalid":
+			writeJSON(w, map[string]interface{}{"code": 4001, "msg": "invalid phone"})
+		default:
+			writeJSON(w, map[string]interface{}{"code": 1001})
+		}
+	})
+}
+
+func newMockSogou(t *testing.T, key string) *mockSource {
+	return newMockSource(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("key") != key {
+			writeJSON(w, map[string]interface{}{"status": "error", "error": "invalid_key"})
+			return
+		}
+		switch kindOfPhone(q.Get("number")) {
+		case "hit":
+			writeJSON(w, map[string]interface{}{"status": "ok", "result": map[string]interface{}{"tag": "骚扰电话", "amount": 30}})
+		case "invalid":
+			writeJSON(w, map[string]interface{}{"status": "error", "error": "invalid_number"})
+		default:
+			writeJSON(w, map[string]interface{}{"status": "not_found"})
+		}
+	})
+}
+
+func newMockDHB(t *testing.T, key, secret string) *mockSource {
+	return newMockSource(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("apikey") != key || common.GenerateSign(r.PostForm, secret) != r.PostForm.Get("s") {
+			writeJSON(w, map[string]interface{}{"err_code": 20001, "err_msg": "invalid sign"})
+			return
+		}
+		switch kindOfPhone(r.PostForm.Get("tel")) {
+		case "hit":
+			writeJSON(w, map[string]interface{}{"err_code": 0, "data": map[string]interface{}{"name": "某银行", "classify": "金融理财", "marked": 5}})
+		case "invalid":
+			writeJSON(w, map[string]interface{}{"err_code": 10002, "err_msg": "invalid tel"})
+		default:
+			writeJSON(w, map[string]interface{}{"err_code": 10001})
+		}
+	})
+}
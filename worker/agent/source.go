@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// 抓取来源 与master中的crawl_source一致
+const (
+	Source360   = 1 // 360手机卫士
+	SourceSogou = 2 // 搜狗号码通
+	SourceDHB   = 3 // 电话邦
+)
+
+// SourceName 来源名称
+var SourceName = map[int]string{
+	Source360:   "360手机卫士",
+	SourceSogou: "搜狗号码通",
+	SourceDHB:   "电话邦",
+}
+
+// SourceClient 直接查询竞品的号码信息
+type SourceClient interface {
+	// Source 来源 1-360手机卫士;2-搜狗号码通;3-电话邦
+	Source() int
+	// Query 查询号码 无结果时返回空字符串 出错时返回*SourceError
+	Query(ctx context.Context, phone string) (string, error)
+}
+
+// ErrorKind 查询错误的分类 决定agent如何处理
+type ErrorKind int
+
+// error kind
+const (
+	KindTemporary   ErrorKind = iota + 1 // 网络错误/超时/5xx 退避后重试
+	KindRateLimited                      // 被来源限流 退避后重试
+	KindAuth                             // 凭证无效或被封禁 本次任务不再使用该来源
+	KindInvalid                          // 号码或请求参数不被接受 跳过该号码
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindTemporary:
+		return "temporary"
+	case KindRateLimited:
+		return "rate_limited"
+	case KindAuth:
+		return "auth"
+	case KindInvalid:
+		return "invalid"
+	}
+	return "unknown"
+}
+
+// Retryable 是否可以重试
+func (k ErrorKind) Retryable() bool {
+	return k == KindTemporary || k == KindRateLimited
+}
+
+// SourceError 来源查询错误
+type SourceError struct {
+	Source int
+	Kind   ErrorKind
+	Code   int // http状态码或来源返回的错误码
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%v: %v(code %v): %v", SourceName[e.Source], e.Kind, e.Code, e.Err)
+}
+
+// KindOf 错误的分类 非SourceError按临时错误处理
+func KindOf(err error) ErrorKind {
+	if e, ok := err.(*SourceError); ok {
+		return e.Kind
+	}
+	return KindTemporary
+}
+
+// ClassifyStatus 按http状态码分类 2xx返回0
+func ClassifyStatus(code int) ErrorKind {
+	switch {
+	case code >= 200 && code < 300:
+		return 0
+	case code == http.StatusTooManyRequests:
+		return KindRateLimited
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return KindAuth
+	case code >= 500 || code == http.StatusRequestTimeout:
+		return KindTemporary
+	}
+	return KindInvalid
+}
@@ -1 +1,105 @@
 package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"code.safe.molen.com/molen/haoma/greedy/worker/agent"
+
+	"github.com/MolenZhang/log"
+	"go.uber.org/zap/zapcore"
+)
+
+func main() {
+	var (
+		master   = flag.String("master", "http://127.0.0.1:8585", "master地址")
+		workerID = flag.String("worker-id", "", "worker标识 默认为主机名")
+		sources  = flag.String("sources", "1,2,3", "查询的来源 1-360手机卫士;2-搜狗号码通;3-电话邦")
+		poll     = flag.Duration("poll", agent.DefaultConfig.PollInterval, "没有任务时的轮询间隔")
+		retries  = flag.Int("retries", agent.DefaultConfig.MaxRetries, "可重试错误的最大重试次数")
+		beat     = flag.Duration("heartbeat", agent.DefaultConfig.Heartbeat, "执行期间的心跳间隔 需小于master的执行期限")
+	)
+	flag.Parse()
+
+	debug := log.Config{
+		Level:              zapcore.DebugLevel,
+		EncodeLogsAsJSON:   false,
+		FileLoggingEnabled: true,
+		StdLoggingDisabled: true,
+		MaxSize:            100000,
+		MaxBackups:         3,
+		MaxAge:             7,
+		IsAddCaller:        true,
+		CallerSkip:         1,
+		Directory:          "./log/",
+		Filename:           "greedy-worker.log",
+	}
+	debug.Init()
+
+	if len(*workerID) == 0 {
+		hostname, _ := os.Hostname()
+		*workerID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	// 各来源的接入配置从环境变量读取 如GREEDY_SOURCE_1_URL/KEY/SECRET/QPS
+	clients := []agent.SourceClient{}
+	for _, s := range strings.Split(*sources, ",") {
+		source, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			fatal("Invalid source: %v", s)
+		}
+		client, err := agent.NewSourceClient(source, sourceConfig(source))
+		if err != nil {
+			fatal("Init Source Client error: %v", err)
+		}
+		clients = append(clients, client)
+	}
+
+	a := agent.New(agent.NewMasterClient(*master, *workerID), clients, agent.Config{
+		PollInterval: *poll,
+		MaxRetries:   *retries,
+		Heartbeat:    *beat,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Info("shutting down ...")
+		cancel()
+	}()
+
+	log.Infof("worker %v started, master: %v, sources: %v", *workerID, *master, *sources)
+	a.Run(ctx)
+	log.Info("Shutdown byte")
+}
+
+// sourceConfig 读取来源的环境变量
+func sourceConfig(source int) agent.SourceConfig {
+	env := func(name string) string {
+		return os.Getenv(fmt.Sprintf("GREEDY_SOURCE_%d_%s", source, name))
+	}
+	cfg := agent.SourceConfig{
+		BaseURL: env("URL"),
+		Key:     env("KEY"),
+		Secret:  env("SECRET"),
+		Timeout: 10 * time.Second,
+	}
+	cfg.QPS, _ = strconv.ParseFloat(env("QPS"), 64)
+	cfg.Burst, _ = strconv.Atoi(env("BURST"))
+	return cfg
+}
+
+// fatal 启动失败 日志仅写文件 同时输出到标准错误便于排查
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	log.Fatalf(format, args...)
+}